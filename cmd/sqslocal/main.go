@@ -0,0 +1,54 @@
+// cmd/sqslocal/main.go
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"go-sqs-worker/internal/sqslocal"
+)
+
+func main() {
+	addr := flag.String("addr", ":9324", "listen address; the default matches ElasticMQ's")
+	queues := flag.String("queues", "local-sqs-worker", "comma-separated queues to create on startup")
+	flag.Parse()
+
+	srv, err := sqslocal.Start(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listen error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, name := range strings.Split(*queues, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		out, err := srv.Fake.CreateQueue(context.Background(), &sqs.CreateQueueInput{QueueName: &name, Attributes: queueAttributes(name)})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create queue %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("queue ready: %s\n", *out.QueueUrl)
+	}
+	fmt.Printf("sqslocal listening on %s\n", srv.URL)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	_ = srv.Close()
+}
+
+// queueAttributes marks names ending in .fifo as FIFO queues.
+func queueAttributes(name string) map[string]string {
+	if strings.HasSuffix(name, ".fifo") {
+		return map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"}
+	}
+	return nil
+}
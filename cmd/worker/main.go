@@ -3,15 +3,22 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite"
 
 	"go-sqs-worker/internal/config"
 	"go-sqs-worker/internal/worker"
@@ -24,12 +31,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Printf("config ok: region=%s endpoint=%s queue=%s concurrency=%d\n",
-		cfg.AWSRegion, cfg.SQSEndpoint, cfg.QueueURL, cfg.Concurrency)
+	fmt.Printf("config ok: backend=%s region=%s endpoint=%s queue=%s concurrency=%d\n",
+		cfg.QueueBackend, cfg.AWSRegion, cfg.SQSEndpoint, cfg.QueueURL, cfg.Concurrency)
 
 	ctx := context.Background()
-	client := newSQSClient(ctx, cfg)
-	poller := worker.NewPoller(client, cfg.QueueURL)
 
 	handler := func(ctx context.Context, msg *worker.Message) error {
 		fmt.Printf("processing: id=%s body=%s\n", msg.MessageID, msg.Body)
@@ -37,13 +42,76 @@ func main() {
 	}
 
 	// Redis setup
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: cfg.RedisAddr,
-	})
-	defer redisClient.Close()
+	var redisClient redis.UniversalClient
+	if cfg.RedisAddr != "" {
+		redisClient, err = worker.NewRedisClient(redisOptions(cfg))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "redis error: %v\n", err)
+			os.Exit(1)
+		}
+		defer redisClient.Close()
+	}
+
+	source, err := newSource(ctx, cfg, redisClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "source error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.FileQueueForward {
+		// Drain the local queue into SQS instead of handling messages here
+		fwd := worker.NewForwarder(source, newSQSClient(ctx, cfg), cfg.QueueURL)
+		if err := fwd.Run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	leaseStore, err := newLeaseStore(ctx, cfg, redisClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lease store error: %v\n", err)
+		os.Exit(1)
+	}
+
+	runner := worker.NewRunner(source, handler, cfg.MaxInFlight, cfg.Concurrency).
+		WithLeaseStore(leaseStore, time.Duration(cfg.LeaseTTL)*time.Second).
+		WithLeaseKey(leaseKeyFunc(cfg.LeaseKey)).
+		WithContentionPolicy(contentionPolicy(cfg.LeaseContention)).
+		WithLeaseFailover(worker.LeaseFailover{Policy: leaseFailurePolicy(cfg.LeaseFailurePolicy)})
 
-	runner := worker.NewRunner(poller, handler, cfg.MaxInFlight, cfg.Concurrency).
-		WithLeaseStore(worker.NewRedisLeaseStore(redisClient), time.Duration(cfg.LeaseTTL)*time.Second)
+	// Only applies to sources that don't long poll, such as postgres
+	runner.WithIdleBackoff(1, 100*time.Millisecond, 5*time.Second)
+
+	if cfg.QueueBackend != "sqs" {
+		// Keep long handlers' messages from being redelivered mid-run
+		runner.WithVisibilityExtension(time.Duration(cfg.VisibilityTimeout) * time.Second)
+	}
+
+	if cfg.DedupeWindow > 0 {
+		runner.WithDuplicateSuppression(time.Duration(cfg.DedupeWindow)*time.Second, cfg.DedupeCapacity)
+	}
+
+	if cfg.IdempotencyRetention > 0 {
+		runner.WithIdempotency(time.Duration(cfg.IdempotencyRetention) * time.Second)
+	}
+
+	if cfg.SharedRateLimit > 0 {
+		limiter, err := worker.NewRedisRateLimiter(redisClient, cfg.SharedRateLimit, cfg.SharedRateBurst)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rate limiter error: %v\n", err)
+			os.Exit(1)
+		}
+		runner.WithSharedRateLimit(limiter, cfg.RateLimitKeyAttribute)
+	}
+
+	pollInterval := time.Duration(cfg.PausePollInterval) * time.Second
+	switch {
+	case cfg.PauseFile != "":
+		runner.WithPauseSwitch(worker.NewFilePauseSwitch(cfg.PauseFile), pollInterval)
+	case cfg.PauseRedisKey != "":
+		runner.WithPauseSwitch(worker.NewRedisPauseSwitch(redisClient, cfg.PauseRedisKey), pollInterval)
+	}
 
 	if err := runner.Run(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -51,7 +119,203 @@ func main() {
 	}
 }
 
-func newSQSClient(ctx context.Context, cfg config.Config) worker.SQSClient {
+// newSource builds the message source selected by QUEUE_BACKEND. The
+// postgres job table and the file queue directory are created if missing,
+// and the http backend starts listening.
+func newSource(ctx context.Context, cfg config.Config, redisClient redis.UniversalClient) (worker.Source, error) {
+	visibility := time.Duration(cfg.VisibilityTimeout) * time.Second
+
+	switch cfg.QueueBackend {
+	case "redis-streams":
+		consumer := cfg.StreamConsumer
+		if consumer == "" {
+			host, err := os.Hostname()
+			if err != nil {
+				return nil, err
+			}
+			consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		source := worker.NewRedisStreamSource(redisClient, cfg.StreamKey, cfg.StreamGroup, consumer).
+			WithVisibilityTimeout(visibility)
+		if cfg.StreamDeadLetterKey != "" {
+			source.WithDeadLetter(cfg.StreamDeadLetterKey, cfg.StreamMaxDeliveries)
+		}
+		if err := source.EnsureGroup(ctx); err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "file":
+		queue, err := worker.OpenFileQueue(cfg.FileQueueDir)
+		if err != nil {
+			return nil, err
+		}
+		queue.WithVisibilityTimeout(visibility)
+		if cfg.FileQueueMaxReceives > 0 {
+			dlq, err := worker.OpenFileQueue(filepath.Join(cfg.FileQueueDir, "dead-letter"))
+			if err != nil {
+				return nil, err
+			}
+			queue.WithDeadLetter(dlq, cfg.FileQueueMaxReceives)
+		}
+		return queue, nil
+	case "http":
+		source := worker.NewHTTPSource(cfg.MaxInFlight).
+			WithResponseTimeout(time.Duration(cfg.HTTPResponseTimeout) * time.Second).
+			WithIDHeader(cfg.HTTPIDHeader)
+		if len(cfg.HTTPSNSTopicARNs) > 0 {
+			source.WithTopicARNs(cfg.HTTPSNSTopicARNs...)
+		}
+		ln, err := net.Listen("tcp", cfg.HTTPListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		srv := &http.Server{Handler: source, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "http source error: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+		fmt.Printf("http source listening on %s\n", ln.Addr())
+		return source, nil
+	case "postgres":
+		db, err := sql.Open("pgx", cfg.QueueDSN)
+		if err != nil {
+			return nil, err
+		}
+		source := worker.NewPostgresSource(db).
+			WithTable(cfg.QueueTable).
+			WithVisibilityTimeout(visibility)
+		if cfg.QueueAckMode == "mark-done" {
+			source.WithAckMode(worker.AckMarkDone)
+		}
+		if err := source.Migrate(ctx); err != nil {
+			return nil, err
+		}
+		return source, nil
+	default:
+		return worker.NewPoller(newSQSClient(ctx, cfg), cfg.QueueURL), nil
+	}
+}
+
+// newLeaseStore builds the store selected by LEASE_BACKEND. SQL tables are
+// created if missing.
+func newLeaseStore(ctx context.Context, cfg config.Config, redisClient redis.UniversalClient) (worker.LeaseStore, error) {
+	ttl := time.Duration(cfg.LeaseTTL) * time.Second
+
+	switch cfg.LeaseBackend {
+	case "memory":
+		store := worker.NewMemoryLeaseStore()
+		go store.RunJanitor(ctx, ttl)
+		return store, nil
+
+	case "redlock":
+		var nodes []redis.UniversalClient
+		for _, addr := range cfg.RedlockAddrs {
+			node, err := worker.NewRedisClient(worker.RedisOptions{
+				Addrs:     []string{addr},
+				Username:  cfg.RedisUsername,
+				Password:  cfg.RedisPassword,
+				PoolSize:  cfg.RedisPoolSize,
+				TLS:       cfg.RedisTLS,
+				TLSCAFile: cfg.RedisTLSCAFile,
+			})
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+		store, err := worker.NewRedlockLeaseStore(nodes)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.RedisKeyPrefix != "" {
+			store.
+				WithKeyPrefix(cfg.RedisKeyPrefix + "lease:").
+				WithCompletionPrefix(cfg.RedisKeyPrefix + "done:")
+		}
+		return store, nil
+
+	case "postgres", "sqlite":
+		driver, dialect := "pgx", worker.DialectPostgres
+		if cfg.LeaseBackend == "sqlite" {
+			driver, dialect = "sqlite", worker.DialectSQLite
+		}
+		db, err := sql.Open(driver, cfg.LeaseDSN)
+		if err != nil {
+			return nil, err
+		}
+		if dialect == worker.DialectSQLite {
+			db.SetMaxOpenConns(1)
+		}
+		store := worker.NewSQLLeaseStore(db, dialect)
+		if err := store.Migrate(ctx); err != nil {
+			return nil, err
+		}
+		go store.RunJanitor(ctx, ttl)
+		return store, nil
+	}
+
+	store := worker.NewRedisLeaseStore(redisClient).WithHashTags(redisOptions(cfg).Cluster())
+	if cfg.RedisKeyPrefix != "" {
+		store.
+			WithKeyPrefix(cfg.RedisKeyPrefix + "lease:").
+			WithCompletionPrefix(cfg.RedisKeyPrefix + "done:").
+			WithFenceKey(cfg.RedisKeyPrefix + "lease-fence")
+	}
+	return store, nil
+}
+
+// redisOptions builds the Redis client options from the REDIS_* settings.
+func redisOptions(cfg config.Config) worker.RedisOptions {
+	return worker.RedisOptions{
+		Addrs:       strings.Split(cfg.RedisAddr, ","),
+		Username:    cfg.RedisUsername,
+		Password:    cfg.RedisPassword,
+		DB:          cfg.RedisDB,
+		MasterName:  cfg.RedisSentinelMaster,
+		PoolSize:    cfg.RedisPoolSize,
+		ClusterMode: cfg.RedisCluster,
+		TLS:         cfg.RedisTLS,
+		TLSCAFile:   cfg.RedisTLSCAFile,
+	}
+}
+
+// leaseKeyFunc maps a validated LEASE_KEY value to its extractor.
+func leaseKeyFunc(spec string) worker.KeyFunc {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "sha256":
+		return worker.BodySHA256Key
+	case "attribute":
+		return worker.AttributeKey(arg)
+	case "json":
+		return worker.JSONPathKey(arg)
+	}
+	return worker.MessageIDKey
+}
+
+func contentionPolicy(name string) worker.ContentionPolicy {
+	switch name {
+	case "release":
+		return worker.ContentionRelease
+	case "delete-duplicate":
+		return worker.ContentionDeleteDuplicate
+	}
+	return worker.ContentionDelay
+}
+
+func leaseFailurePolicy(name string) worker.LeaseFailurePolicy {
+	switch name {
+	case "open":
+		return worker.LeaseFailOpen
+	case "local":
+		return worker.LeaseFailLocal
+	}
+	return worker.LeaseFailClosed
+}
+
+func newSQSClient(ctx context.Context, cfg config.Config) *sqs.Client {
 	opts := []func(*awsconfig.LoadOptions) error{
 		awsconfig.WithRegion(cfg.AWSRegion),
 	}
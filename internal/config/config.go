@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type EnvReader interface {
@@ -19,6 +20,41 @@ func (OSEnv) Getenv(key string) string {
 }
 
 type Config struct {
+	// QueueBackend selects where messages come from: "sqs" (default),
+	// "redis-streams", "postgres", "file" or "http". SQS_QUEUE_URL is only
+	// required for "sqs" or when forwarding a file queue.
+	QueueBackend string
+	// VisibilityTimeout is how long, in seconds, a message received from a
+	// backend other than SQS may go unacknowledged before it is delivered
+	// again. SQS uses the queue's own setting.
+	VisibilityTimeout int
+
+	// FileQueueDir holds the file backend's log. A message received more
+	// than FileQueueMaxReceives times moves to the dead-letter queue in its
+	// "dead-letter" subdirectory; 0 keeps retrying it. With
+	// FileQueueForward the worker forwards the queue to SQS_QUEUE_URL
+	// instead of handling messages itself.
+	FileQueueDir         string
+	FileQueueMaxReceives int
+	FileQueueForward     bool
+
+	// HTTPListenAddr is where the http backend accepts pushed messages, at
+	// most MaxInFlight requests at a time. Each request is answered within
+	// HTTPResponseTimeout seconds. HTTPIDHeader optionally names the header
+	// carrying webhook message IDs; HTTPSNSTopicARNs restricts which SNS
+	// topics are accepted.
+	HTTPListenAddr      string
+	HTTPResponseTimeout int
+	HTTPIDHeader        string
+	HTTPSNSTopicARNs    []string
+
+	// QueueDSN is the database connection string of the postgres backend,
+	// which reads jobs from QueueTable. QueueAckMode is "delete" (default)
+	// or "mark-done".
+	QueueDSN     string
+	QueueTable   string
+	QueueAckMode string
+
 	AWSRegion    string
 	SQSEndpoint  string
 	QueueURL     string
@@ -26,14 +62,155 @@ type Config struct {
 	AWSSecretKey string
 	Concurrency  int
 	MaxInFlight  int
-	RedisAddr    string
-	LeaseTTL     int
+	// RedisAddr may list several comma-separated addresses for a cluster
+	// or, with RedisSentinelMaster, the sentinels.
+	RedisAddr string
+	LeaseTTL  int
+
+	// StreamKey is the Redis stream read by the redis-streams backend, as
+	// StreamConsumer within StreamGroup. StreamConsumer defaults to one
+	// derived from the host name and must be unique per process.
+	StreamKey      string
+	StreamGroup    string
+	StreamConsumer string
+	// StreamDeadLetterKey receives entries delivered more than
+	// StreamMaxDeliveries times; empty keeps retrying them.
+	StreamDeadLetterKey string
+	StreamMaxDeliveries int
+
+	// LeaseBackend selects the lease store: "redis" (default), "redlock",
+	// "memory", "postgres" or "sqlite". REDIS_ADDR is only required for "redis" or
+	// when a Redis-backed feature is enabled.
+	LeaseBackend string
+	// LeaseDSN is the database connection string for the SQL backends.
+	LeaseDSN string
+	// RedlockAddrs are the independent Redis nodes of the redlock backend.
+	// They share the REDIS_USERNAME, REDIS_PASSWORD and TLS settings.
+	RedlockAddrs []string
+
+	RedisUsername       string
+	RedisPassword       string
+	RedisDB             int
+	RedisTLS            bool
+	RedisTLSCAFile      string
+	RedisPoolSize       int
+	RedisSentinelMaster string
+	// RedisCluster treats a single RedisAddr as a cluster configuration
+	// endpoint.
+	RedisCluster bool
+	// RedisKeyPrefix namespaces lease and completion keys (e.g. "tenant-a:")
+	// when several workers share one Redis.
+	RedisKeyPrefix string
+
+	// LeaseKey selects the lease/idempotency key: "message-id" (default),
+	// "sha256", "attribute:<name>" or "json:<path>".
+	LeaseKey string
+	// LeaseContention is what to do with a message whose lease is held
+	// elsewhere: "delay" (default), "release" or "delete-duplicate".
+	LeaseContention string
+	// LeaseFailurePolicy is how messages are handled while the lease store
+	// is unavailable: "closed" (default), "open" or "local".
+	LeaseFailurePolicy string
+	// DedupeWindow is how long, in seconds, message IDs completed on this
+	// worker are remembered to suppress duplicates in memory; 0 disables it.
+	// DedupeCapacity caps how many are kept.
+	DedupeWindow   int
+	DedupeCapacity int
+	// IdempotencyRetention is how long, in seconds, completion records are
+	// kept; 0 disables duplicate skipping.
+	IdempotencyRetention int
+
+	// SharedRateLimit is the fleet-wide messages per second; 0 disables it.
+	SharedRateLimit       float64
+	SharedRateBurst       int
+	RateLimitKeyAttribute string
+
+	// PauseFile and PauseRedisKey are fleet-wide pause toggles; at most one
+	// may be set. PausePollInterval is in seconds.
+	PauseFile         string
+	PauseRedisKey     string
+	PausePollInterval int
 }
 
 func Load(env EnvReader) (Config, error) {
+	queueBackend := getenv(env, "QUEUE_BACKEND", "sqs")
 	queueURL := env.Getenv("SQS_QUEUE_URL")
-	if queueURL == "" {
-		return Config{}, errors.New("SQS_QUEUE_URL is required")
+	streamKey := env.Getenv("STREAM_KEY")
+	queueDSN := env.Getenv("QUEUE_DSN")
+	fileQueueDir := env.Getenv("FILE_QUEUE_DIR")
+	fileQueueForward, err := getenvBool(env, "FILE_QUEUE_FORWARD", false)
+	if err != nil {
+		return Config{}, err
+	}
+	switch queueBackend {
+	case "sqs":
+		if queueURL == "" {
+			return Config{}, errors.New("SQS_QUEUE_URL is required")
+		}
+	case "redis-streams":
+		if streamKey == "" {
+			return Config{}, errors.New("STREAM_KEY is required for QUEUE_BACKEND=redis-streams")
+		}
+	case "postgres":
+		if queueDSN == "" {
+			return Config{}, errors.New("QUEUE_DSN is required for QUEUE_BACKEND=postgres")
+		}
+	case "http":
+	case "file":
+		if fileQueueDir == "" {
+			return Config{}, errors.New("FILE_QUEUE_DIR is required for QUEUE_BACKEND=file")
+		}
+		if fileQueueForward && queueURL == "" {
+			return Config{}, errors.New("SQS_QUEUE_URL is required for FILE_QUEUE_FORWARD")
+		}
+	default:
+		return Config{}, fmt.Errorf("QUEUE_BACKEND must be sqs, redis-streams, postgres, file or http, got %q", queueBackend)
+	}
+
+	fileQueueMaxReceives, err := getenvInt(env, "FILE_QUEUE_MAX_RECEIVES", 5)
+	if err != nil {
+		return Config{}, err
+	}
+	if fileQueueMaxReceives < 0 {
+		return Config{}, errors.New("FILE_QUEUE_MAX_RECEIVES must be >= 0")
+	}
+
+	httpResponseTimeout, err := getenvInt(env, "HTTP_RESPONSE_TIMEOUT", 15)
+	if err != nil {
+		return Config{}, err
+	}
+	if httpResponseTimeout <= 0 {
+		return Config{}, errors.New("HTTP_RESPONSE_TIMEOUT must be > 0")
+	}
+	var httpSNSTopicARNs []string
+	for _, arn := range strings.Split(env.Getenv("HTTP_SNS_TOPIC_ARNS"), ",") {
+		if arn = strings.TrimSpace(arn); arn != "" {
+			httpSNSTopicARNs = append(httpSNSTopicARNs, arn)
+		}
+	}
+
+	visibilityTimeout, err := getenvInt(env, "VISIBILITY_TIMEOUT", 30)
+	if err != nil {
+		return Config{}, err
+	}
+	if visibilityTimeout <= 0 {
+		return Config{}, errors.New("VISIBILITY_TIMEOUT must be > 0")
+	}
+
+	queueAckMode := getenv(env, "QUEUE_ACK_MODE", "delete")
+	switch queueAckMode {
+	case "delete", "mark-done":
+	default:
+		return Config{}, fmt.Errorf("QUEUE_ACK_MODE must be delete or mark-done, got %q", queueAckMode)
+	}
+
+	streamDeadLetterKey := env.Getenv("STREAM_DEAD_LETTER_KEY")
+	streamMaxDeliveries, err := getenvInt(env, "STREAM_MAX_DELIVERIES", 5)
+	if err != nil {
+		return Config{}, err
+	}
+	if streamMaxDeliveries <= 0 {
+		return Config{}, errors.New("STREAM_MAX_DELIVERIES must be > 0")
 	}
 
 	concurrency, err := getenvInt(env, "WORKER_CONCURRENCY", 4)
@@ -52,11 +229,59 @@ func Load(env EnvReader) (Config, error) {
 		return Config{}, errors.New("MAX_IN_FLIGHT must be > 0")
 	}
 
+	leaseBackend := getenv(env, "LEASE_BACKEND", "redis")
+	leaseDSN := env.Getenv("LEASE_DSN")
+	var redlockAddrs []string
+	for _, addr := range strings.Split(env.Getenv("REDLOCK_ADDRS"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			redlockAddrs = append(redlockAddrs, addr)
+		}
+	}
+	switch leaseBackend {
+	case "redis", "memory":
+	case "redlock":
+		if len(redlockAddrs) == 0 {
+			return Config{}, errors.New("REDLOCK_ADDRS is required for LEASE_BACKEND=redlock")
+		}
+	case "postgres", "sqlite":
+		if leaseDSN == "" {
+			return Config{}, fmt.Errorf("LEASE_DSN is required for LEASE_BACKEND=%s", leaseBackend)
+		}
+	default:
+		return Config{}, fmt.Errorf("LEASE_BACKEND must be redis, redlock, memory, postgres or sqlite, got %q", leaseBackend)
+	}
+
 	redisAddr := env.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
+	if redisAddr == "" && leaseBackend == "redis" {
 		return Config{}, errors.New("REDIS_ADDR is required")
 	}
 
+	redisDB, err := getenvInt(env, "REDIS_DB", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if redisDB < 0 {
+		return Config{}, errors.New("REDIS_DB must be >= 0")
+	}
+
+	redisTLS, err := getenvBool(env, "REDIS_TLS", false)
+	if err != nil {
+		return Config{}, err
+	}
+
+	redisCluster, err := getenvBool(env, "REDIS_CLUSTER", false)
+	if err != nil {
+		return Config{}, err
+	}
+
+	redisPoolSize, err := getenvInt(env, "REDIS_POOL_SIZE", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if redisPoolSize < 0 {
+		return Config{}, errors.New("REDIS_POOL_SIZE must be >= 0")
+	}
+
 	leaseTTL, err := getenvInt(env, "LEASE_TTL", 45)
 	if err != nil {
 		return Config{}, err
@@ -65,12 +290,112 @@ func Load(env EnvReader) (Config, error) {
 		return Config{}, errors.New("LEASE_TTL must be > 0")
 	}
 
+	leaseKey := getenv(env, "LEASE_KEY", "message-id")
+	if err := validateLeaseKey(leaseKey); err != nil {
+		return Config{}, err
+	}
+
+	leaseContention := getenv(env, "LEASE_CONTENTION", "delay")
+	switch leaseContention {
+	case "delay", "release", "delete-duplicate":
+	default:
+		return Config{}, fmt.Errorf("LEASE_CONTENTION must be delay, release or delete-duplicate, got %q", leaseContention)
+	}
+
+	leaseFailurePolicy := getenv(env, "LEASE_FAILURE_POLICY", "closed")
+	switch leaseFailurePolicy {
+	case "closed", "open", "local":
+	default:
+		return Config{}, fmt.Errorf("LEASE_FAILURE_POLICY must be closed, open or local, got %q", leaseFailurePolicy)
+	}
+
+	dedupeWindow, err := getenvInt(env, "DEDUPE_WINDOW", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if dedupeWindow < 0 {
+		return Config{}, errors.New("DEDUPE_WINDOW must be >= 0")
+	}
+
+	dedupeCapacity, err := getenvInt(env, "DEDUPE_CAPACITY", 10000)
+	if err != nil {
+		return Config{}, err
+	}
+	if dedupeCapacity <= 0 {
+		return Config{}, errors.New("DEDUPE_CAPACITY must be > 0")
+	}
+
+	idempotencyRetention, err := getenvInt(env, "IDEMPOTENCY_RETENTION", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if idempotencyRetention < 0 {
+		return Config{}, errors.New("IDEMPOTENCY_RETENTION must be >= 0")
+	}
+
+	sharedRateLimit, err := getenvFloat(env, "SHARED_RATE_LIMIT", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if sharedRateLimit < 0 {
+		return Config{}, errors.New("SHARED_RATE_LIMIT must be >= 0")
+	}
+
+	sharedRateBurst, err := getenvInt(env, "SHARED_RATE_BURST", 1)
+	if err != nil {
+		return Config{}, err
+	}
+	if sharedRateBurst <= 0 {
+		return Config{}, errors.New("SHARED_RATE_BURST must be > 0")
+	}
+
+	pauseFile := env.Getenv("PAUSE_FILE")
+	pauseRedisKey := env.Getenv("PAUSE_REDIS_KEY")
+	if pauseFile != "" && pauseRedisKey != "" {
+		return Config{}, errors.New("set only one of PAUSE_FILE and PAUSE_REDIS_KEY")
+	}
+
+	pausePollInterval, err := getenvInt(env, "PAUSE_POLL_INTERVAL", 5)
+	if err != nil {
+		return Config{}, err
+	}
+	if pausePollInterval <= 0 {
+		return Config{}, errors.New("PAUSE_POLL_INTERVAL must be > 0")
+	}
+
+	if redisAddr == "" {
+		switch {
+		case sharedRateLimit > 0:
+			return Config{}, errors.New("REDIS_ADDR is required for SHARED_RATE_LIMIT")
+		case pauseRedisKey != "":
+			return Config{}, errors.New("REDIS_ADDR is required for PAUSE_REDIS_KEY")
+		case queueBackend == "redis-streams":
+			return Config{}, errors.New("REDIS_ADDR is required for QUEUE_BACKEND=redis-streams")
+		}
+	}
+
 	region := getenv(env, "AWS_REGION", "us-east-1")
 	endpoint := env.Getenv("SQS_ENDPOINT")
 	accessKey := getenv(env, "AWS_ACCESS_KEY_ID", "dummy")
 	secretKey := getenv(env, "AWS_SECRET_ACCESS_KEY", "dummy")
 
 	return Config{
+		QueueBackend:      queueBackend,
+		VisibilityTimeout: visibilityTimeout,
+
+		QueueDSN:     queueDSN,
+		QueueTable:   getenv(env, "QUEUE_TABLE", "worker_jobs"),
+		QueueAckMode: queueAckMode,
+
+		FileQueueDir:         fileQueueDir,
+		FileQueueMaxReceives: fileQueueMaxReceives,
+		FileQueueForward:     fileQueueForward,
+
+		HTTPListenAddr:      getenv(env, "HTTP_LISTEN_ADDR", ":8080"),
+		HTTPResponseTimeout: httpResponseTimeout,
+		HTTPIDHeader:        env.Getenv("HTTP_ID_HEADER"),
+		HTTPSNSTopicARNs:    httpSNSTopicARNs,
+
 		AWSRegion:    region,
 		SQSEndpoint:  endpoint,
 		QueueURL:     queueURL,
@@ -80,9 +405,56 @@ func Load(env EnvReader) (Config, error) {
 		MaxInFlight:  maxInFlight,
 		RedisAddr:    redisAddr,
 		LeaseTTL:     leaseTTL,
+
+		StreamKey:           streamKey,
+		StreamGroup:         getenv(env, "STREAM_GROUP", "workers"),
+		StreamConsumer:      env.Getenv("STREAM_CONSUMER"),
+		StreamDeadLetterKey: streamDeadLetterKey,
+		StreamMaxDeliveries: streamMaxDeliveries,
+
+		LeaseBackend: leaseBackend,
+		LeaseDSN:     leaseDSN,
+		RedlockAddrs: redlockAddrs,
+
+		RedisUsername:       env.Getenv("REDIS_USERNAME"),
+		RedisPassword:       env.Getenv("REDIS_PASSWORD"),
+		RedisDB:             redisDB,
+		RedisTLS:            redisTLS,
+		RedisTLSCAFile:      env.Getenv("REDIS_TLS_CA_FILE"),
+		RedisPoolSize:       redisPoolSize,
+		RedisSentinelMaster: env.Getenv("REDIS_SENTINEL_MASTER"),
+		RedisCluster:        redisCluster,
+		RedisKeyPrefix:      env.Getenv("REDIS_KEY_PREFIX"),
+
+		LeaseKey:             leaseKey,
+		LeaseContention:      leaseContention,
+		LeaseFailurePolicy:   leaseFailurePolicy,
+		DedupeWindow:         dedupeWindow,
+		DedupeCapacity:       dedupeCapacity,
+		IdempotencyRetention: idempotencyRetention,
+
+		SharedRateLimit:       sharedRateLimit,
+		SharedRateBurst:       sharedRateBurst,
+		RateLimitKeyAttribute: env.Getenv("RATE_LIMIT_KEY_ATTRIBUTE"),
+
+		PauseFile:         pauseFile,
+		PauseRedisKey:     pauseRedisKey,
+		PausePollInterval: pausePollInterval,
 	}, nil
 }
 
+func validateLeaseKey(v string) error {
+	switch {
+	case v == "message-id", v == "sha256":
+		return nil
+	case strings.HasPrefix(v, "attribute:") && len(v) > len("attribute:"):
+		return nil
+	case strings.HasPrefix(v, "json:") && len(v) > len("json:"):
+		return nil
+	}
+	return fmt.Errorf("LEASE_KEY must be message-id, sha256, attribute:<name> or json:<path>, got %q", v)
+}
+
 func getenv(env EnvReader, key, def string) string {
 	v := env.Getenv(key)
 	if v == "" {
@@ -102,3 +474,27 @@ func getenvInt(env EnvReader, key string, def int) (int, error) {
 	}
 	return n, nil
 }
+
+func getenvBool(env EnvReader, key string, def bool) (bool, error) {
+	v := getenv(env, key, "")
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", key, v)
+	}
+	return b, nil
+}
+
+func getenvFloat(env EnvReader, key string, def float64) (float64, error) {
+	v := getenv(env, key, "")
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, got %q", key, v)
+	}
+	return f, nil
+}
@@ -160,3 +160,497 @@ func TestLoad_MissingRedisAddressFails(t *testing.T) {
 		t.Fatal("expected error, got nil")
 	}
 }
+
+func TestLoad_LeaseBackend(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"REDIS_ADDR":    "localhost:6379",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LeaseBackend != "redis" {
+		t.Fatalf("expected default LeaseBackend redis, got %q", cfg.LeaseBackend)
+	}
+
+	// Redis is optional with another backend
+	env = fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"LEASE_BACKEND": "postgres",
+		"LEASE_DSN":     "postgres://localhost/app",
+	}
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LeaseBackend != "postgres" || cfg.LeaseDSN != "postgres://localhost/app" || cfg.RedisAddr != "" {
+		t.Fatalf("unexpected lease config: %+v", cfg)
+	}
+
+	delete(env, "LEASE_DSN")
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for postgres backend without LEASE_DSN, got nil")
+	}
+
+	env = fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"LEASE_BACKEND": "memory",
+	}
+	if _, err := Load(env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Redis-backed features still need an address
+	env["SHARED_RATE_LIMIT"] = "5"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for SHARED_RATE_LIMIT without REDIS_ADDR, got nil")
+	}
+	delete(env, "SHARED_RATE_LIMIT")
+	env["PAUSE_REDIS_KEY"] = "pause"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for PAUSE_REDIS_KEY without REDIS_ADDR, got nil")
+	}
+
+	env = fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"LEASE_BACKEND": "etcd",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for unknown LEASE_BACKEND, got nil")
+	}
+}
+
+func TestLoad_RedisStreamsBackend(t *testing.T) {
+	env := fakeEnv{
+		"QUEUE_BACKEND": "redis-streams",
+		"REDIS_ADDR":    "localhost:6379",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for redis-streams backend without STREAM_KEY, got nil")
+	}
+
+	// SQS_QUEUE_URL isn't needed
+	env["STREAM_KEY"] = "orders"
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.QueueBackend != "redis-streams" || cfg.StreamKey != "orders" {
+		t.Fatalf("unexpected stream config: %+v", cfg)
+	}
+	if cfg.StreamGroup != "workers" || cfg.VisibilityTimeout != 30 || cfg.StreamMaxDeliveries != 5 {
+		t.Fatalf("expected stream defaults, got %+v", cfg)
+	}
+
+	env["STREAM_DEAD_LETTER_KEY"] = "orders:dlq"
+	env["STREAM_MAX_DELIVERIES"] = "3"
+	env["VISIBILITY_TIMEOUT"] = "60"
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.StreamDeadLetterKey != "orders:dlq" || cfg.StreamMaxDeliveries != 3 || cfg.VisibilityTimeout != 60 {
+		t.Fatalf("unexpected stream config: %+v", cfg)
+	}
+
+	env["STREAM_MAX_DELIVERIES"] = "0"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for STREAM_MAX_DELIVERIES 0, got nil")
+	}
+	delete(env, "STREAM_MAX_DELIVERIES")
+
+	// Streams live in Redis, whatever the lease backend
+	env["LEASE_BACKEND"] = "memory"
+	delete(env, "REDIS_ADDR")
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for redis-streams without REDIS_ADDR, got nil")
+	}
+
+	env = fakeEnv{
+		"QUEUE_BACKEND": "kafka",
+		"REDIS_ADDR":    "localhost:6379",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for unknown QUEUE_BACKEND, got nil")
+	}
+}
+
+func TestLoad_PostgresQueueBackend(t *testing.T) {
+	env := fakeEnv{
+		"QUEUE_BACKEND": "postgres",
+		"LEASE_BACKEND": "memory",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for postgres backend without QUEUE_DSN, got nil")
+	}
+
+	env["QUEUE_DSN"] = "postgres://localhost/app"
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.QueueDSN != "postgres://localhost/app" || cfg.QueueTable != "worker_jobs" || cfg.QueueAckMode != "delete" {
+		t.Fatalf("unexpected queue config: %+v", cfg)
+	}
+
+	env["QUEUE_TABLE"] = "jobs"
+	env["QUEUE_ACK_MODE"] = "mark-done"
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.QueueTable != "jobs" || cfg.QueueAckMode != "mark-done" {
+		t.Fatalf("unexpected queue config: %+v", cfg)
+	}
+
+	env["QUEUE_ACK_MODE"] = "archive"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for unknown QUEUE_ACK_MODE, got nil")
+	}
+}
+
+func TestLoad_FileQueueBackend(t *testing.T) {
+	env := fakeEnv{
+		"QUEUE_BACKEND": "file",
+		"LEASE_BACKEND": "memory",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for file backend without FILE_QUEUE_DIR, got nil")
+	}
+
+	env["FILE_QUEUE_DIR"] = "/var/lib/worker/queue"
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.FileQueueDir != "/var/lib/worker/queue" || cfg.FileQueueMaxReceives != 5 || cfg.FileQueueForward {
+		t.Fatalf("unexpected file queue config: %+v", cfg)
+	}
+
+	env["FILE_QUEUE_FORWARD"] = "true"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for FILE_QUEUE_FORWARD without SQS_QUEUE_URL, got nil")
+	}
+	env["SQS_QUEUE_URL"] = "http://example.com/queue"
+	env["FILE_QUEUE_MAX_RECEIVES"] = "0"
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.FileQueueForward || cfg.FileQueueMaxReceives != 0 {
+		t.Fatalf("unexpected file queue config: %+v", cfg)
+	}
+
+	env["FILE_QUEUE_MAX_RECEIVES"] = "-1"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for negative FILE_QUEUE_MAX_RECEIVES, got nil")
+	}
+}
+
+func TestLoad_HTTPBackend(t *testing.T) {
+	env := fakeEnv{
+		"QUEUE_BACKEND":       "http",
+		"LEASE_BACKEND":       "memory",
+		"HTTP_SNS_TOPIC_ARNS": "arn:aws:sns:us-east-1:123:a, arn:aws:sns:us-east-1:123:b",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.HTTPListenAddr != ":8080" || cfg.HTTPResponseTimeout != 15 || len(cfg.HTTPSNSTopicARNs) != 2 || cfg.HTTPSNSTopicARNs[1] != "arn:aws:sns:us-east-1:123:b" {
+		t.Fatalf("unexpected http config: %+v", cfg)
+	}
+
+	env["HTTP_RESPONSE_TIMEOUT"] = "0"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for zero HTTP_RESPONSE_TIMEOUT, got nil")
+	}
+}
+
+func TestLoad_RedlockAddrs(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"LEASE_BACKEND": "redlock",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for redlock backend without REDLOCK_ADDRS, got nil")
+	}
+
+	env["REDLOCK_ADDRS"] = "r1:6379, r2:6379,r3:6379"
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"r1:6379", "r2:6379", "r3:6379"}
+	if len(cfg.RedlockAddrs) != len(want) {
+		t.Fatalf("expected RedlockAddrs %v, got %v", want, cfg.RedlockAddrs)
+	}
+	for i := range want {
+		if cfg.RedlockAddrs[i] != want[i] {
+			t.Fatalf("expected RedlockAddrs %v, got %v", want, cfg.RedlockAddrs)
+		}
+	}
+}
+
+func TestLoad_Dedupe(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"REDIS_ADDR":    "localhost:6379",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DedupeWindow != 0 || cfg.DedupeCapacity != 10000 {
+		t.Fatalf("expected dedupe disabled with capacity 10000, got %d/%d", cfg.DedupeWindow, cfg.DedupeCapacity)
+	}
+
+	env["DEDUPE_WINDOW"] = "120"
+	env["DEDUPE_CAPACITY"] = "500"
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DedupeWindow != 120 || cfg.DedupeCapacity != 500 {
+		t.Fatalf("expected dedupe 120/500, got %d/%d", cfg.DedupeWindow, cfg.DedupeCapacity)
+	}
+
+	env["DEDUPE_CAPACITY"] = "0"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for zero DEDUPE_CAPACITY, got nil")
+	}
+}
+
+func TestLoad_SharedRateLimit(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL":            "http://example.com/queue",
+		"REDIS_ADDR":               "localhost:6379",
+		"SHARED_RATE_LIMIT":        "2.5",
+		"SHARED_RATE_BURST":        "10",
+		"RATE_LIMIT_KEY_ATTRIBUTE": "tenant",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SharedRateLimit != 2.5 {
+		t.Fatalf("expected SharedRateLimit 2.5, got %v", cfg.SharedRateLimit)
+	}
+	if cfg.SharedRateBurst != 10 {
+		t.Fatalf("expected SharedRateBurst 10, got %d", cfg.SharedRateBurst)
+	}
+	if cfg.RateLimitKeyAttribute != "tenant" {
+		t.Fatalf("expected RateLimitKeyAttribute tenant, got %q", cfg.RateLimitKeyAttribute)
+	}
+}
+
+func TestLoad_InvalidSharedRateLimitFails(t *testing.T) {
+	for _, v := range []string{"abc", "-1"} {
+		env := fakeEnv{
+			"SQS_QUEUE_URL":     "http://example.com/queue",
+			"REDIS_ADDR":        "localhost:6379",
+			"SHARED_RATE_LIMIT": v,
+		}
+		if _, err := Load(env); err == nil {
+			t.Fatalf("expected error for SHARED_RATE_LIMIT=%q, got nil", v)
+		}
+	}
+}
+
+func TestLoad_PauseToggle(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL":       "http://example.com/queue",
+		"REDIS_ADDR":          "localhost:6379",
+		"PAUSE_REDIS_KEY":     "worker:paused",
+		"PAUSE_POLL_INTERVAL": "2",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.PauseRedisKey != "worker:paused" {
+		t.Fatalf("expected PauseRedisKey worker:paused, got %q", cfg.PauseRedisKey)
+	}
+	if cfg.PausePollInterval != 2 {
+		t.Fatalf("expected PausePollInterval 2, got %d", cfg.PausePollInterval)
+	}
+}
+
+func TestLoad_BothPauseTogglesFail(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL":   "http://example.com/queue",
+		"REDIS_ADDR":      "localhost:6379",
+		"PAUSE_FILE":      "/tmp/paused",
+		"PAUSE_REDIS_KEY": "worker:paused",
+	}
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestLoad_IdempotencyRetention(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL":         "http://example.com/queue",
+		"REDIS_ADDR":            "localhost:6379",
+		"IDEMPOTENCY_RETENTION": "86400",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IdempotencyRetention != 86400 {
+		t.Fatalf("expected IdempotencyRetention 86400, got %d", cfg.IdempotencyRetention)
+	}
+
+	env["IDEMPOTENCY_RETENTION"] = "-1"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for negative IDEMPOTENCY_RETENTION, got nil")
+	}
+}
+
+func TestLoad_LeaseKey(t *testing.T) {
+	valid := []string{"message-id", "sha256", "attribute:event-id", "json:order.id"}
+	for _, v := range valid {
+		env := fakeEnv{
+			"SQS_QUEUE_URL": "http://example.com/queue",
+			"REDIS_ADDR":    "localhost:6379",
+			"LEASE_KEY":     v,
+		}
+		cfg, err := Load(env)
+		if err != nil {
+			t.Fatalf("unexpected error for LEASE_KEY=%q: %v", v, err)
+		}
+		if cfg.LeaseKey != v {
+			t.Fatalf("expected LeaseKey %q, got %q", v, cfg.LeaseKey)
+		}
+	}
+
+	invalid := []string{"attribute:", "json:", "md5"}
+	for _, v := range invalid {
+		env := fakeEnv{
+			"SQS_QUEUE_URL": "http://example.com/queue",
+			"REDIS_ADDR":    "localhost:6379",
+			"LEASE_KEY":     v,
+		}
+		if _, err := Load(env); err == nil {
+			t.Fatalf("expected error for LEASE_KEY=%q, got nil", v)
+		}
+	}
+}
+
+func TestLoad_LeaseContention(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"REDIS_ADDR":    "localhost:6379",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LeaseContention != "delay" {
+		t.Fatalf("expected default LeaseContention delay, got %q", cfg.LeaseContention)
+	}
+
+	env["LEASE_CONTENTION"] = "delete-duplicate"
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LeaseContention != "delete-duplicate" {
+		t.Fatalf("expected LeaseContention delete-duplicate, got %q", cfg.LeaseContention)
+	}
+
+	env["LEASE_CONTENTION"] = "drop"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for unknown LEASE_CONTENTION, got nil")
+	}
+}
+
+func TestLoad_LeaseFailurePolicy(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL": "http://example.com/queue",
+		"REDIS_ADDR":    "localhost:6379",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LeaseFailurePolicy != "closed" {
+		t.Fatalf("expected default LeaseFailurePolicy closed, got %q", cfg.LeaseFailurePolicy)
+	}
+
+	env["LEASE_FAILURE_POLICY"] = "local"
+	cfg, err = Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LeaseFailurePolicy != "local" {
+		t.Fatalf("expected LeaseFailurePolicy local, got %q", cfg.LeaseFailurePolicy)
+	}
+
+	env["LEASE_FAILURE_POLICY"] = "ignore"
+	if _, err := Load(env); err == nil {
+		t.Fatal("expected error for unknown LEASE_FAILURE_POLICY, got nil")
+	}
+}
+
+func TestLoad_RedisConnectionOptions(t *testing.T) {
+	env := fakeEnv{
+		"SQS_QUEUE_URL":         "http://example.com/queue",
+		"REDIS_ADDR":            "s1:26379,s2:26379",
+		"REDIS_USERNAME":        "worker",
+		"REDIS_PASSWORD":        "secret",
+		"REDIS_DB":              "3",
+		"REDIS_TLS":             "true",
+		"REDIS_TLS_CA_FILE":     "/etc/ssl/redis-ca.pem",
+		"REDIS_POOL_SIZE":       "20",
+		"REDIS_SENTINEL_MASTER": "mymaster",
+		"REDIS_KEY_PREFIX":      "tenant-a:",
+		"REDIS_CLUSTER":         "true",
+	}
+	cfg, err := Load(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RedisUsername != "worker" || cfg.RedisPassword != "secret" {
+		t.Fatalf("expected Redis credentials worker/secret, got %q/%q", cfg.RedisUsername, cfg.RedisPassword)
+	}
+	if cfg.RedisDB != 3 {
+		t.Fatalf("expected RedisDB 3, got %d", cfg.RedisDB)
+	}
+	if !cfg.RedisTLS || cfg.RedisTLSCAFile != "/etc/ssl/redis-ca.pem" {
+		t.Fatalf("expected Redis TLS with CA file, got %v %q", cfg.RedisTLS, cfg.RedisTLSCAFile)
+	}
+	if cfg.RedisPoolSize != 20 {
+		t.Fatalf("expected RedisPoolSize 20, got %d", cfg.RedisPoolSize)
+	}
+	if cfg.RedisSentinelMaster != "mymaster" {
+		t.Fatalf("expected RedisSentinelMaster mymaster, got %q", cfg.RedisSentinelMaster)
+	}
+	if cfg.RedisKeyPrefix != "tenant-a:" {
+		t.Fatalf("expected RedisKeyPrefix tenant-a:, got %q", cfg.RedisKeyPrefix)
+	}
+	if !cfg.RedisCluster {
+		t.Fatal("expected RedisCluster")
+	}
+}
+
+func TestLoad_InvalidRedisOptionsFail(t *testing.T) {
+	invalid := map[string]string{
+		"REDIS_DB":        "-1",
+		"REDIS_TLS":       "maybe",
+		"REDIS_CLUSTER":   "maybe",
+		"REDIS_POOL_SIZE": "-5",
+	}
+	for key, v := range invalid {
+		env := fakeEnv{
+			"SQS_QUEUE_URL": "http://example.com/queue",
+			"REDIS_ADDR":    "localhost:6379",
+			key:             v,
+		}
+		if _, err := Load(env); err == nil {
+			t.Fatalf("expected error for %s=%q, got nil", key, v)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package sqslocal serves the SQS JSON protocol over HTTP, backed by an
+// sqsfake.Client, so the AWS SDK v2 client can run against it in place of
+// ElasticMQ without Docker. It covers queue management, single and batch
+// send, receive, delete and visibility changes, queue attributes, purge and
+// redrive; the legacy query protocol is not supported.
+package sqslocal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+
+	"go-sqs-worker/internal/worker/sqsfake"
+)
+
+const targetPrefix = "AmazonSQS."
+
+type operation func(ctx context.Context, body []byte) (any, error)
+
+// Handler serves the SQS JSON protocol for fake. Queue URLs are whatever
+// fake hands out; see sqsfake.Client.WithBaseURL.
+func Handler(fake *sqsfake.Client) http.Handler {
+	ops := map[string]operation{
+		"CreateQueue":                  op(fake.CreateQueue),
+		"GetQueueUrl":                  op(fake.GetQueueUrl),
+		"ListQueues":                   op(fake.ListQueues),
+		"DeleteQueue":                  op(fake.DeleteQueue),
+		"GetQueueAttributes":           op(fake.GetQueueAttributes),
+		"SetQueueAttributes":           op(fake.SetQueueAttributes),
+		"PurgeQueue":                   op(fake.PurgeQueue),
+		"SendMessage":                  op(fake.SendMessage),
+		"SendMessageBatch":             op(fake.SendMessageBatch),
+		"ReceiveMessage":               op(fake.ReceiveMessage),
+		"DeleteMessage":                op(fake.DeleteMessage),
+		"DeleteMessageBatch":           op(fake.DeleteMessageBatch),
+		"ChangeMessageVisibility":      op(fake.ChangeMessageVisibility),
+		"ChangeMessageVisibilityBatch": op(fake.ChangeMessageVisibilityBatch),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			// Health checks
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		target := req.Header.Get("X-Amz-Target")
+		handle, ok := ops[strings.TrimPrefix(target, targetPrefix)]
+		if !ok || !strings.HasPrefix(target, targetPrefix) {
+			writeError(w, &smithy.GenericAPIError{Code: "UnsupportedOperation", Message: fmt.Sprintf("Unsupported target %q.", target), Fault: smithy.FaultClient})
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, &smithy.GenericAPIError{Code: "InvalidRequest", Message: err.Error(), Fault: smithy.FaultClient})
+			return
+		}
+
+		out, err := handle(req.Context(), body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		encoded, err := encode(out)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_, _ = w.Write(encoded)
+	})
+}
+
+// op adapts an SDK-shaped method. The SDK's input structs use the wire
+// field names, so the JSON body decodes straight into them.
+func op[In, Out any](fn func(context.Context, *In, ...func(*sqs.Options)) (*Out, error)) operation {
+	return func(ctx context.Context, body []byte) (any, error) {
+		in := new(In)
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, in); err != nil {
+				return nil, &smithy.GenericAPIError{Code: "SerializationException", Message: err.Error(), Fault: smithy.FaultClient}
+			}
+		}
+		return fn(ctx, in)
+	}
+}
+
+// encode renders an SDK output struct on the wire, dropping nulls and the
+// client-side ResultMetadata.
+func encode(out any) ([]byte, error) {
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, "ResultMetadata")
+	return json.Marshal(dropNulls(doc))
+}
+
+func dropNulls(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if child == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = dropNulls(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = dropNulls(child)
+		}
+	}
+	return v
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, status := "InternalError", http.StatusInternalServerError
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+		if apiErr.ErrorFault() != smithy.FaultServer {
+			status = http.StatusBadRequest
+		}
+	} else if errors.Is(err, context.Canceled) {
+		// The client went away during a long poll
+		return
+	}
+	body, _ := json.Marshal(map[string]string{
+		"__type":  "com.amazonaws.sqs#" + code,
+		"message": errorMessage(err),
+	})
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func errorMessage(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorMessage() != "" {
+		return apiErr.ErrorMessage()
+	}
+	return err.Error()
+}
+
+// Server is a running sqslocal endpoint.
+type Server struct {
+	// URL is the endpoint to give the SDK, e.g. as BaseEndpoint.
+	URL string
+	// Fake is the service behind the endpoint, for seeding queues and
+	// assertions.
+	Fake *sqsfake.Client
+
+	srv *http.Server
+}
+
+// Start serves a new sqsfake.Client on addr in the background; use
+// "127.0.0.1:0" for a free port. Queue URLs point at the server.
+func Start(addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host := ln.Addr().(*net.TCPAddr)
+	hostname := "localhost"
+	if !host.IP.IsUnspecified() {
+		hostname = host.IP.String()
+	}
+	url := fmt.Sprintf("http://%s", net.JoinHostPort(hostname, fmt.Sprint(host.Port)))
+
+	fake := sqsfake.New().WithBaseURL(url)
+	s := &Server{
+		URL:  url,
+		Fake: fake,
+		srv:  &http.Server{Handler: Handler(fake), ReadHeaderTimeout: 10 * time.Second},
+	}
+	go func() {
+		_ = s.srv.Serve(ln)
+	}()
+	return s, nil
+}
+
+// Close stops the server, interrupting long polls.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
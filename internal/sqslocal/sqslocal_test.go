@@ -0,0 +1,172 @@
+package sqslocal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"go-sqs-worker/internal/sqslocal"
+)
+
+func newTestClient(t *testing.T) (*sqs.Client, *sqslocal.Server, context.Context) {
+	t.Helper()
+	srv, err := sqslocal.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	client := sqs.New(sqs.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("dummy", "dummy", ""),
+	})
+	return client, srv, ctx
+}
+
+func TestSDKRoundTrip(t *testing.T) {
+	client, srv, ctx := newTestClient(t)
+
+	created, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String("jobs")})
+	if err != nil {
+		t.Fatalf("create queue: %v", err)
+	}
+	queueURL := *created.QueueUrl
+	if queueURL != srv.URL+"/000000000000/jobs" {
+		t.Fatalf("unexpected queue URL %s", queueURL)
+	}
+	got, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String("jobs")})
+	if err != nil || *got.QueueUrl != queueURL {
+		t.Fatalf("get queue URL: %v (err %v)", got, err)
+	}
+
+	// The SDK checks the body MD5s of sends and receives
+	if _, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    &queueURL,
+		MessageBody: aws.String("hello"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("a")},
+			"blob":   {DataType: aws.String("Binary"), BinaryValue: []byte{0, 1, 2}},
+		},
+	}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	batch, err := client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: &queueURL, Entries: []types.SendMessageBatchRequestEntry{
+		{Id: aws.String("a"), MessageBody: aws.String("two")},
+		{Id: aws.String("b"), MessageBody: aws.String("three")},
+	}})
+	if err != nil || len(batch.Successful) != 2 {
+		t.Fatalf("send batch: %+v (err %v)", batch, err)
+	}
+
+	out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:                    &queueURL,
+		MaxNumberOfMessages:         10,
+		MessageAttributeNames:       []string{"All"},
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+	})
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(out.Messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(out.Messages))
+	}
+	first := out.Messages[0]
+	if *first.Body != "hello" || *first.MessageAttributes["tenant"].StringValue != "a" ||
+		string(first.MessageAttributes["blob"].BinaryValue) != "\x00\x01\x02" ||
+		first.Attributes["ApproximateReceiveCount"] != "1" {
+		t.Fatalf("unexpected message %+v", first)
+	}
+
+	if _, err := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: &queueURL, ReceiptHandle: first.ReceiptHandle, VisibilityTimeout: 0}); err != nil {
+		t.Fatalf("change visibility: %v", err)
+	}
+	del, err := client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{QueueUrl: &queueURL, Entries: []types.DeleteMessageBatchRequestEntry{
+		{Id: aws.String("0"), ReceiptHandle: out.Messages[1].ReceiptHandle},
+		{Id: aws.String("1"), ReceiptHandle: out.Messages[2].ReceiptHandle},
+	}})
+	if err != nil || len(del.Successful) != 2 {
+		t.Fatalf("delete batch: %+v (err %v)", del, err)
+	}
+
+	attrs, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: &queueURL, AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages}})
+	if err != nil || attrs.Attributes["ApproximateNumberOfMessages"] != "1" {
+		t.Fatalf("expected 1 visible message, got %v (err %v)", attrs, err)
+	}
+	if _, err := client.PurgeQueue(ctx, &sqs.PurgeQueueInput{QueueUrl: &queueURL}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if n := len(srv.Fake.Messages(queueURL)); n != 0 {
+		t.Fatalf("expected queue purged, got %d", n)
+	}
+}
+
+func TestSDKErrors(t *testing.T) {
+	client, _, ctx := newTestClient(t)
+
+	var missing *types.QueueDoesNotExist
+	_, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String("nope")})
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected QueueDoesNotExist, got %v", err)
+	}
+
+	created, _ := client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String("jobs")})
+	var invalid *types.ReceiptHandleIsInvalid
+	_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: created.QueueUrl, ReceiptHandle: aws.String("bogus")})
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected ReceiptHandleIsInvalid, got %v", err)
+	}
+}
+
+func TestSDKRedrive(t *testing.T) {
+	client, srv, ctx := newTestClient(t)
+
+	dlq, _ := client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String("jobs-dlq")})
+	dlqAttrs, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: dlq.QueueUrl, AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn}})
+	if err != nil {
+		t.Fatalf("attributes: %v", err)
+	}
+	created, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String("jobs"), Attributes: map[string]string{
+		"RedrivePolicy": `{"deadLetterTargetArn":"` + dlqAttrs.Attributes["QueueArn"] + `","maxReceiveCount":"1"}`,
+	}})
+	if err != nil {
+		t.Fatalf("create queue: %v", err)
+	}
+	queueURL := created.QueueUrl
+	_, _ = client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: queueURL, MessageBody: aws.String("poison")})
+
+	out, _ := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL})
+	if len(out.Messages) != 1 {
+		t.Fatal("expected first delivery")
+	}
+	_, _ = client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: queueURL, ReceiptHandle: out.Messages[0].ReceiptHandle, VisibilityTimeout: 0})
+	out, _ = client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL})
+	if len(out.Messages) != 0 {
+		t.Fatal("expected message redriven after maxReceiveCount")
+	}
+	if n := len(srv.Fake.Messages(*dlq.QueueUrl)); n != 1 {
+		t.Fatalf("expected 1 message in the DLQ, got %d", n)
+	}
+}
+
+func TestSDKLongPoll(t *testing.T) {
+	client, _, ctx := newTestClient(t)
+	created, _ := client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String("jobs")})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = client.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: created.QueueUrl, MessageBody: aws.String("late")})
+	}()
+	out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: created.QueueUrl, WaitTimeSeconds: 5})
+	if err != nil || len(out.Messages) != 1 {
+		t.Fatalf("expected the late message, got %+v (err %v)", out, err)
+	}
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// backoff yields exponentially growing delays with jitter: each delay is
+// drawn from [d/2, d) where d doubles from base up to max.
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func (b *backoff) next() time.Duration {
+	d := b.base << b.attempt
+	if d > b.max || d <= 0 {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+	if d <= 0 {
+		// A zero backoff retries straight away
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half)
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// fatalReceiveCodes are SQS error codes that retrying will not fix.
+var fatalReceiveCodes = map[string]bool{
+	"AccessDenied":                            true,
+	"AccessDeniedException":                   true,
+	"AWS.SimpleQueueService.NonExistentQueue": true,
+	"QueueDoesNotExist":                       true,
+	"InvalidClientTokenId":                    true,
+	"UnrecognizedClientException":             true,
+	"InvalidAddress":                          true,
+}
+
+// IsFatalReceiveError reports whether err is a configuration or permission
+// problem, such as a missing queue or denied access, rather than something
+// that may clear up on retry.
+func IsFatalReceiveError(err error) bool {
+	var notExist *types.QueueDoesNotExist
+	if errors.As(err, &notExist) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return fatalReceiveCodes[apiErr.ErrorCode()]
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestBackoff_GrowsWithJitterUpToMax(t *testing.T) {
+	b := backoff{base: 100 * time.Millisecond, max: time.Second}
+
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		w *= time.Millisecond
+		d := b.next()
+		if d < w/2 || d >= w {
+			t.Fatalf("attempt %d: delay %v outside [%v, %v)", i, d, w/2, w)
+		}
+	}
+
+	b.reset()
+	if d := b.next(); d >= 100*time.Millisecond {
+		t.Fatalf("expected reset to start over, got %v", d)
+	}
+}
+
+func TestBackoff_ZeroMaxDoesNotPanic(t *testing.T) {
+	// e.g. WithIdleBackoff(n, 0, 0) or WithReceiveBackoff(time.Second, 0)
+	for _, b := range []backoff{{}, {base: time.Second}} {
+		for i := 0; i < 3; i++ {
+			if d := b.next(); d != 0 {
+				t.Fatalf("%+v: expected no delay, got %v", b, d)
+			}
+		}
+	}
+}
+
+func TestIsFatalReceiveError(t *testing.T) {
+	tests := []struct {
+		err   error
+		fatal bool
+	}{
+		{&smithy.GenericAPIError{Code: "AccessDenied"}, true},
+		{&smithy.GenericAPIError{Code: "AWS.SimpleQueueService.NonExistentQueue"}, true},
+		{fmt.Errorf("receive: %w", &types.QueueDoesNotExist{}), true},
+		{&smithy.GenericAPIError{Code: "ThrottlingException"}, false},
+		{errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := IsFatalReceiveError(tt.err); got != tt.fatal {
+			t.Errorf("IsFatalReceiveError(%v) = %v, want %v", tt.err, got, tt.fatal)
+		}
+	}
+}
+
+func TestRunner_ReturnsFatalReceiveError(t *testing.T) {
+	client := &fakeSQS{err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "no"}}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 2, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := runner.Run(ctx)
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+		t.Fatalf("expected AccessDenied error, got %v", err)
+	}
+	if got := client.receiveCalls.Load(); got != 1 {
+		t.Errorf("expected a single receive, got %d", got)
+	}
+}
+
+func TestRunner_BacksOffOnReceiveErrors(t *testing.T) {
+	client := &fakeSQS{err: &smithy.GenericAPIError{Code: "ThrottlingException"}}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 2, 2).
+		WithReceiveBackoff(20*time.Millisecond, 40*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if err := runner.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// Delays are at least 10ms, 20ms, 20ms, ... so ~10 attempts fit in 200ms
+	if got := client.receiveCalls.Load(); got > 15 {
+		t.Errorf("expected receive retries to back off, got %d calls", got)
+	}
+}
+
+func TestRunner_IdleBackoffWithoutLongPolling(t *testing.T) {
+	client := &fakeSQS{}
+	poller := NewPoller(client, "http://example.com/queue").WithWaitTimeSeconds(0)
+
+	handler := func(ctx context.Context, msg *Message) error {
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 2, 2).
+		WithIdleBackoff(3, 20*time.Millisecond, 20*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_ = runner.Run(ctx)
+
+	// 3 immediate empty receives, then one every 10-20ms
+	if got := client.receiveCalls.Load(); got > 25 {
+		t.Errorf("expected idle receives to back off, got %d calls", got)
+	}
+}
@@ -0,0 +1,267 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig controls when the circuit breaker trips and how it recovers.
+// Zero values fall back to the defaults noted on each field.
+type BreakerConfig struct {
+	// MaxFailures trips the breaker after this many consecutive handler
+	// failures. Defaults to 5 when FailureRate is not set either.
+	MaxFailures int
+	// FailureRate trips the breaker once the failure ratio over the last
+	// WindowSize outcomes reaches it (0 < FailureRate <= 1).
+	FailureRate float64
+	// WindowSize is the number of outcomes FailureRate is measured over.
+	// Defaults to 20.
+	WindowSize int
+	// OpenDuration is how long the breaker stays open before probing.
+	// Defaults to 30s.
+	OpenDuration time.Duration
+	// HalfOpenProbes is how many messages are let through while half-open;
+	// all of them must succeed to close the breaker. Defaults to 1.
+	HalfOpenProbes int
+	// ReleaseVisibility is the visibility timeout given to messages that
+	// fail, or are not run, while the breaker is open. Defaults to 5s.
+	ReleaseVisibility time.Duration
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.MaxFailures <= 0 && c.FailureRate <= 0 {
+		c.MaxFailures = 5
+	}
+	if c.WindowSize <= 0 {
+		c.WindowSize = 20
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = 1
+	}
+	if c.ReleaseVisibility <= 0 {
+		c.ReleaseVisibility = 5 * time.Second
+	}
+	return c
+}
+
+// breaker gates receives on handler health. Every state change bumps gen;
+// outcomes are only counted for the generation the message was admitted in,
+// so results of messages received before a transition can't flip the new
+// state.
+type breaker struct {
+	cfg      BreakerConfig
+	now      func() time.Time
+	after    func(d time.Duration) (<-chan time.Time, func() bool)
+	onChange func(from, to BreakerState)
+
+	mu          sync.Mutex
+	state       BreakerState
+	gen         uint64
+	changed     chan struct{}
+	openedAt    time.Time
+	probes      int // probe slots left while half-open
+	successes   int // successful probes while half-open
+	consecutive int
+	window      []bool // ring of recent outcomes, true = failure
+	next        int
+	filled      int
+	failures    int
+}
+
+func newBreaker(cfg BreakerConfig, onChange func(from, to BreakerState)) *breaker {
+	cfg = cfg.withDefaults()
+	return &breaker{
+		cfg:      cfg,
+		now:      time.Now,
+		after:    systemClock{}.After,
+		onChange: onChange,
+		changed:  make(chan struct{}),
+		window:   make([]bool, cfg.WindowSize),
+	}
+}
+
+// State reports the current state, moving from open to half-open if the
+// open period has elapsed.
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	from, to := b.expireLocked()
+	state := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return state
+}
+
+// acquire blocks until a receive may happen and returns the generation to
+// report its outcome against.
+func (b *breaker) acquire(ctx context.Context) (uint64, error) {
+	for {
+		b.mu.Lock()
+		from, to := b.expireLocked()
+		switch {
+		case b.state == BreakerClosed:
+			gen := b.gen
+			b.mu.Unlock()
+			b.notify(from, to)
+			return gen, nil
+		case b.state == BreakerHalfOpen && b.probes > 0:
+			b.probes--
+			gen := b.gen
+			b.mu.Unlock()
+			b.notify(from, to)
+			return gen, nil
+		}
+
+		changed := b.changed
+		var expired <-chan time.Time
+		stop := func() bool { return false }
+		if b.state == BreakerOpen {
+			expired, stop = b.after(b.openedAt.Add(b.cfg.OpenDuration).Sub(b.now()))
+		}
+		b.mu.Unlock()
+		b.notify(from, to)
+
+		select {
+		case <-ctx.Done():
+			stop()
+			return 0, ctx.Err()
+		case <-changed:
+		case <-expired:
+		}
+		stop()
+	}
+}
+
+// release hands back an admission that produced no outcome, such as an
+// empty receive or a message skipped before its handler ran.
+func (b *breaker) release(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.gen || b.state != BreakerHalfOpen {
+		return
+	}
+	b.probes++
+	b.signalLocked()
+}
+
+// record reports a handler outcome.
+func (b *breaker) record(gen uint64, failed bool) {
+	b.mu.Lock()
+	if gen != b.gen {
+		b.mu.Unlock()
+		return
+	}
+
+	from, to := b.state, b.state
+	switch b.state {
+	case BreakerClosed:
+		b.observeLocked(failed)
+		if b.trippedLocked() {
+			to = BreakerOpen
+		}
+	case BreakerHalfOpen:
+		if failed {
+			to = BreakerOpen
+		} else {
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenProbes {
+				to = BreakerClosed
+			}
+		}
+	}
+	if to != from {
+		b.setStateLocked(to)
+	}
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *breaker) observeLocked(failed bool) {
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	if b.filled == len(b.window) && b.window[b.next] {
+		b.failures--
+	}
+	b.window[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.window)
+	if b.filled < len(b.window) {
+		b.filled++
+	}
+}
+
+func (b *breaker) trippedLocked() bool {
+	if b.cfg.MaxFailures > 0 && b.consecutive >= b.cfg.MaxFailures {
+		return true
+	}
+	if b.cfg.FailureRate > 0 && b.filled == len(b.window) {
+		return float64(b.failures)/float64(b.filled) >= b.cfg.FailureRate
+	}
+	return false
+}
+
+func (b *breaker) expireLocked() (from, to BreakerState) {
+	if b.state != BreakerOpen || b.now().Before(b.openedAt.Add(b.cfg.OpenDuration)) {
+		return b.state, b.state
+	}
+	b.setStateLocked(BreakerHalfOpen)
+	return BreakerOpen, BreakerHalfOpen
+}
+
+func (b *breaker) setStateLocked(to BreakerState) {
+	b.state = to
+	b.gen++
+	switch to {
+	case BreakerOpen:
+		b.openedAt = b.now()
+	case BreakerHalfOpen:
+		b.probes = b.cfg.HalfOpenProbes
+		b.successes = 0
+	case BreakerClosed:
+		b.consecutive = 0
+		b.failures = 0
+		b.filled = 0
+		b.next = 0
+	}
+	b.signalLocked()
+}
+
+func (b *breaker) signalLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
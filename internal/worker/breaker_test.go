@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestBreaker(cfg BreakerConfig) (*breaker, *fakeClock, *[]BreakerState) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var transitions []BreakerState
+	b := newBreaker(cfg, func(from, to BreakerState) {
+		transitions = append(transitions, to)
+	})
+	b.now = clock.Now
+	return b, clock, &transitions
+}
+
+func mustAcquire(t *testing.T, b *breaker) uint64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	gen, err := b.acquire(ctx)
+	if err != nil {
+		t.Fatalf("expected acquire to succeed, got %v", err)
+	}
+	return gen
+}
+
+func expectBlocked(t *testing.T, b *breaker) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx); err == nil {
+		t.Fatal("expected acquire to block")
+	}
+}
+
+func TestBreaker_TripsOnConsecutiveFailures(t *testing.T) {
+	b, _, transitions := newTestBreaker(BreakerConfig{MaxFailures: 3})
+
+	for i := 0; i < 2; i++ {
+		b.record(mustAcquire(t, b), true)
+	}
+	// A success resets the streak
+	b.record(mustAcquire(t, b), false)
+	for i := 0; i < 2; i++ {
+		b.record(mustAcquire(t, b), true)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("expected closed, got %s", got)
+	}
+
+	b.record(mustAcquire(t, b), true)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("expected open, got %s", got)
+	}
+	expectBlocked(t, b)
+
+	if len(*transitions) != 1 || (*transitions)[0] != BreakerOpen {
+		t.Fatalf("expected one transition to open, got %v", *transitions)
+	}
+}
+
+func TestBreaker_TripsOnFailureRate(t *testing.T) {
+	b, _, _ := newTestBreaker(BreakerConfig{FailureRate: 0.5, WindowSize: 4})
+
+	// Alternating outcomes never build a streak but hit 50%
+	for i := 0; i < 3; i++ {
+		b.record(mustAcquire(t, b), i%2 == 0)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("expected closed before window fills, got %s", got)
+	}
+
+	b.record(mustAcquire(t, b), false)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("expected open, got %s", got)
+	}
+}
+
+func TestBreaker_HalfOpenProbesThenCloses(t *testing.T) {
+	b, clock, transitions := newTestBreaker(BreakerConfig{
+		MaxFailures:    1,
+		OpenDuration:   10 * time.Second,
+		HalfOpenProbes: 2,
+	})
+
+	b.record(mustAcquire(t, b), true)
+	clock.Advance(10 * time.Second)
+
+	p1 := mustAcquire(t, b)
+	p2 := mustAcquire(t, b)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", got)
+	}
+	// Only the configured number of probes get through
+	expectBlocked(t, b)
+
+	b.record(p1, false)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("expected half-open after first probe, got %s", got)
+	}
+	b.record(p2, false)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("expected closed after all probes succeed, got %s", got)
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(*transitions) != len(want) {
+		t.Fatalf("expected transitions %v, got %v", want, *transitions)
+	}
+	for i := range want {
+		if (*transitions)[i] != want[i] {
+			t.Fatalf("expected transitions %v, got %v", want, *transitions)
+		}
+	}
+}
+
+func TestBreaker_HalfOpenFailureReopens(t *testing.T) {
+	b, clock, _ := newTestBreaker(BreakerConfig{MaxFailures: 1, OpenDuration: time.Second})
+
+	b.record(mustAcquire(t, b), true)
+	clock.Advance(time.Second)
+
+	b.record(mustAcquire(t, b), true)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("expected open again, got %s", got)
+	}
+	expectBlocked(t, b)
+}
+
+func TestBreaker_ReleasedProbeCanBeReused(t *testing.T) {
+	b, clock, _ := newTestBreaker(BreakerConfig{MaxFailures: 1, OpenDuration: time.Second})
+
+	b.record(mustAcquire(t, b), true)
+	clock.Advance(time.Second)
+
+	// Empty receive hands the probe back
+	b.release(mustAcquire(t, b))
+	b.record(mustAcquire(t, b), false)
+
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("expected closed, got %s", got)
+	}
+}
+
+func TestBreaker_IgnoresStaleOutcomes(t *testing.T) {
+	b, clock, _ := newTestBreaker(BreakerConfig{MaxFailures: 1, OpenDuration: time.Second})
+
+	stale := mustAcquire(t, b)
+	b.record(mustAcquire(t, b), true)
+	clock.Advance(time.Second)
+	probe := mustAcquire(t, b)
+
+	// A failure from before the trip must not re-open the half-open breaker
+	b.record(stale, true)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", got)
+	}
+
+	b.record(probe, false)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("expected closed, got %s", got)
+	}
+}
+
+func TestBreaker_AcquireWakesWhenOpenPeriodEnds(t *testing.T) {
+	b := newBreaker(BreakerConfig{MaxFailures: 1, OpenDuration: 30 * time.Millisecond}, nil)
+
+	b.record(mustAcquire(t, b), true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := b.acquire(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected acquire to wait for the open period, returned after %v", elapsed)
+	}
+}
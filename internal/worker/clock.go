@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the Runner's source of time. The default is the system clock;
+// workertest.Clock replaces it to drive handler timeouts, lease renewal and
+// backoff from a test.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives once d has passed, and a func
+	// that releases the timer early.
+	After(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// WithClock makes the Runner read time from clock: the handler timeout,
+// lease and visibility renewal, receive and idle backoff, lease store
+// failover waits, the circuit breaker's open period and the duplicate
+// suppression window follow it. Pause switch and lease store polling, and
+// the deadlines on ack and nack calls, keep using the system clock.
+func (r *Runner) WithClock(clock Clock) *Runner {
+	r.clock = clock
+	return r
+}
+
+// WithHandlerTimeout sets how long a handler may run before its context is
+// cancelled with context.DeadlineExceeded. Defaults to 30s.
+func (r *Runner) WithHandlerTimeout(d time.Duration) *Runner {
+	r.handlerTimeout = d
+	return r
+}
+
+func (r *Runner) sleep(ctx context.Context, d time.Duration) error {
+	fired, stop := r.clock.After(d)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-fired:
+		return nil
+	}
+}
+
+// withTimeout is context.WithTimeout on the Runner's clock.
+func (r *Runner) withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := r.clock.(systemClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	dc := &clockDeadlineCtx{Context: ctx, deadline: r.clock.Now().Add(d), done: make(chan struct{})}
+	fired, stop := r.clock.After(d)
+	go func() {
+		select {
+		case <-fired:
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+			stop()
+		}
+		close(dc.done)
+	}()
+	return dc, func() { cancel(context.Canceled) }
+}
+
+// clockDeadlineCtx reports a deadline on a non-system clock the way
+// context.WithTimeout would: Err is context.DeadlineExceeded once it passes.
+// Done is its own channel rather than the inner context's, so contexts
+// derived from it copy Err from it instead of from the inner context.
+type clockDeadlineCtx struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+}
+
+func (c *clockDeadlineCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *clockDeadlineCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *clockDeadlineCtx) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+	// done closes right after the inner context; wait so Err and Done agree
+	<-c.done
+	if context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ContentionPolicy decides what happens to a message whose lease is held by
+// another worker.
+type ContentionPolicy int
+
+const (
+	// ContentionDelay hides the message until the current lease should have
+	// ended, so it comes back once the holder is done or has died.
+	ContentionDelay ContentionPolicy = iota
+	// ContentionRelease makes the message visible again immediately.
+	ContentionRelease
+	// ContentionDeleteDuplicate deletes the message if its key already has
+	// a completion record and delays it otherwise. It requires a lease store
+	// that implements CompletionStore.
+	ContentionDeleteDuplicate
+)
+
+func (p ContentionPolicy) String() string {
+	switch p {
+	case ContentionDelay:
+		return "delay"
+	case ContentionRelease:
+		return "release"
+	case ContentionDeleteDuplicate:
+		return "delete-duplicate"
+	}
+	return "unknown"
+}
+
+// LeaseTTLStore is implemented by lease stores that can report how long a
+// lease has left. Without it, ContentionDelay assumes a full lease TTL.
+type LeaseTTLStore interface {
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// WithContentionPolicy sets how messages are handled when their lease is
+// already held. The default is ContentionDelay.
+func (r *Runner) WithContentionPolicy(policy ContentionPolicy) *Runner {
+	r.contention = policy
+	return r
+}
+
+func (r *Runner) handleContention(ctx context.Context, msg *Message, key string, workerID int) {
+	r.contended.Add(1)
+
+	action := "delayed"
+	switch {
+	case r.contention == ContentionRelease:
+		r.nack(msg, 0, workerID)
+		action = "released"
+	case r.contention == ContentionDeleteDuplicate && r.isCompleted(ctx, key, workerID):
+		r.ack(msg, workerID)
+		action = "deleted duplicate"
+	default:
+		r.nack(msg, r.remainingLease(ctx, key), workerID)
+	}
+
+	fmt.Printf("worker %d lease contention on %s: %s message %s\n", workerID, key, action, msg.MessageID)
+	r.emit(Event{Type: EventLeaseContention, MessageID: msg.MessageID, Key: key, Action: action})
+}
+
+func (r *Runner) isCompleted(ctx context.Context, key string, workerID int) bool {
+	completions, ok := r.leaseStore.(CompletionStore)
+	if !ok {
+		return false
+	}
+	done, err := completions.Completed(ctx, key)
+	if err != nil {
+		fmt.Printf("worker %d completion lookup error: %v\n", workerID, err)
+		return false
+	}
+	return done
+}
+
+// remainingLease is how long the current holder's lease has left, falling
+// back to the full lease TTL when the store can't say.
+func (r *Runner) remainingLease(ctx context.Context, key string) time.Duration {
+	ttlStore, ok := r.leaseStore.(LeaseTTLStore)
+	if !ok {
+		return r.leaseTTL
+	}
+	ttl, err := ttlStore.TTL(ctx, key)
+	if err != nil {
+		return r.leaseTTL
+	}
+	if ttl < 0 {
+		// Released in the meantime
+		return 0
+	}
+	return ttl
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fixedTTLLeaseStore struct {
+	*MemoryLeaseStore
+	ttl time.Duration
+}
+
+func (s *fixedTTLLeaseStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.ttl, nil
+}
+
+// untimedLeaseStore hides the TTL method of the store it wraps.
+type untimedLeaseStore struct {
+	LeaseStore
+	CompletionStore
+}
+
+func newUntimedLeaseStore() untimedLeaseStore {
+	store := NewMemoryLeaseStore()
+	return untimedLeaseStore{LeaseStore: store, CompletionStore: store}
+}
+
+// runContended runs a Runner over a single message whose lease is already
+// held, and waits for it to be settled by the contention policy.
+func runContended(t *testing.T, store LeaseStore, policy ContentionPolicy) (*fakeSQS, *Runner, []Event) {
+	t.Helper()
+
+	if _, ok, _ := store.Acquire(context.Background(), "1", time.Minute); !ok {
+		t.Fatal("expected to pre-acquire lease")
+	}
+
+	settled := make(chan struct{}, 1)
+	client := &fakeSQS{
+		messages:           makeMessages(1),
+		OnDelete:           func(handle string) { settled <- struct{}{} },
+		OnChangeVisibility: func(handle string, timeout int32) { settled <- struct{}{} },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		t.Error("handler must not run for a contended message")
+		return nil
+	}
+
+	events := make(chan Event, 1)
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(store, 45*time.Second).
+		WithContentionPolicy(policy).
+		WithEventHandler(func(e Event) { events <- e })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	var got []Event
+	select {
+	case <-settled:
+		got = append(got, <-events)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for contended message to settle")
+	}
+
+	cancel()
+	<-done
+	return client, runner, got
+}
+
+func TestRunner_Contention_DelayUsesRemainingLease(t *testing.T) {
+	store := &fixedTTLLeaseStore{MemoryLeaseStore: NewMemoryLeaseStore(), ttl: 12 * time.Second}
+
+	client, runner, events := runContended(t, store, ContentionDelay)
+
+	if v, ok := client.GetVisibility("1"); !ok || v != 12 {
+		t.Errorf("expected visibility 12s, got %d (set %v)", v, ok)
+	}
+	if got := runner.Status().LeaseContended; got != 1 {
+		t.Errorf("expected 1 contended message, got %d", got)
+	}
+	if events[0].Type != EventLeaseContention || events[0].Key != "1" || events[0].Action != "delayed" {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+}
+
+func TestRunner_Contention_DelayFallsBackToLeaseTTL(t *testing.T) {
+	client, _, _ := runContended(t, newUntimedLeaseStore(), ContentionDelay)
+
+	if v, _ := client.GetVisibility("1"); v != 45 {
+		t.Errorf("expected visibility 45s, got %d", v)
+	}
+}
+
+func TestRunner_Contention_Release(t *testing.T) {
+	client, _, events := runContended(t, NewMemoryLeaseStore(), ContentionRelease)
+
+	if v, ok := client.GetVisibility("1"); !ok || v != 0 {
+		t.Errorf("expected visibility 0, got %d (set %v)", v, ok)
+	}
+	if events[0].Action != "released" {
+		t.Errorf("expected released action, got %q", events[0].Action)
+	}
+}
+
+func TestRunner_Contention_DeleteDuplicate(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	// Completed earlier, and a redelivered copy is still holding the lease
+	if err := store.Complete(context.Background(), "1", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	client, _, events := runContended(t, store, ContentionDeleteDuplicate)
+
+	if got := client.GetDeletedCount(); got != 1 {
+		t.Errorf("expected duplicate to be deleted, got %d deletes", got)
+	}
+	if events[0].Action != "deleted duplicate" {
+		t.Errorf("expected deleted duplicate action, got %q", events[0].Action)
+	}
+}
+
+func TestRunner_Contention_DeleteDuplicateDelaysUncompleted(t *testing.T) {
+	client, _, _ := runContended(t, newUntimedLeaseStore(), ContentionDeleteDuplicate)
+
+	if got := client.GetDeletedCount(); got != 0 {
+		t.Errorf("expected no delete without completion record, got %d", got)
+	}
+	if v, _ := client.GetVisibility("1"); v != 45 {
+		t.Errorf("expected visibility 45s, got %d", v)
+	}
+}
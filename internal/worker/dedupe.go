@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// dedupeSet remembers which message IDs are in flight on this Runner and
+// which completed within the window. In-flight IDs are bounded by
+// maxInFlight; completed ones by capacity, oldest evicted first.
+type dedupeSet struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	now      func() time.Time
+
+	inFlight map[string]struct{}
+	done     map[string]*list.Element
+	// order holds done entries, oldest first
+	order *list.List
+}
+
+type dedupeEntry struct {
+	id      string
+	expires time.Time
+}
+
+type dedupeState int
+
+const (
+	dedupeNew dedupeState = iota
+	dedupeInFlight
+	dedupeDone
+)
+
+func newDedupeSet(window time.Duration, capacity int) *dedupeSet {
+	return &dedupeSet{
+		window:   window,
+		capacity: capacity,
+		now:      time.Now,
+		inFlight: make(map[string]struct{}),
+		done:     make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// begin marks id in flight unless it already is or recently completed.
+func (d *dedupeSet) begin(id string) dedupeState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.inFlight[id]; ok {
+		return dedupeInFlight
+	}
+	d.prune()
+	if _, ok := d.done[id]; ok {
+		return dedupeDone
+	}
+	d.inFlight[id] = struct{}{}
+	return dedupeNew
+}
+
+// complete moves id from in flight to done for the window.
+func (d *dedupeSet) complete(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.inFlight, id)
+	if el, ok := d.done[id]; ok {
+		d.order.Remove(el)
+	}
+	d.done[id] = d.order.PushBack(dedupeEntry{id: id, expires: d.now().Add(d.window)})
+	for d.order.Len() > d.capacity {
+		d.evict(d.order.Front())
+	}
+}
+
+// forget drops an in-flight id that wasn't processed, so a redelivery runs.
+func (d *dedupeSet) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlight, id)
+}
+
+// prune drops expired done entries. Callers hold d.mu.
+func (d *dedupeSet) prune() {
+	now := d.now()
+	for el := d.order.Front(); el != nil; el = d.order.Front() {
+		if now.Before(el.Value.(dedupeEntry).expires) {
+			return
+		}
+		d.evict(el)
+	}
+}
+
+func (d *dedupeSet) evict(el *list.Element) {
+	d.order.Remove(el)
+	delete(d.done, el.Value.(dedupeEntry).id)
+}
+
+// maxVisibilityTimeout is the longest visibility timeout SQS accepts.
+const maxVisibilityTimeout = 12 * time.Hour
+
+// WithDuplicateSuppression keeps the IDs of in-flight messages, and of those
+// completed within window, in memory (at most capacity completed IDs). A
+// copy arriving while the original runs is hidden for window, at most 12h;
+// one arriving after it completed is deleted. Neither touches the lease
+// store.
+func (r *Runner) WithDuplicateSuppression(window time.Duration, capacity int) *Runner {
+	r.dedupe = newDedupeSet(window, capacity)
+	return r
+}
+
+// processOnce runs process unless msg duplicates one in flight or recently
+// completed on this Runner.
+func (r *Runner) processOnce(ctx context.Context, msg *Message, workerID int) (bool, error) {
+	if r.dedupe == nil {
+		return r.process(ctx, msg, workerID)
+	}
+
+	switch r.dedupe.begin(msg.MessageID) {
+	case dedupeInFlight:
+		fmt.Printf("worker %d duplicate of in-flight message %s, delaying\n", workerID, msg.MessageID)
+		r.nack(msg, min(r.dedupe.window, maxVisibilityTimeout), workerID)
+		r.emit(Event{Type: EventDuplicate, MessageID: msg.MessageID, Action: "delayed"})
+		return false, nil
+	case dedupeDone:
+		fmt.Printf("worker %d duplicate of completed message %s, deleting\n", workerID, msg.MessageID)
+		r.ack(msg, workerID)
+		r.emit(Event{Type: EventDuplicate, MessageID: msg.MessageID, Action: "deleted"})
+		return false, nil
+	}
+
+	ran, err := r.process(ctx, msg, workerID)
+	if ran && err == nil {
+		// Handled, or dropped as permanently failed; either way it's gone
+		r.dedupe.complete(msg.MessageID)
+	} else {
+		r.dedupe.forget(msg.MessageID)
+	}
+	return ran, err
+}
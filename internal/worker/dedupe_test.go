@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+type acquireCountingLeaseStore struct {
+	*MemoryLeaseStore
+	acquires atomic.Int32
+}
+
+func (s *acquireCountingLeaseStore) AcquireFenced(ctx context.Context, key string, ttl time.Duration) (string, int64, bool, error) {
+	s.acquires.Add(1)
+	return s.MemoryLeaseStore.AcquireFenced(ctx, key, ttl)
+}
+
+func newTestDedupeSet(window time.Duration, capacity int) (*dedupeSet, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	d := newDedupeSet(window, capacity)
+	d.now = clock.Now
+	return d, clock
+}
+
+func TestDedupeSet_InFlightAndCompleted(t *testing.T) {
+	d, clock := newTestDedupeSet(time.Minute, 10)
+
+	if got := d.begin("a"); got != dedupeNew {
+		t.Fatalf("expected new, got %v", got)
+	}
+	if got := d.begin("a"); got != dedupeInFlight {
+		t.Fatalf("expected in flight, got %v", got)
+	}
+
+	d.complete("a")
+	if got := d.begin("a"); got != dedupeDone {
+		t.Fatalf("expected done, got %v", got)
+	}
+
+	clock.Advance(time.Minute)
+	if got := d.begin("a"); got != dedupeNew {
+		t.Fatalf("expected new after the window, got %v", got)
+	}
+}
+
+func TestDedupeSet_ForgetAllowsRetry(t *testing.T) {
+	d, _ := newTestDedupeSet(time.Minute, 10)
+
+	d.begin("a")
+	d.forget("a")
+	if got := d.begin("a"); got != dedupeNew {
+		t.Fatalf("expected new after forget, got %v", got)
+	}
+}
+
+func TestDedupeSet_EvictsOldestOverCapacity(t *testing.T) {
+	d, _ := newTestDedupeSet(time.Minute, 2)
+
+	for _, id := range []string{"a", "b", "c"} {
+		d.begin(id)
+		d.complete(id)
+	}
+	if got := d.begin("a"); got != dedupeNew {
+		t.Fatalf("expected oldest entry to be evicted, got %v", got)
+	}
+	if got := d.begin("c"); got != dedupeDone {
+		t.Fatalf("expected newest entry to be kept, got %v", got)
+	}
+	if len(d.done) != 2 || d.order.Len() != 2 {
+		t.Fatalf("expected 2 done entries, got %d/%d", len(d.done), d.order.Len())
+	}
+}
+
+func TestRunner_DuplicateSuppression(t *testing.T) {
+	dup := func(handle string) types.Message {
+		return types.Message{MessageId: aws.String("1"), Body: aws.String("1"), ReceiptHandle: aws.String(handle)}
+	}
+
+	release := make(chan struct{})
+	settled := make(chan string, 3)
+	client := &fakeSQS{
+		messages:           []types.Message{dup("first"), dup("during")},
+		OnDelete:           func(handle string) { settled <- handle },
+		OnChangeVisibility: func(handle string, timeout int32) { settled <- handle },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	var runs atomic.Int32
+	original := make(chan string, 1)
+	handler := func(ctx context.Context, msg *Message) error {
+		if runs.Add(1) == 1 {
+			original <- *msg.ReceiptHandle
+			<-release
+		}
+		return nil
+	}
+
+	store := &acquireCountingLeaseStore{MemoryLeaseStore: NewMemoryLeaseStore()}
+	runner := NewRunner(poller, handler, 2, 2).
+		WithLeaseStore(store, 45*time.Second).
+		WithDuplicateSuppression(30*time.Second, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	wait := func() string {
+		t.Helper()
+		select {
+		case h := <-settled:
+			return h
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for message to settle")
+		}
+		return ""
+	}
+
+	// Either copy may start first; the other is the in-flight duplicate.
+	first := <-original
+	during := "during"
+	if first == "during" {
+		during = "first"
+	}
+	if h := wait(); h != during {
+		t.Fatalf("expected the in-flight duplicate to settle first, got %s", h)
+	}
+	if v, _ := client.GetVisibility(during); v != 30 {
+		t.Errorf("expected duplicate delayed by the window, got %ds", v)
+	}
+
+	close(release)
+	if h := wait(); h != first {
+		t.Fatalf("expected original to be deleted, got %s", h)
+	}
+
+	client.mu.Lock()
+	client.messages = append(client.messages, dup("after"))
+	client.mu.Unlock()
+	if h := wait(); h != "after" {
+		t.Fatalf("expected late duplicate to be deleted, got %s", h)
+	}
+
+	cancel()
+	<-done
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("expected handler to run once, got %d", n)
+	}
+	if n := store.acquires.Load(); n != 1 {
+		t.Errorf("expected duplicates not to reach the lease store, got %d acquires", n)
+	}
+	if v, ok := client.GetVisibility("after"); ok {
+		t.Errorf("expected late duplicate to be deleted, not delayed %ds", v)
+	}
+}
+
+func TestRunner_DuplicateDelayCappedAtMaxVisibility(t *testing.T) {
+	dup := func(handle string) types.Message {
+		return types.Message{MessageId: aws.String("1"), Body: aws.String("1"), ReceiptHandle: aws.String(handle)}
+	}
+	release := make(chan struct{})
+	delayed := make(chan int32, 1)
+	client := &fakeSQS{
+		messages:           []types.Message{dup("first"), dup("during")},
+		OnChangeVisibility: func(handle string, timeout int32) { delayed <- timeout },
+	}
+	handler := func(ctx context.Context, msg *Message) error {
+		<-release
+		return nil
+	}
+	runner := NewRunner(NewPoller(client, "http://example.com/queue"), handler, 2, 2).
+		WithDuplicateSuppression(24*time.Hour, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case v := <-delayed:
+		if v != 43200 {
+			t.Errorf("expected the duplicate delayed by 12h, got %ds", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the duplicate to be delayed")
+	}
+	close(release)
+	cancel()
+	<-done
+}
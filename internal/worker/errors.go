@@ -0,0 +1,32 @@
+package worker
+
+import "errors"
+
+// PermanentError marks a failure that retrying won't fix. The Runner deletes
+// such messages instead of leaving them to be redelivered.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return "permanent: " + e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so the Runner treats it as a permanent failure.
+// Handlers can return it for messages that can never succeed.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err is, or wraps, a PermanentError.
+func IsPermanent(err error) bool {
+	var perm *PermanentError
+	return errors.As(err, &perm)
+}
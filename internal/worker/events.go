@@ -0,0 +1,61 @@
+package worker
+
+type EventType string
+
+const (
+	// EventBreakerStateChange is emitted when the circuit breaker changes
+	// state; State holds the new state.
+	EventBreakerStateChange EventType = "breaker_state_change"
+	// EventPaused and EventResumed are emitted when receiving stops and
+	// starts again, whether by Pause/Resume or the pause switch.
+	EventPaused  EventType = "paused"
+	EventResumed EventType = "resumed"
+	// EventLeaseLost is emitted when a running handler's lease could not be
+	// renewed and its context was cancelled.
+	EventLeaseLost EventType = "lease_lost"
+	// EventDuplicate is emitted when a message is acknowledged without
+	// running the handler because its key was already completed. Duplicates
+	// caught by WithDuplicateSuppression set Action to "delayed" or
+	// "deleted".
+	EventDuplicate EventType = "duplicate"
+	// EventPermanentFailure is emitted when a message is deleted because it
+	// can never succeed, e.g. its lease key could not be extracted.
+	EventPermanentFailure EventType = "permanent_failure"
+	// EventLeaseContention is emitted when a message is skipped because its
+	// lease is held elsewhere; Action says what was done with it.
+	EventLeaseContention EventType = "lease_contention"
+	// EventLeaseStoreDown and EventLeaseStoreUp are emitted when the lease
+	// store becomes unavailable and recovers; State holds the failover
+	// policy in effect.
+	EventLeaseStoreDown EventType = "lease_store_down"
+	EventLeaseStoreUp   EventType = "lease_store_up"
+	// EventLeaseUnavailable is emitted for each message handled by the
+	// failover policy; Action says whether it was released or processed
+	// without a lease.
+	EventLeaseUnavailable EventType = "lease_unavailable"
+)
+
+// Event describes something notable the Runner did, for metrics and alerting.
+// Only the fields relevant to Type are set.
+type Event struct {
+	Type      EventType
+	MessageID string
+	Key       string
+	State     string
+	Action    string
+	Err       error
+}
+
+// WithEventHandler registers fn to receive Runner events. fn is called
+// synchronously from worker goroutines, so it must be fast and safe for
+// concurrent use.
+func (r *Runner) WithEventHandler(fn func(Event)) *Runner {
+	r.onEvent = fn
+	return r
+}
+
+func (r *Runner) emit(e Event) {
+	if r.onEvent != nil {
+		r.onEvent(e)
+	}
+}
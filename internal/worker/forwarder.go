@@ -0,0 +1,261 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// SQSSender is the part of the SQS API the Forwarder needs.
+type SQSSender interface {
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+var _ SQSSender = (*sqs.Client)(nil)
+
+// Forwarder drains a Source, typically a FileQueue filled while offline,
+// into an SQS queue. A message is acked only once SQS has accepted it, so
+// nothing is lost if the process stops part way; a message may be sent
+// twice if it stops between the send and the ack.
+//
+// While SQS is unreachable the same batch is retried with backoff, keeping
+// it hidden, so an outage doesn't count against a dead-letter limit.
+// Entries SQS rejects, and messages it refuses outright such as one over the
+// size limit, are released after a backoff and count as a receive like any
+// other. A missing queue or denied access stops Run with an error.
+type Forwarder struct {
+	source   Source
+	client   SQSSender
+	queueURL string
+	backoff  backoff
+}
+
+func NewForwarder(source Source, client SQSSender, queueURL string) *Forwarder {
+	return &Forwarder{
+		source:   source,
+		client:   client,
+		queueURL: queueURL,
+		backoff:  backoff{base: time.Second, max: 5 * time.Minute},
+	}
+}
+
+// WithBackoff sets the jittered exponential backoff between attempts while
+// SQS is unreachable. Defaults to 1s doubling up to 5m.
+func (f *Forwarder) WithBackoff(base, max time.Duration) *Forwarder {
+	f.backoff = backoff{base: base, max: max}
+	return f
+}
+
+// Run forwards messages until ctx is done.
+func (f *Forwarder) Run(ctx context.Context) error {
+	for {
+		msgs, err := f.source.Receive(ctx, 10)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			delay := f.backoff.next()
+			fmt.Printf("forwarder receive error (retrying in %v): %v\n", delay, err)
+			if sleepCtx(ctx, delay) != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := f.forward(ctx, msgs); err != nil {
+			return err
+		}
+	}
+}
+
+// maxBatchPayload is the most SQS accepts in one SendMessageBatch, summed
+// over the bodies and attributes of its entries.
+const maxBatchPayload = 256 * 1024
+
+// forward sends msgs in batches that fit SQS's payload limit.
+func (f *Forwarder) forward(ctx context.Context, msgs []*Message) error {
+	batches := splitBatch(msgs, maxBatchPayload)
+	for i, batch := range batches {
+		if err := f.send(ctx, batch); err != nil {
+			for _, rest := range batches[i+1:] {
+				f.release(rest)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// splitBatch groups msgs in order so each group's payload stays within
+// limit. A message over the limit on its own gets a group to itself.
+func splitBatch(msgs []*Message, limit int) [][]*Message {
+	var batches [][]*Message
+	var batch []*Message
+	size := 0
+	for _, msg := range msgs {
+		n := payloadSize(msg)
+		if len(batch) > 0 && size+n > limit {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, msg)
+		size += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func payloadSize(msg *Message) int {
+	n := len(msg.Body)
+	for name, value := range msg.Attributes {
+		n += len(name) + len("String") + len(value)
+	}
+	return n
+}
+
+// send sends msgs as one batch, retrying until SQS answers or ctx is done.
+// A batch SQS refuses outright is split into single messages, and a single
+// message it refuses is released after a backoff like a rejected entry.
+// It returns an error if the queue is missing or access is denied.
+func (f *Forwarder) send(ctx context.Context, msgs []*Message) error {
+	input := &sqs.SendMessageBatchInput{QueueUrl: &f.queueURL}
+	for i, msg := range msgs {
+		entry := types.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(i)),
+			MessageBody: aws.String(msg.Body),
+		}
+		if len(msg.Attributes) > 0 {
+			entry.MessageAttributes = make(map[string]types.MessageAttributeValue, len(msg.Attributes))
+			for name, value := range msg.Attributes {
+				entry.MessageAttributes[name] = types.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(value),
+				}
+			}
+		}
+		input.Entries = append(input.Entries, entry)
+	}
+
+	for {
+		out, err := f.client.SendMessageBatch(ctx, input)
+		if err == nil {
+			if len(out.Failed) == 0 {
+				f.backoff.reset()
+			}
+			f.settle(msgs, out)
+			return nil
+		}
+		if ctx.Err() != nil {
+			f.release(msgs)
+			return ctx.Err()
+		}
+		if IsFatalReceiveError(err) {
+			f.release(msgs)
+			return fmt.Errorf("forwarder: %w", err)
+		}
+		if isPermanentSendError(err) {
+			if len(msgs) > 1 {
+				for i, msg := range msgs {
+					if err := f.send(ctx, []*Message{msg}); err != nil {
+						f.release(msgs[i+1:])
+						return err
+					}
+				}
+				return nil
+			}
+			f.reject(msgs[0], err)
+			return nil
+		}
+
+		delay := f.backoff.next()
+		fmt.Printf("forwarder send error (retrying in %v): %v\n", delay, err)
+		for _, msg := range msgs {
+			// Keep the batch ours while we wait
+			if err := f.source.Extend(ctx, msg, delay+time.Minute); err != nil {
+				fmt.Printf("forwarder extend error: %v\n", err)
+			}
+		}
+		if sleepCtx(ctx, delay) != nil {
+			f.release(msgs)
+			return ctx.Err()
+		}
+	}
+}
+
+// retryableSendCodes are client faults that clear up on their own.
+var retryableSendCodes = map[string]bool{
+	"RequestThrottled":    true,
+	"ThrottlingException": true,
+	"KmsThrottled":        true,
+}
+
+// isPermanentSendError reports whether SQS refused the request itself, e.g.
+// BatchRequestTooLong or InvalidParameterValue, so resending it unchanged
+// can't succeed.
+func isPermanentSendError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultClient && !retryableSendCodes[apiErr.ErrorCode()]
+}
+
+// reject releases msg after a backoff, so it counts as a receive and a
+// dead-letter limit on the source eventually sets it aside.
+func (f *Forwarder) reject(msg *Message, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	delay := f.backoff.next()
+	fmt.Printf("forwarder: SQS refused %s (retrying in %v): %v\n", msg.MessageID, delay, err)
+	if err := f.source.Nack(ctx, msg, delay); err != nil {
+		fmt.Printf("forwarder nack error: %v\n", err)
+	}
+}
+
+// settle acks the entries SQS accepted and releases the rest after a
+// backoff.
+func (f *Forwarder) settle(msgs []*Message, out *sqs.SendMessageBatchOutput) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, ok := range out.Successful {
+		i, err := strconv.Atoi(aws.ToString(ok.Id))
+		if err != nil || i < 0 || i >= len(msgs) {
+			continue
+		}
+		if err := f.source.Ack(ctx, msgs[i]); err != nil {
+			fmt.Printf("forwarder ack error: %v\n", err)
+		}
+	}
+	if len(out.Failed) == 0 {
+		return
+	}
+	delay := f.backoff.next()
+	for _, failed := range out.Failed {
+		i, err := strconv.Atoi(aws.ToString(failed.Id))
+		if err != nil || i < 0 || i >= len(msgs) {
+			continue
+		}
+		fmt.Printf("forwarder: SQS rejected %s (retrying in %v): %s %s\n", msgs[i].MessageID, delay, aws.ToString(failed.Code), aws.ToString(failed.Message))
+		if err := f.source.Nack(ctx, msgs[i], delay); err != nil {
+			fmt.Printf("forwarder nack error: %v\n", err)
+		}
+	}
+}
+
+// release hands msgs back so they are forwarded again next time.
+func (f *Forwarder) release(msgs []*Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, msg := range msgs {
+		_ = f.source.Nack(ctx, msg, 0)
+	}
+}
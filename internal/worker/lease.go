@@ -2,10 +2,69 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// ErrLeaseLost is the cause set on a handler's context when its lease could
+// not be renewed and another worker may now own the message.
+var ErrLeaseLost = errors.New("lease lost")
+
 type LeaseStore interface {
 	Acquire(ctx context.Context, key string, ttl time.Duration) (token string, ok bool, err error)
 	Release(ctx context.Context, key string, token string) error
+	// Extend resets the lease's TTL if token still holds it. ok is false
+	// when the lease expired or belongs to someone else.
+	Extend(ctx context.Context, key string, token string, ttl time.Duration) (ok bool, err error)
+}
+
+// CompletionStore records which keys were processed successfully so
+// redeliveries can be acknowledged without running the handler again.
+// Runner.WithIdempotency requires the LeaseStore to implement it.
+type CompletionStore interface {
+	Completed(ctx context.Context, key string) (bool, error)
+	// Complete writes the completion record, kept for retention, and releases
+	// the lease held by token as a single atomic step.
+	Complete(ctx context.Context, key string, token string, retention time.Duration) error
+}
+
+// FencingLeaseStore is implemented by lease stores that hand out a fencing
+// token with each lease: a number that increases with every acquisition, so
+// storage can reject writes from a holder whose lease has since expired.
+type FencingLeaseStore interface {
+	AcquireFenced(ctx context.Context, key string, ttl time.Duration) (token string, fence int64, ok bool, err error)
+}
+
+// Lease describes the lease a handler runs under.
+type Lease struct {
+	Key   string
+	Token string
+	// Fence is the fencing token, or 0 if the store doesn't issue them or
+	// the lease came from the LeaseFailLocal fallback.
+	// Pass it to storage that rejects writes carrying a lower fence than
+	// the last one it accepted.
+	Fence int64
+}
+
+type leaseContextKey struct{}
+
+// LeaseFromContext returns the lease held for the message being handled.
+// ok is false when no lease store is configured or the message runs without
+// a lease under LeaseFailOpen.
+func LeaseFromContext(ctx context.Context) (lease Lease, ok bool) {
+	lease, ok = ctx.Value(leaseContextKey{}).(Lease)
+	return lease, ok
+}
+
+func contextWithLease(ctx context.Context, lease Lease) context.Context {
+	return context.WithValue(ctx, leaseContextKey{}, lease)
+}
+
+// acquireFenced takes a lease from store, with a fence when it issues them.
+func acquireFenced(ctx context.Context, store LeaseStore, key string, ttl time.Duration) (string, int64, bool, error) {
+	if f, ok := store.(FencingLeaseStore); ok {
+		return f.AcquireFenced(ctx, key, ttl)
+	}
+	token, ok, err := store.Acquire(ctx, key, ttl)
+	return token, 0, ok, err
 }
@@ -0,0 +1,14 @@
+package worker_test
+
+import (
+	"testing"
+
+	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/leasetest"
+)
+
+func TestMemoryLeaseStore_Conformance(t *testing.T) {
+	leasetest.RunConformance(t, func(t *testing.T) worker.LeaseStore {
+		return worker.NewMemoryLeaseStore()
+	})
+}
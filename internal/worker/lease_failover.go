@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LeaseFailurePolicy decides how messages are processed while the lease
+// store is unavailable.
+type LeaseFailurePolicy int
+
+const (
+	// LeaseFailClosed releases messages with a short visibility and backs
+	// off receiving until the store is back. Nothing runs unguarded.
+	LeaseFailClosed LeaseFailurePolicy = iota
+	// LeaseFailOpen processes messages without a lease, emitting
+	// EventLeaseUnavailable for each one.
+	LeaseFailOpen
+	// LeaseFailLocal falls back to an in-process lease map, which still
+	// stops duplicates within this replica.
+	LeaseFailLocal
+)
+
+func (p LeaseFailurePolicy) String() string {
+	switch p {
+	case LeaseFailClosed:
+		return "fail-closed"
+	case LeaseFailOpen:
+		return "fail-open"
+	case LeaseFailLocal:
+		return "fail-local"
+	}
+	return "unknown"
+}
+
+// LeaseFailover configures behaviour while the lease store is unavailable.
+// Zero values fall back to the defaults noted on each field.
+type LeaseFailover struct {
+	Policy LeaseFailurePolicy
+	// HealthInterval is how often a store implementing LeaseHealthChecker is
+	// pinged, and how long fail-closed waits between receive attempts.
+	// Defaults to 5s.
+	HealthInterval time.Duration
+	// ReleaseVisibility is the visibility given to messages released by
+	// fail-closed. Defaults to 5s.
+	ReleaseVisibility time.Duration
+}
+
+func (c LeaseFailover) withDefaults() LeaseFailover {
+	if c.HealthInterval <= 0 {
+		c.HealthInterval = 5 * time.Second
+	}
+	if c.ReleaseVisibility <= 0 {
+		c.ReleaseVisibility = 5 * time.Second
+	}
+	return c
+}
+
+// LeaseHealthChecker is implemented by lease stores that can be probed.
+// The Runner pings them in the background, switches to the failover policy
+// as soon as a ping or Acquire fails, and back once a ping succeeds. Stores
+// without it switch on a failed Acquire and are tried again with the next
+// message, which under fail-closed is received after HealthInterval.
+type LeaseHealthChecker interface {
+	Ping(ctx context.Context) error
+}
+
+// WithLeaseFailover sets how the Runner behaves when the lease store is
+// unavailable. The default is LeaseFailClosed.
+func (r *Runner) WithLeaseFailover(cfg LeaseFailover) *Runner {
+	r.failover = cfg.withDefaults()
+	return r
+}
+
+// leaseGrant is the outcome of acquireLease. store is nil when the message
+// runs without a lease.
+type leaseGrant struct {
+	store       LeaseStore
+	completions CompletionStore
+	token       string
+	fence       int64
+}
+
+// acquireLease takes the lease for key, applying the failover policy if the
+// lease store is unavailable. ok is false when the message must not run.
+func (r *Runner) acquireLease(ctx context.Context, msg *Message, key string, workerID int) (leaseGrant, bool) {
+	_, pingable := r.leaseStore.(LeaseHealthChecker)
+	// Without Ping, a message is the only way to find out the store is back
+	if !r.leaseDown.Load() || !pingable {
+		token, fence, ok, err := acquireFenced(ctx, r.leaseStore, key, r.leaseTTL)
+		if err == nil {
+			if !pingable {
+				r.setLeaseStoreDown(false)
+			}
+			if !ok {
+				// Another worker has it
+				r.handleContention(ctx, msg, key, workerID)
+				return leaseGrant{}, false
+			}
+			return leaseGrant{store: r.leaseStore, completions: r.completions, token: token, fence: fence}, true
+		}
+		if ctx.Err() != nil {
+			return leaseGrant{}, false
+		}
+		fmt.Printf("worker %d lease error: %v\n", workerID, err)
+		r.leaseRetryAt.Store(r.clock.Now().Add(r.failover.HealthInterval).UnixNano())
+		r.setLeaseStoreDown(true)
+	}
+
+	switch r.failover.Policy {
+	case LeaseFailOpen:
+		r.unleased.Add(1)
+		r.emit(Event{Type: EventLeaseUnavailable, MessageID: msg.MessageID, Key: key, Action: "processed without lease"})
+		return leaseGrant{}, true
+
+	case LeaseFailLocal:
+		// Local fences aren't comparable with the store's, so none is given
+		token, ok, err := r.localLeases.Acquire(ctx, key, r.leaseTTL)
+		if err != nil {
+			fmt.Printf("worker %d local lease error: %v\n", workerID, err)
+			return leaseGrant{}, false
+		}
+		if !ok {
+			r.handleContention(ctx, msg, key, workerID)
+			return leaseGrant{}, false
+		}
+		g := leaseGrant{store: r.localLeases, token: token}
+		if r.completions != nil {
+			g.completions = r.localLeases
+		}
+		return g, true
+	}
+
+	r.nack(msg, r.failover.ReleaseVisibility, workerID)
+	r.emit(Event{Type: EventLeaseUnavailable, MessageID: msg.MessageID, Key: key, Action: "released"})
+	return leaseGrant{}, false
+}
+
+// leaseBlocksReceive reports whether fail-closed should hold off receiving.
+// A store that can't be pinged is retried with a message every
+// HealthInterval.
+func (r *Runner) leaseBlocksReceive() bool {
+	if r.leaseStore == nil || r.failover.Policy != LeaseFailClosed || !r.leaseDown.Load() {
+		return false
+	}
+	if _, ok := r.leaseStore.(LeaseHealthChecker); ok {
+		return true
+	}
+	return r.clock.Now().UnixNano() < r.leaseRetryAt.Load()
+}
+
+func (r *Runner) setLeaseStoreDown(down bool) {
+	if r.leaseDown.Swap(down) == down {
+		return
+	}
+	if down {
+		fmt.Printf("lease store unavailable, switching to %s\n", r.failover.Policy)
+		r.emit(Event{Type: EventLeaseStoreDown, State: r.failover.Policy.String()})
+	} else {
+		fmt.Printf("lease store recovered, leaving %s\n", r.failover.Policy)
+		r.emit(Event{Type: EventLeaseStoreUp})
+	}
+}
+
+// watchLeaseStore pings the lease store every HealthInterval until ctx is
+// done.
+func (r *Runner) watchLeaseStore(ctx context.Context, checker LeaseHealthChecker) {
+	ticker := time.NewTicker(r.failover.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, r.failover.HealthInterval)
+		err := checker.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		r.setLeaseStoreDown(err != nil)
+	}
+}
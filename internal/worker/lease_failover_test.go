@@ -0,0 +1,271 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// downLeaseStore fails every Acquire, and Ping while down is set.
+type downLeaseStore struct {
+	*MemoryLeaseStore
+	down atomic.Bool
+}
+
+func newDownLeaseStore() *downLeaseStore {
+	s := &downLeaseStore{MemoryLeaseStore: NewMemoryLeaseStore()}
+	s.down.Store(true)
+	return s
+}
+
+func (s *downLeaseStore) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token, _, ok, err := s.AcquireFenced(ctx, key, ttl)
+	return token, ok, err
+}
+
+func (s *downLeaseStore) AcquireFenced(ctx context.Context, key string, ttl time.Duration) (string, int64, bool, error) {
+	if s.down.Load() {
+		return "", 0, false, errors.New("connection refused")
+	}
+	return s.MemoryLeaseStore.AcquireFenced(ctx, key, ttl)
+}
+
+func (s *downLeaseStore) Ping(ctx context.Context) error {
+	if s.down.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+// runFailover runs a Runner over one message with the lease store down and
+// waits for the message to be deleted or released.
+func runFailover(t *testing.T, store LeaseStore, cfg LeaseFailover, handler Handler) (*fakeSQS, *Runner, []Event) {
+	t.Helper()
+
+	settled := make(chan struct{}, 1)
+	client := &fakeSQS{
+		messages:           makeMessages(1),
+		OnDelete:           func(handle string) { settled <- struct{}{} },
+		OnChangeVisibility: func(handle string, timeout int32) { settled <- struct{}{} },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	events := make(chan Event, 10)
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(store, 45*time.Second).
+		WithLeaseFailover(cfg).
+		WithEventHandler(func(e Event) { events <- e })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-settled:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for message to settle")
+	}
+
+	cancel()
+	<-done
+	close(events)
+
+	var got []Event
+	for e := range events {
+		got = append(got, e)
+	}
+	return client, runner, got
+}
+
+func hasEvent(events []Event, typ EventType, action string) bool {
+	for _, e := range events {
+		if e.Type == typ && e.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunner_LeaseFailClosed_ReleasesMessage(t *testing.T) {
+	store := newDownLeaseStore()
+	handler := func(ctx context.Context, msg *Message) error {
+		t.Error("handler must not run without a lease")
+		return nil
+	}
+
+	client, runner, events := runFailover(t, store, LeaseFailover{ReleaseVisibility: 7 * time.Second}, handler)
+
+	if v, ok := client.GetVisibility("1"); !ok || v != 7 {
+		t.Errorf("expected visibility 7s, got %d (set %v)", v, ok)
+	}
+	if client.GetDeletedCount() != 0 {
+		t.Error("expected message to be kept")
+	}
+	if !runner.Status().LeaseStoreDown {
+		t.Error("expected lease store to be reported down")
+	}
+	if !hasEvent(events, EventLeaseStoreDown, "") || !hasEvent(events, EventLeaseUnavailable, "released") {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestRunner_LeaseFailClosed_BacksOffReceiving(t *testing.T) {
+	store := newDownLeaseStore()
+	client := &fakeSQS{}
+	poller := NewPoller(client, "http://example.com/queue")
+	runner := NewRunner(poller, func(ctx context.Context, msg *Message) error { return nil }, 1, 1).
+		WithLeaseStore(store, 45*time.Second).
+		WithLeaseFailover(LeaseFailover{HealthInterval: time.Hour})
+	runner.setLeaseStoreDown(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = runner.Run(ctx)
+
+	if n := client.receiveCalls.Load(); n != 0 {
+		t.Errorf("expected no receives while the store is down, got %d", n)
+	}
+}
+
+func TestRunner_LeaseFailOpen_ProcessesWithoutLease(t *testing.T) {
+	store := newDownLeaseStore()
+	var ran atomic.Bool
+	handler := func(ctx context.Context, msg *Message) error {
+		ran.Store(true)
+		return nil
+	}
+
+	client, runner, events := runFailover(t, store, LeaseFailover{Policy: LeaseFailOpen}, handler)
+
+	if !ran.Load() || client.GetDeletedCount() != 1 {
+		t.Error("expected message to be processed and deleted")
+	}
+	if got := runner.Status().Unleased; got != 1 {
+		t.Errorf("expected 1 unleased message, got %d", got)
+	}
+	if !hasEvent(events, EventLeaseUnavailable, "processed without lease") {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestRunner_LeaseFailLocal_UsesLocalLeases(t *testing.T) {
+	store := newDownLeaseStore()
+	var held atomic.Bool
+	var runner *Runner
+	handler := func(ctx context.Context, msg *Message) error {
+		// The local map holds the lease while the handler runs
+		_, ok, _ := runner.localLeases.Acquire(ctx, msg.MessageID, time.Minute)
+		held.Store(!ok)
+		return nil
+	}
+
+	client := &fakeSQS{messages: makeMessages(1)}
+	deleted := make(chan struct{}, 1)
+	client.OnDelete = func(handle string) { deleted <- struct{}{} }
+	poller := NewPoller(client, "http://example.com/queue")
+	runner = NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(store, 45*time.Second).
+		WithLeaseFailover(LeaseFailover{Policy: LeaseFailLocal})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-deleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for message to be processed")
+	}
+	cancel()
+	<-done
+
+	if !held.Load() {
+		t.Error("expected local lease to be held during the handler")
+	}
+	if _, ok, _ := runner.localLeases.Acquire(context.Background(), "1", time.Minute); !ok {
+		t.Error("expected local lease to be released after the handler")
+	}
+}
+
+func TestRunner_LeaseFailLocal_WithoutLeaseStore(t *testing.T) {
+	client := &fakeSQS{messages: makeMessages(1)}
+	deleted := make(chan struct{}, 1)
+	client.OnDelete = func(handle string) { deleted <- struct{}{} }
+	poller := NewPoller(client, "http://example.com/queue")
+	handler := func(ctx context.Context, msg *Message) error {
+		return nil
+	}
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseFailover(LeaseFailover{Policy: LeaseFailLocal})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-deleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for message to be processed")
+	}
+	cancel()
+	<-done
+
+	if runner.localLeases != nil {
+		t.Error("expected no local lease store without a lease store")
+	}
+}
+
+func TestRunner_LeaseStoreRecovers(t *testing.T) {
+	store := newDownLeaseStore()
+	client := &fakeSQS{}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	events := make(chan Event, 10)
+	runner := NewRunner(poller, func(ctx context.Context, msg *Message) error { return nil }, 1, 1).
+		WithLeaseStore(store, 45*time.Second).
+		WithLeaseFailover(LeaseFailover{HealthInterval: 10 * time.Millisecond}).
+		WithEventHandler(func(e Event) { events <- e })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	waitEvent := func(typ EventType) {
+		t.Helper()
+		for {
+			select {
+			case e := <-events:
+				if e.Type == typ {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("timeout waiting for %s", typ)
+			}
+		}
+	}
+
+	waitEvent(EventLeaseStoreDown)
+	store.down.Store(false)
+	waitEvent(EventLeaseStoreUp)
+
+	cancel()
+	<-done
+	if runner.Status().LeaseStoreDown {
+		t.Error("expected lease store to be reported up")
+	}
+}
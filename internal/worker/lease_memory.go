@@ -3,54 +3,245 @@ package worker
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// MemoryLeaseStore is a test-only implementation.
-// It does not enforce TTL; use Expire to simulate lease expiry.
+const memoryLeaseShards = 32
+
+// MemoryLeaseStore keeps leases and completion records in process. It
+// enforces TTLs and retention like RedisLeaseStore, so a single replica can
+// dedupe without Redis; it does nothing across replicas.
+//
+// Expired entries are ignored as soon as they lapse, but only reclaimed by
+// RunJanitor or by touching the key again.
 type MemoryLeaseStore struct {
+	shards [memoryLeaseShards]memoryLeaseShard
+	fence  atomic.Int64
+	now    func() time.Time
+}
+
+type memoryLeaseShard struct {
 	mu     sync.Mutex
-	leases map[string]string
+	leases map[string]memoryLease
+	// done maps completed keys to when their record expires
+	done map[string]time.Time
+}
+
+type memoryLease struct {
+	token   string
+	expires time.Time
 }
 
 func NewMemoryLeaseStore() *MemoryLeaseStore {
-	return &MemoryLeaseStore{
-		leases: make(map[string]string),
+	m := &MemoryLeaseStore{now: time.Now}
+	for i := range m.shards {
+		m.shards[i].leases = make(map[string]memoryLease)
+		m.shards[i].done = make(map[string]time.Time)
+	}
+	return m
+}
+
+// WithClock replaces time.Now, e.g. to drive expiry from a fake clock.
+func (m *MemoryLeaseStore) WithClock(now func() time.Time) *MemoryLeaseStore {
+	m.now = now
+	return m
+}
+
+func (m *MemoryLeaseStore) shard(key string) *memoryLeaseShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &m.shards[h.Sum32()%memoryLeaseShards]
+}
+
+// lease returns the unexpired lease on key. Callers hold s.mu.
+func (s *memoryLeaseShard) lease(key string, now time.Time) (memoryLease, bool) {
+	l, ok := s.leases[key]
+	if !ok {
+		return memoryLease{}, false
+	}
+	if !now.Before(l.expires) {
+		delete(s.leases, key)
+		return memoryLease{}, false
 	}
+	return l, true
 }
 
 func (m *MemoryLeaseStore) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token, _, ok, err := m.AcquireFenced(ctx, key, ttl)
+	return token, ok, err
+}
+
+func (m *MemoryLeaseStore) AcquireFenced(ctx context.Context, key string, ttl time.Duration) (string, int64, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, false, err
+	}
 	if ttl <= 0 {
-		return "", false, fmt.Errorf("lease ttl must be > 0")
+		return "", 0, false, fmt.Errorf("lease ttl must be > 0")
 	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if _, exists := m.leases[key]; exists {
-		return "", false, nil
+	now := m.now()
+	if _, held := s.lease(key, now); held {
+		return "", 0, false, nil
 	}
 
 	token := uuid.New().String()
-	m.leases[key] = token
-	return token, true, nil
+	s.leases[key] = memoryLease{token: token, expires: now.Add(ttl)}
+	return token, m.fence.Add(1), true, nil
 }
 
 func (m *MemoryLeaseStore) Release(ctx context.Context, key string, token string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if m.leases[key] == token {
-		delete(m.leases, key)
+	if l, held := s.lease(key, m.now()); held && l.token == token {
+		delete(s.leases, key)
+	}
+	return nil
+}
+
+func (m *MemoryLeaseStore) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if ttl <= 0 {
+		return false, fmt.Errorf("lease ttl must be > 0")
+	}
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	l, held := s.lease(key, now)
+	if !held || l.token != token {
+		return false, nil
+	}
+	l.expires = now.Add(ttl)
+	s.leases[key] = l
+	return true, nil
+}
+
+// TTL reports how long the lease on key has left, or a negative duration if
+// it isn't held.
+func (m *MemoryLeaseStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	l, held := s.lease(key, now)
+	if !held {
+		return -1, nil
+	}
+	return l.expires.Sub(now), nil
+}
+
+func (m *MemoryLeaseStore) Completed(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.done[key]
+	if !ok {
+		return false, nil
+	}
+	if !m.now().Before(expires) {
+		delete(s.done, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *MemoryLeaseStore) Complete(ctx context.Context, key string, token string, retention time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if retention <= 0 {
+		return fmt.Errorf("completion retention must be > 0")
+	}
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	s.done[key] = now.Add(retention)
+	if l, held := s.lease(key, now); held && l.token == token {
+		delete(s.leases, key)
 	}
 	return nil
 }
 
 // Expire forces a lease to expire. Test use only.
 func (m *MemoryLeaseStore) Expire(key string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	delete(m.leases, key)
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, key)
+}
+
+// RunJanitor removes expired leases and completion records every interval
+// until ctx is done. It returns at once if interval is not positive.
+func (m *MemoryLeaseStore) RunJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweep()
+		}
+	}
+}
+
+func (m *MemoryLeaseStore) sweep() {
+	now := m.now()
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for key, l := range s.leases {
+			if !now.Before(l.expires) {
+				delete(s.leases, key)
+			}
+		}
+		for key, expires := range s.done {
+			if !now.Before(expires) {
+				delete(s.done, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// size counts stored leases and completion records, expired or not.
+func (m *MemoryLeaseStore) size() (leases, done int) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		leases += len(s.leases)
+		done += len(s.done)
+		s.mu.Unlock()
+	}
+	return leases, done
 }
@@ -9,7 +9,20 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-const leaseKeyPrefix = "lease:"
+const (
+	leaseKeyPrefix  = "lease:"
+	doneKeyPrefix   = "done:"
+	defaultFenceKey = "lease-fence"
+)
+
+// acquireScript takes the lease (KEYS[1]) and returns the next fence from
+// the counter at KEYS[2], or 0 if the lease is held.
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
 
 var releaseScript = redis.NewScript(`
 if redis.call("GET", KEYS[1]) == ARGV[1] then
@@ -18,32 +31,170 @@ end
 return 0
 `)
 
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// completeScript writes the completion record (KEYS[2]) and releases the
+// lease (KEYS[1]) if ARGV[1] still holds it.
+var completeScript = redis.NewScript(`
+redis.call("SET", KEYS[2], "1", "PX", ARGV[2])
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return 1
+`)
+
+// RedisLeaseStore keeps leases in Redis. It works with any
+// redis.UniversalClient: a single node, Sentinel failover or a cluster.
 type RedisLeaseStore struct {
-	client *redis.Client
+	client      redis.UniversalClient
+	leasePrefix string
+	donePrefix  string
+	fenceKey    string
+	// hashTag wraps keys in {} so a key's lease, fence and completion record
+	// land in the same cluster slot, as the scripts touch them together.
+	hashTag bool
+}
+
+func NewRedisLeaseStore(client redis.UniversalClient) *RedisLeaseStore {
+	_, cluster := client.(*redis.ClusterClient)
+	return &RedisLeaseStore{
+		client:      client,
+		leasePrefix: leaseKeyPrefix,
+		donePrefix:  doneKeyPrefix,
+		fenceKey:    defaultFenceKey,
+		hashTag:     cluster,
+	}
+}
+
+// WithHashTags sets whether keys are wrapped in {} for a cluster. It
+// defaults to whether client is a *redis.ClusterClient; pass
+// RedisOptions.Cluster for a client that hides its type.
+func (r *RedisLeaseStore) WithHashTags(on bool) *RedisLeaseStore {
+	r.hashTag = on
+	return r
+}
+
+// WithKeyPrefix replaces the "lease:" prefix, e.g. to share one Redis
+// between tenants.
+func (r *RedisLeaseStore) WithKeyPrefix(prefix string) *RedisLeaseStore {
+	r.leasePrefix = prefix
+	return r
+}
+
+// WithCompletionPrefix replaces the "done:" prefix of completion records.
+func (r *RedisLeaseStore) WithCompletionPrefix(prefix string) *RedisLeaseStore {
+	r.donePrefix = prefix
+	return r
+}
+
+// WithFenceKey replaces the "lease-fence" key holding the fencing counter,
+// or prefixing the per-key counters when keys are hash-tagged. Stores
+// sharing a fence key share one sequence.
+func (r *RedisLeaseStore) WithFenceKey(key string) *RedisLeaseStore {
+	r.fenceKey = key
+	return r
+}
+
+func (r *RedisLeaseStore) leaseKey(key string) string {
+	if r.hashTag {
+		return r.leasePrefix + "{" + key + "}"
+	}
+	return r.leasePrefix + key
 }
 
-func NewRedisLeaseStore(client *redis.Client) *RedisLeaseStore {
-	return &RedisLeaseStore{client: client}
+func (r *RedisLeaseStore) doneKey(key string) string {
+	if r.hashTag {
+		return r.donePrefix + "{" + key + "}"
+	}
+	return r.donePrefix + key
+}
+
+// fenceKeyFor is the fencing counter for key. With hash tags each key has
+// its own counter in the lease key's slot. It is kept without an expiry: a
+// counter that reset would hand out fences lower than ones storage has
+// already accepted.
+func (r *RedisLeaseStore) fenceKeyFor(key string) string {
+	if r.hashTag {
+		return r.fenceKey + ":{" + key + "}"
+	}
+	return r.fenceKey
 }
 
 func (r *RedisLeaseStore) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token, _, ok, err := r.AcquireFenced(ctx, key, ttl)
+	return token, ok, err
+}
+
+// AcquireFenced takes the lease and a fence in one script. Fences come from
+// a single counter shared by all keys, so they only ever increase whatever
+// the key; with hash tags the counter is per key instead, as a script can't
+// reach a counter in another cluster slot.
+func (r *RedisLeaseStore) AcquireFenced(ctx context.Context, key string, ttl time.Duration) (string, int64, bool, error) {
 	if ttl <= 0 {
-		return "", false, fmt.Errorf("lease ttl must be > 0")
+		return "", 0, false, fmt.Errorf("lease ttl must be > 0")
 	}
 	token := uuid.New().String()
-	redisKey := leaseKeyPrefix + key
-	ok, err := r.client.SetNX(ctx, redisKey, token, ttl).Result()
+	keys := []string{r.leaseKey(key), r.fenceKeyFor(key)}
+
+	fence, err := acquireScript.Run(ctx, r.client, keys, token, ttl.Milliseconds()).Int64()
 	if err != nil {
-		return "", false, err
+		return "", 0, false, err
 	}
-	if !ok {
-		return "", false, nil
+	if fence == 0 {
+		return "", 0, false, nil
 	}
-	return token, true, nil
+	return token, fence, true, nil
 }
 
 func (r *RedisLeaseStore) Release(ctx context.Context, key string, token string) error {
-	redisKey := leaseKeyPrefix + key
+	redisKey := r.leaseKey(key)
 	_, err := releaseScript.Run(ctx, r.client, []string{redisKey}, token).Result()
 	return err
 }
+
+func (r *RedisLeaseStore) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("lease ttl must be > 0")
+	}
+	redisKey := r.leaseKey(key)
+	n, err := extendScript.Run(ctx, r.client, []string{redisKey}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *RedisLeaseStore) Completed(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, r.doneKey(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *RedisLeaseStore) Complete(ctx context.Context, key string, token string, retention time.Duration) error {
+	if retention <= 0 {
+		return fmt.Errorf("completion retention must be > 0")
+	}
+	keys := []string{r.leaseKey(key), r.doneKey(key)}
+	return completeScript.Run(ctx, r.client, keys, token, retention.Milliseconds()).Err()
+}
+
+// TTL reports how long the lease on key has left, or a negative duration if
+// it isn't held.
+func (r *RedisLeaseStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, r.leaseKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (r *RedisLeaseStore) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
@@ -4,14 +4,25 @@ package worker_test
 
 import (
 	"context"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/leasetest"
 
 	"github.com/redis/go-redis/v9"
 )
 
+func TestRedisLeaseStore_Conformance(t *testing.T) {
+	leasetest.RunConformance(t, func(t *testing.T) worker.LeaseStore {
+		client, _ := newTestRedis(t)
+		return worker.NewRedisLeaseStore(client)
+	})
+}
+
 func TestRedisLeaseStore_AcquireRelease(t *testing.T) {
 	client, ctx := newTestRedis(t)
 	store := worker.NewRedisLeaseStore(client)
@@ -135,7 +146,7 @@ func newTestRedis(t *testing.T) (*redis.Client, context.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	t.Cleanup(cancel)
 
-	c := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	c := redis.NewClient(&redis.Options{Addr: testRedisAddr()})
 	t.Cleanup(func() { _ = c.Close() })
 
 	if err := c.Ping(ctx).Err(); err != nil {
@@ -147,3 +158,344 @@ func newTestRedis(t *testing.T) (*redis.Client, context.Context) {
 	}
 	return c, ctx
 }
+
+func TestRedisLeaseStore_Extend(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	store := worker.NewRedisLeaseStore(client)
+
+	token, ok, err := store.Acquire(ctx, "extend-1", 200*time.Millisecond)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+
+	// Wrong token can't extend
+	ok, err = store.Extend(ctx, "extend-1", "wrong-token", 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected extend with wrong token to fail")
+	}
+
+	ok, err = store.Extend(ctx, "extend-1", token, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected extend to succeed")
+	}
+
+	if pttl := client.PTTL(ctx, "lease:extend-1").Val(); pttl <= time.Second {
+		t.Fatalf("expected TTL to be reset to ~5s, got %v", pttl)
+	}
+
+	// Once released the token no longer extends
+	if err := store.Release(ctx, "extend-1", token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ok, err = store.Extend(ctx, "extend-1", token, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected extend after release to fail")
+	}
+}
+
+func TestRedisLeaseStore_FencesIncrease(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	store := worker.NewRedisLeaseStore(client)
+
+	token1, fence1, ok, err := store.AcquireFenced(ctx, "fence-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+	if fence1 <= 0 {
+		t.Fatalf("expected positive fence, got %d", fence1)
+	}
+
+	// A failed acquire doesn't consume a fence
+	if _, _, ok, _ := store.AcquireFenced(ctx, "fence-1", 5*time.Second); ok {
+		t.Fatal("expected second acquire to fail")
+	}
+
+	_ = store.Release(ctx, "fence-1", token1)
+	_, fence2, ok, err := store.AcquireFenced(ctx, "fence-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to reacquire lease, ok=%v err=%v", ok, err)
+	}
+	if fence2 != fence1+1 {
+		t.Fatalf("expected fence %d, got %d", fence1+1, fence2)
+	}
+
+	// Other keys draw from the same counter
+	_, fence3, _, _ := store.AcquireFenced(ctx, "fence-2", 5*time.Second)
+	if fence3 <= fence2 {
+		t.Fatalf("expected fence > %d, got %d", fence2, fence3)
+	}
+	if got := client.Get(ctx, "lease-fence").Val(); got != strconv.FormatInt(fence3, 10) {
+		t.Fatalf("expected counter at %d, got %q", fence3, got)
+	}
+}
+
+func TestRedisLeaseStore_HashTaggedFencesPerKey(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	store := worker.NewRedisLeaseStore(client).WithHashTags(true)
+
+	token, fence1, ok, err := store.AcquireFenced(ctx, "tagged-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+	_ = store.Release(ctx, "tagged-1", token)
+	_, fence2, ok, err := store.AcquireFenced(ctx, "tagged-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to reacquire lease, ok=%v err=%v", ok, err)
+	}
+	if fence2 != fence1+1 {
+		t.Fatalf("expected fence %d, got %d", fence1+1, fence2)
+	}
+
+	// The counter shares the lease key's hash tag, so one script sets both
+	if got := client.Get(ctx, "lease-fence:{tagged-1}").Val(); got != strconv.FormatInt(fence2, 10) {
+		t.Fatalf("expected per-key counter at %d, got %q", fence2, got)
+	}
+	if n := client.Exists(ctx, "lease-fence").Val(); n != 0 {
+		t.Fatal("expected the shared counter untouched")
+	}
+}
+
+func TestRedisLeaseStore_CompleteRecordsAndReleases(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	store := worker.NewRedisLeaseStore(client)
+
+	token, ok, err := store.Acquire(ctx, "complete-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+
+	done, err := store.Completed(ctx, "complete-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if done {
+		t.Fatal("expected no completion record yet")
+	}
+
+	if err := store.Complete(ctx, "complete-1", token, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done, err = store.Completed(ctx, "complete-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !done {
+		t.Fatal("expected completion record")
+	}
+	if pttl := client.PTTL(ctx, "done:complete-1").Val(); pttl <= 0 || pttl > time.Minute {
+		t.Fatalf("expected completion record to expire within retention, got %v", pttl)
+	}
+
+	// Lease was released in the same script
+	if _, ok, _ := store.Acquire(ctx, "complete-1", 5*time.Second); !ok {
+		t.Fatal("expected lease to be released by Complete")
+	}
+}
+
+func TestRedisLeaseStore_CompleteKeepsOtherHoldersLease(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	store := worker.NewRedisLeaseStore(client)
+
+	if _, ok, _ := store.Acquire(ctx, "complete-2", 5*time.Second); !ok {
+		t.Fatal("expected to acquire lease")
+	}
+
+	if err := store.Complete(ctx, "complete-2", "stale-token", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok, _ := store.Acquire(ctx, "complete-2", 5*time.Second); ok {
+		t.Fatal("expected current holder's lease to survive a stale Complete")
+	}
+}
+
+func TestRedisLeaseStore_TTL(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	store := worker.NewRedisLeaseStore(client)
+
+	if _, ok, _ := store.Acquire(ctx, "ttl-1", 5*time.Second); !ok {
+		t.Fatal("expected to acquire lease")
+	}
+
+	ttl, err := store.TTL(ctx, "ttl-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl <= 0 || ttl > 5*time.Second {
+		t.Fatalf("expected TTL in (0, 5s], got %v", ttl)
+	}
+
+	ttl, err = store.TTL(ctx, "ttl-missing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl >= 0 {
+		t.Fatalf("expected negative TTL for missing lease, got %v", ttl)
+	}
+}
+
+func TestRedisLeaseStore_KeyPrefix(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	tenantA := worker.NewRedisLeaseStore(client).
+		WithKeyPrefix("tenant-a:lease:").
+		WithCompletionPrefix("tenant-a:done:")
+	tenantB := worker.NewRedisLeaseStore(client).
+		WithKeyPrefix("tenant-b:lease:").
+		WithCompletionPrefix("tenant-b:done:")
+
+	tokenA, ok, err := tenantA.Acquire(ctx, "prefix-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+	if got := client.Get(ctx, "tenant-a:lease:prefix-1").Val(); got != tokenA {
+		t.Fatalf("expected lease under tenant-a prefix, got %q", got)
+	}
+
+	// Same key under another prefix is independent
+	if _, ok, _ := tenantB.Acquire(ctx, "prefix-1", 5*time.Second); !ok {
+		t.Fatal("expected tenant-b to acquire its own lease")
+	}
+
+	if err := tenantA.Complete(ctx, "prefix-1", tokenA, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := client.Exists(ctx, "tenant-a:done:prefix-1").Val(); n != 1 {
+		t.Fatal("expected completion record under tenant-a prefix")
+	}
+	if done, _ := tenantB.Completed(ctx, "prefix-1"); done {
+		t.Fatal("expected tenant-b not to see tenant-a's completion record")
+	}
+}
+
+func TestRedisLeaseStore_UniversalClientDB(t *testing.T) {
+	plain, ctx := newTestRedis(t)
+
+	client, err := worker.NewRedisClient(worker.RedisOptions{
+		Addrs:    []string{testRedisAddr()},
+		DB:       1,
+		PoolSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if err := client.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("flushdb failed: %v", err)
+	}
+
+	exerciseLeaseStore(t, ctx, worker.NewRedisLeaseStore(client))
+
+	// Keys went to DB 1, not the default DB
+	if _, ok, _ := worker.NewRedisLeaseStore(client).Acquire(ctx, "db-check", 5*time.Second); !ok {
+		t.Fatal("expected to acquire lease")
+	}
+	if n := plain.Exists(ctx, "lease:db-check").Val(); n != 0 {
+		t.Fatal("expected lease not to be written to DB 0")
+	}
+}
+
+// The deployments below need infrastructure the default docker-compose
+// setup doesn't provide; point the env vars at one to run them.
+
+func TestRedisLeaseStore_AuthAndTLS(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_SECURE_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_SECURE_ADDR not set")
+	}
+	client := newTestUniversalRedis(t, worker.RedisOptions{
+		Addrs:     []string{addr},
+		Username:  os.Getenv("REDIS_TEST_USERNAME"),
+		Password:  os.Getenv("REDIS_TEST_PASSWORD"),
+		TLS:       os.Getenv("REDIS_TEST_TLS") == "true",
+		TLSCAFile: os.Getenv("REDIS_TEST_TLS_CA_FILE"),
+	})
+	ctx := context.Background()
+	exerciseLeaseStore(t, ctx, worker.NewRedisLeaseStore(client).WithKeyPrefix("it:lease:").WithCompletionPrefix("it:done:"))
+}
+
+func TestRedisLeaseStore_Sentinel(t *testing.T) {
+	addrs := os.Getenv("REDIS_TEST_SENTINEL_ADDRS")
+	master := os.Getenv("REDIS_TEST_SENTINEL_MASTER")
+	if addrs == "" || master == "" {
+		t.Skip("REDIS_TEST_SENTINEL_ADDRS / REDIS_TEST_SENTINEL_MASTER not set")
+	}
+	client := newTestUniversalRedis(t, worker.RedisOptions{
+		Addrs:      strings.Split(addrs, ","),
+		MasterName: master,
+		Password:   os.Getenv("REDIS_TEST_PASSWORD"),
+	})
+	exerciseLeaseStore(t, context.Background(), worker.NewRedisLeaseStore(client))
+}
+
+func TestRedisLeaseStore_Cluster(t *testing.T) {
+	addrs := os.Getenv("REDIS_TEST_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("REDIS_TEST_CLUSTER_ADDRS not set")
+	}
+	client := newTestUniversalRedis(t, worker.RedisOptions{
+		Addrs:    strings.Split(addrs, ","),
+		Password: os.Getenv("REDIS_TEST_PASSWORD"),
+	})
+	// Complete touches the lease and completion keys in one script, which
+	// fails with CROSSSLOT unless they share a hash slot
+	exerciseLeaseStore(t, context.Background(), worker.NewRedisLeaseStore(client))
+}
+
+// exerciseLeaseStore runs an acquire/extend/complete round trip.
+func exerciseLeaseStore(t *testing.T, ctx context.Context, store *worker.RedisLeaseStore) {
+	t.Helper()
+	key := "roundtrip-" + time.Now().Format("150405.000000000")
+
+	token, ok, err := store.Acquire(ctx, key, 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := store.Acquire(ctx, key, 5*time.Second); ok {
+		t.Fatal("expected lease to be held")
+	}
+	if ok, err := store.Extend(ctx, key, token, 5*time.Second); err != nil || !ok {
+		t.Fatalf("expected extend to succeed, ok=%v err=%v", ok, err)
+	}
+	if err := store.Complete(ctx, key, token, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if done, err := store.Completed(ctx, key); err != nil || !done {
+		t.Fatalf("expected completion record, done=%v err=%v", done, err)
+	}
+	if _, ok, _ := store.Acquire(ctx, key, 5*time.Second); !ok {
+		t.Fatal("expected lease to be released by Complete")
+	}
+}
+
+func newTestUniversalRedis(t *testing.T, opts worker.RedisOptions) redis.UniversalClient {
+	t.Helper()
+	client, err := worker.NewRedisClient(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatalf("redis unreachable: %v", err)
+	}
+	return client
+}
+
+func testRedisAddr() string {
+	if addr := os.Getenv("REDIS_TEST_ADDR"); addr != "" {
+		return addr
+	}
+	return "localhost:6379"
+}
@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedlockLeaseStore holds leases on a majority of independent Redis nodes,
+// so losing a node, or a failover that drops its data, can't hand the same
+// lease to two workers. It follows the Redlock algorithm: a lease is valid
+// for its TTL minus the time taken to acquire it and an allowance for clock
+// drift between nodes.
+type RedlockLeaseStore struct {
+	nodes       []redis.UniversalClient
+	quorum      int
+	leasePrefix string
+	donePrefix  string
+	// driftFactor is the fraction of the TTL allowed for clock drift
+	driftFactor float64
+	nodeTimeout time.Duration
+}
+
+// NewRedlockLeaseStore spreads leases over nodes, which must be independent
+// deployments rather than replicas of one another. Use an odd number, at
+// least three, to tolerate failures.
+func NewRedlockLeaseStore(nodes []redis.UniversalClient) (*RedlockLeaseStore, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("redlock: no nodes")
+	}
+	return &RedlockLeaseStore{
+		nodes:       nodes,
+		quorum:      len(nodes)/2 + 1,
+		leasePrefix: leaseKeyPrefix,
+		donePrefix:  doneKeyPrefix,
+		driftFactor: 0.01,
+		nodeTimeout: 250 * time.Millisecond,
+	}, nil
+}
+
+// WithKeyPrefix replaces the "lease:" prefix.
+func (r *RedlockLeaseStore) WithKeyPrefix(prefix string) *RedlockLeaseStore {
+	r.leasePrefix = prefix
+	return r
+}
+
+// WithCompletionPrefix replaces the "done:" prefix of completion records.
+func (r *RedlockLeaseStore) WithCompletionPrefix(prefix string) *RedlockLeaseStore {
+	r.donePrefix = prefix
+	return r
+}
+
+// WithClockDrift sets the fraction of the TTL subtracted from a lease's
+// validity for clock drift between nodes. Defaults to 0.01.
+func (r *RedlockLeaseStore) WithClockDrift(factor float64) *RedlockLeaseStore {
+	r.driftFactor = factor
+	return r
+}
+
+// WithNodeTimeout bounds each call to a single node, so an unreachable node
+// doesn't eat into the lease's validity. Defaults to 250ms.
+func (r *RedlockLeaseStore) WithNodeTimeout(d time.Duration) *RedlockLeaseStore {
+	r.nodeTimeout = d
+	return r
+}
+
+// nodeResults tallies one operation across nodes.
+type nodeResults struct {
+	ok   int
+	errs int
+	err  error
+}
+
+// eachNode runs fn on every node concurrently, each bounded by the node
+// timeout.
+func (r *RedlockLeaseStore) eachNode(ctx context.Context, fn func(ctx context.Context, node redis.UniversalClient) (bool, error)) nodeResults {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		res nodeResults
+	)
+	for _, node := range r.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, r.nodeTimeout)
+			ok, err := fn(nodeCtx, node)
+			cancel()
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				res.errs++
+				if res.err == nil {
+					res.err = err
+				}
+			case ok:
+				res.ok++
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// unreachable reports whether too many nodes failed for a quorum to be
+// possible, as opposed to the lease simply being held.
+func (r *RedlockLeaseStore) unreachable(res nodeResults) error {
+	if res.errs > len(r.nodes)-r.quorum {
+		return fmt.Errorf("redlock: %d of %d nodes failed: %w", res.errs, len(r.nodes), res.err)
+	}
+	return nil
+}
+
+// validity is what's left of ttl after elapsed and the drift allowance.
+func (r *RedlockLeaseStore) validity(ttl, elapsed time.Duration) time.Duration {
+	drift := time.Duration(float64(ttl)*r.driftFactor) + 2*time.Millisecond
+	return ttl - elapsed - drift
+}
+
+func (r *RedlockLeaseStore) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	if ttl <= 0 {
+		return "", false, fmt.Errorf("lease ttl must be > 0")
+	}
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+	token := uuid.New().String()
+	redisKey := r.leasePrefix + key
+
+	start := time.Now()
+	res := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) (bool, error) {
+		return node.SetNX(ctx, redisKey, token, ttl).Result()
+	})
+	if res.ok >= r.quorum && r.validity(ttl, time.Since(start)) > 0 {
+		return token, true, nil
+	}
+
+	// Undo the partial acquisition so the key frees up before the TTL
+	r.release(context.WithoutCancel(ctx), redisKey, token)
+	if err := r.unreachable(res); err != nil {
+		return "", false, err
+	}
+	return "", false, nil
+}
+
+func (r *RedlockLeaseStore) release(ctx context.Context, redisKey, token string) nodeResults {
+	return r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) (bool, error) {
+		return true, releaseScript.Run(ctx, node, []string{redisKey}, token).Err()
+	})
+}
+
+// Release deletes the lease from every node that token still holds it on.
+// It fails only if a majority of nodes couldn't be reached.
+func (r *RedlockLeaseStore) Release(ctx context.Context, key string, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.unreachable(r.release(ctx, r.leasePrefix+key, token))
+}
+
+// Extend succeeds if a majority of nodes extended the lease within its new
+// validity.
+func (r *RedlockLeaseStore) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("lease ttl must be > 0")
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	redisKey := r.leasePrefix + key
+
+	start := time.Now()
+	res := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) (bool, error) {
+		n, err := extendScript.Run(ctx, node, []string{redisKey}, token, ttl.Milliseconds()).Int()
+		return n == 1, err
+	})
+	if res.ok >= r.quorum && r.validity(ttl, time.Since(start)) > 0 {
+		return true, nil
+	}
+	if err := r.unreachable(res); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Completed reports true if any node has the completion record. It errors
+// only when no record was found and a majority of nodes couldn't answer.
+func (r *RedlockLeaseStore) Completed(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	doneKey := r.donePrefix + key
+	res := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) (bool, error) {
+		n, err := node.Exists(ctx, doneKey).Result()
+		return n > 0, err
+	})
+	if res.ok > 0 {
+		return true, nil
+	}
+	return false, r.unreachable(res)
+}
+
+// Complete writes the completion record and releases the lease on every
+// node, and fails unless a majority succeeded.
+func (r *RedlockLeaseStore) Complete(ctx context.Context, key string, token string, retention time.Duration) error {
+	if retention <= 0 {
+		return fmt.Errorf("completion retention must be > 0")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keys := []string{r.leasePrefix + key, r.donePrefix + key}
+	res := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) (bool, error) {
+		return true, completeScript.Run(ctx, node, keys, token, retention.Milliseconds()).Err()
+	})
+	if res.ok < r.quorum {
+		return fmt.Errorf("redlock: completion recorded on %d of %d nodes: %w", res.ok, len(r.nodes), res.err)
+	}
+	return nil
+}
+
+// Ping succeeds while a majority of nodes respond.
+func (r *RedlockLeaseStore) Ping(ctx context.Context) error {
+	res := r.eachNode(ctx, func(ctx context.Context, node redis.UniversalClient) (bool, error) {
+		return true, node.Ping(ctx).Err()
+	})
+	return r.unreachable(res)
+}
@@ -0,0 +1,146 @@
+//go:build integration
+
+package worker_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/leasetest"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedlockNodes returns three nodes from REDLOCK_TEST_ADDRS, or three
+// databases of the test Redis standing in for independent instances.
+func newTestRedlockNodes(t *testing.T) []*redis.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var opts []*redis.Options
+	if addrs := os.Getenv("REDLOCK_TEST_ADDRS"); addrs != "" {
+		for _, addr := range strings.Split(addrs, ",") {
+			opts = append(opts, &redis.Options{Addr: addr})
+		}
+	} else {
+		for db := 1; db <= 3; db++ {
+			opts = append(opts, &redis.Options{Addr: testRedisAddr(), DB: db})
+		}
+	}
+
+	var nodes []*redis.Client
+	for _, o := range opts {
+		c := redis.NewClient(o)
+		t.Cleanup(func() { _ = c.Close() })
+		if err := c.Ping(ctx).Err(); err != nil {
+			t.Skipf("redis unreachable: %v", err)
+		}
+		if err := c.FlushDB(ctx).Err(); err != nil {
+			t.Fatalf("flushdb failed: %v", err)
+		}
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
+
+func newTestRedlock(t *testing.T, nodes ...redis.UniversalClient) *worker.RedlockLeaseStore {
+	t.Helper()
+	store, err := worker.NewRedlockLeaseStore(nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return store
+}
+
+func TestRedlockLeaseStore_Conformance(t *testing.T) {
+	leasetest.RunConformance(t, func(t *testing.T) worker.LeaseStore {
+		nodes := newTestRedlockNodes(t)
+		return newTestRedlock(t, nodes[0], nodes[1], nodes[2])
+	})
+}
+
+func TestRedlockLeaseStore_AcquiresOnEveryNode(t *testing.T) {
+	nodes := newTestRedlockNodes(t)
+	store := newTestRedlock(t, nodes[0], nodes[1], nodes[2])
+	ctx := context.Background()
+
+	token, ok, err := store.Acquire(ctx, "job-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire lease, ok=%v err=%v", ok, err)
+	}
+	for i, node := range nodes {
+		if got := node.Get(ctx, "lease:job-1").Val(); got != token {
+			t.Errorf("node %d: expected token %q, got %q", i, token, got)
+		}
+	}
+
+	if err := store.Release(ctx, "job-1", token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, node := range nodes {
+		if n := node.Exists(ctx, "lease:job-1").Val(); n != 0 {
+			t.Errorf("node %d: expected lease to be released", i)
+		}
+	}
+}
+
+func TestRedlockLeaseStore_MinorityIsNotEnough(t *testing.T) {
+	nodes := newTestRedlockNodes(t)
+	store := newTestRedlock(t, nodes[0], nodes[1], nodes[2])
+	ctx := context.Background()
+
+	// Someone else holds the key on two of three nodes
+	nodes[0].Set(ctx, "lease:job-1", "other", time.Minute)
+	nodes[1].Set(ctx, "lease:job-1", "other", time.Minute)
+
+	_, ok, err := store.Acquire(ctx, "job-1", 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected acquire with only one node to fail")
+	}
+	// The partial lease on the free node was undone
+	if n := nodes[2].Exists(ctx, "lease:job-1").Val(); n != 0 {
+		t.Fatal("expected partial lease to be released")
+	}
+	if got := nodes[0].Get(ctx, "lease:job-1").Val(); got != "other" {
+		t.Fatalf("expected other holder's lease to be kept, got %q", got)
+	}
+}
+
+func TestRedlockLeaseStore_ToleratesMinorityDown(t *testing.T) {
+	nodes := newTestRedlockNodes(t)
+	down := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { _ = down.Close() })
+	store := newTestRedlock(t, nodes[0], nodes[1], down)
+	ctx := context.Background()
+
+	token, ok, err := store.Acquire(ctx, "job-1", 5*time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire with one node down, ok=%v err=%v", ok, err)
+	}
+	if ok, err := store.Extend(ctx, "job-1", token, 5*time.Second); err != nil || !ok {
+		t.Fatalf("expected to extend with one node down, ok=%v err=%v", ok, err)
+	}
+	if err := store.Complete(ctx, "job-1", token, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if done, err := store.Completed(ctx, "job-1"); err != nil || !done {
+		t.Fatalf("expected completion record, done=%v err=%v", done, err)
+	}
+	if err := store.Ping(ctx); err != nil {
+		t.Fatalf("expected ping to pass with a majority up: %v", err)
+	}
+
+	// Without a majority the store reports an error rather than contention
+	lonely := newTestRedlock(t, nodes[0], down, down)
+	if _, _, err := lonely.Acquire(ctx, "job-2", 5*time.Second); err == nil {
+		t.Fatal("expected error with a majority down")
+	}
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func unreachableRedis(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestNewRedlockLeaseStore_RequiresNodes(t *testing.T) {
+	if _, err := NewRedlockLeaseStore(nil); err == nil {
+		t.Fatal("expected error without nodes")
+	}
+}
+
+func TestRedlockLeaseStore_Quorum(t *testing.T) {
+	for n, want := range map[int]int{1: 1, 2: 2, 3: 2, 4: 3, 5: 3} {
+		nodes := make([]redis.UniversalClient, n)
+		store, err := NewRedlockLeaseStore(nodes)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if store.quorum != want {
+			t.Errorf("%d nodes: expected quorum %d, got %d", n, want, store.quorum)
+		}
+	}
+}
+
+func TestRedlockLeaseStore_ValiditySubtractsDrift(t *testing.T) {
+	store, _ := NewRedlockLeaseStore(make([]redis.UniversalClient, 3))
+
+	// 10s TTL, 1% drift plus 2ms, 300ms spent acquiring
+	if got, want := store.validity(10*time.Second, 300*time.Millisecond), 9598*time.Millisecond; got != want {
+		t.Fatalf("expected validity %v, got %v", want, got)
+	}
+	if got := store.validity(10*time.Millisecond, 9*time.Millisecond); got > 0 {
+		t.Fatalf("expected no validity left, got %v", got)
+	}
+}
+
+func TestRedlockLeaseStore_ErrorsWhenMajorityUnreachable(t *testing.T) {
+	store, _ := NewRedlockLeaseStore([]redis.UniversalClient{
+		unreachableRedis(t), unreachableRedis(t), unreachableRedis(t),
+	})
+	ctx := context.Background()
+
+	if _, ok, err := store.Acquire(ctx, "job-1", time.Minute); err == nil || ok {
+		t.Fatalf("expected acquire to fail with an error, got ok=%v err=%v", ok, err)
+	}
+	if err := store.Ping(ctx); err == nil {
+		t.Fatal("expected ping to fail")
+	}
+}
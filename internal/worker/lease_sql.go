@@ -0,0 +1,243 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SQLDialect selects the SQL flavour spoken by SQLLeaseStore.
+type SQLDialect int
+
+const (
+	DialectPostgres SQLDialect = iota
+	DialectSQLite
+)
+
+func (d SQLDialect) String() string {
+	switch d {
+	case DialectPostgres:
+		return "postgres"
+	case DialectSQLite:
+		return "sqlite"
+	}
+	return "unknown"
+}
+
+// nowMillis is the database clock in Unix milliseconds, so replicas with
+// skewed clocks still agree on expiry.
+func (d SQLDialect) nowMillis() string {
+	if d == DialectSQLite {
+		return "CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)"
+	}
+	return "CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000 AS BIGINT)"
+}
+
+// rebind rewrites $n placeholders for dialects that don't use them.
+func (d SQLDialect) rebind(query string) string {
+	if d == DialectSQLite {
+		return strings.ReplaceAll(query, "$", "?")
+	}
+	return query
+}
+
+const (
+	defaultLeaseTable      = "worker_leases"
+	defaultCompletionTable = "worker_completions"
+)
+
+// SQLLeaseStore keeps leases in a database/sql table with an expiry column,
+// for services that have Postgres or SQLite but no Redis. Expiry follows the
+// database clock. Call Migrate once to create the tables.
+//
+// With SQLite, limit the pool to one connection (db.SetMaxOpenConns(1)) or
+// set a busy timeout, as concurrent writers otherwise fail with SQLITE_BUSY.
+type SQLLeaseStore struct {
+	db         *sql.DB
+	dialect    SQLDialect
+	leaseTable string
+	doneTable  string
+}
+
+func NewSQLLeaseStore(db *sql.DB, dialect SQLDialect) *SQLLeaseStore {
+	return &SQLLeaseStore{
+		db:         db,
+		dialect:    dialect,
+		leaseTable: defaultLeaseTable,
+		doneTable:  defaultCompletionTable,
+	}
+}
+
+// WithTables replaces the "worker_leases" and "worker_completions" table
+// names. They are interpolated into queries and must be trusted.
+func (s *SQLLeaseStore) WithTables(leases, completions string) *SQLLeaseStore {
+	s.leaseTable = leases
+	s.doneTable = completions
+	return s
+}
+
+// Migrate creates the lease and completion tables if they don't exist.
+func (s *SQLLeaseStore) Migrate(ctx context.Context) error {
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	lease_key  TEXT PRIMARY KEY,
+	token      TEXT NOT NULL,
+	expires_at BIGINT NOT NULL
+)`, s.leaseTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	lease_key  TEXT PRIMARY KEY,
+	expires_at BIGINT NOT NULL
+)`, s.doneTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)`, s.leaseTable, s.leaseTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)`, s.doneTable, s.doneTable),
+	}
+	for _, stmt := range stmts {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate lease tables: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLLeaseStore) query(format string) string {
+	return s.dialect.rebind(strings.ReplaceAll(format, "{now}", s.dialect.nowMillis()))
+}
+
+func (s *SQLLeaseStore) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	if ttl <= 0 {
+		return "", false, fmt.Errorf("lease ttl must be > 0")
+	}
+	token := uuid.New().String()
+
+	// Takes over the row only once the previous lease has expired
+	q := s.query(fmt.Sprintf(`INSERT INTO %[1]s (lease_key, token, expires_at) VALUES ($1, $2, {now} + $3)
+ON CONFLICT (lease_key) DO UPDATE SET token = excluded.token, expires_at = excluded.expires_at
+WHERE %[1]s.expires_at <= {now}`, s.leaseTable))
+	res, err := s.db.ExecContext(ctx, q, key, token, ttl.Milliseconds())
+	if err != nil {
+		return "", false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", false, err
+	}
+	if n == 0 {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+func (s *SQLLeaseStore) Release(ctx context.Context, key string, token string) error {
+	q := s.query(fmt.Sprintf(`DELETE FROM %s WHERE lease_key = $1 AND token = $2`, s.leaseTable))
+	_, err := s.db.ExecContext(ctx, q, key, token)
+	return err
+}
+
+func (s *SQLLeaseStore) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("lease ttl must be > 0")
+	}
+	q := s.query(fmt.Sprintf(`UPDATE %s SET expires_at = {now} + $3
+WHERE lease_key = $1 AND token = $2 AND expires_at > {now}`, s.leaseTable))
+	res, err := s.db.ExecContext(ctx, q, key, token, ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// TTL reports how long the lease on key has left, or a negative duration if
+// it isn't held.
+func (s *SQLLeaseStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	q := s.query(fmt.Sprintf(`SELECT expires_at - {now} FROM %s WHERE lease_key = $1 AND expires_at > {now}`, s.leaseTable))
+	var ms int64
+	err := s.db.QueryRowContext(ctx, q, key).Scan(&ms)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (s *SQLLeaseStore) Completed(ctx context.Context, key string) (bool, error) {
+	q := s.query(fmt.Sprintf(`SELECT 1 FROM %s WHERE lease_key = $1 AND expires_at > {now}`, s.doneTable))
+	var one int
+	err := s.db.QueryRowContext(ctx, q, key).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SQLLeaseStore) Complete(ctx context.Context, key string, token string, retention time.Duration) error {
+	if retention <= 0 {
+		return fmt.Errorf("completion retention must be > 0")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	record := s.query(fmt.Sprintf(`INSERT INTO %s (lease_key, expires_at) VALUES ($1, {now} + $2)
+ON CONFLICT (lease_key) DO UPDATE SET expires_at = excluded.expires_at`, s.doneTable))
+	if _, err := tx.ExecContext(ctx, record, key, retention.Milliseconds()); err != nil {
+		return err
+	}
+	release := s.query(fmt.Sprintf(`DELETE FROM %s WHERE lease_key = $1 AND token = $2`, s.leaseTable))
+	if _, err := tx.ExecContext(ctx, release, key, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLLeaseStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// DeleteExpired removes lapsed leases and completion records. Expired rows
+// are ignored anyway; this only keeps the tables small.
+func (s *SQLLeaseStore) DeleteExpired(ctx context.Context) error {
+	for _, table := range []string{s.leaseTable, s.doneTable} {
+		q := s.query(fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= {now}`, table))
+		if _, err := s.db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunJanitor calls DeleteExpired every interval until ctx is done. It
+// returns at once if interval is not positive.
+func (s *SQLLeaseStore) RunJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				fmt.Printf("lease janitor error: %v\n", err)
+			}
+		}
+	}
+}
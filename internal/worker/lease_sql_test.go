@@ -0,0 +1,88 @@
+package worker_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/leasetest"
+)
+
+func newSQLiteLeaseStore(t *testing.T) (*worker.SQLLeaseStore, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "leases.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := worker.NewSQLLeaseStore(db, worker.DialectSQLite)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return store, db
+}
+
+func TestSQLLeaseStore_SQLiteConformance(t *testing.T) {
+	leasetest.RunConformance(t, func(t *testing.T) worker.LeaseStore {
+		store, _ := newSQLiteLeaseStore(t)
+		return store
+	})
+}
+
+func TestSQLLeaseStore_MigrateIsIdempotent(t *testing.T) {
+	store, _ := newSQLiteLeaseStore(t)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+}
+
+func TestSQLLeaseStore_DeleteExpired(t *testing.T) {
+	store, db := newSQLiteLeaseStore(t)
+	ctx := context.Background()
+
+	token, _, _ := store.Acquire(ctx, "short", 10*time.Millisecond)
+	_ = store.Complete(ctx, "short", token, 10*time.Millisecond)
+	_, _, _ = store.Acquire(ctx, "expiring", 10*time.Millisecond)
+	_, _, _ = store.Acquire(ctx, "long", time.Hour)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := store.DeleteExpired(ctx); err != nil {
+		t.Fatalf("delete expired: %v", err)
+	}
+
+	var leases, done int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM worker_leases`).Scan(&leases)
+	_ = db.QueryRow(`SELECT COUNT(*) FROM worker_completions`).Scan(&done)
+	if leases != 1 || done != 0 {
+		t.Fatalf("expected only the long lease to remain, got %d leases and %d records", leases, done)
+	}
+}
+
+func TestSQLLeaseStore_WithTables(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "leases.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	store := worker.NewSQLLeaseStore(db, worker.DialectSQLite).WithTables("tenant_a_leases", "tenant_a_done")
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, ok, err := store.Acquire(ctx, "job-1", time.Minute); err != nil || !ok {
+		t.Fatalf("expected to acquire, ok=%v err=%v", ok, err)
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tenant_a_leases`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("expected lease in tenant_a_leases, got %d (err %v)", n, err)
+	}
+}
@@ -2,6 +2,9 @@ package worker
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -131,3 +134,227 @@ func TestMemoryLeaseStore_Expire(t *testing.T) {
 		t.Fatal("expected to a new token upon re-acquire for the same job key")
 	}
 }
+
+func TestMemoryLeaseStore_Extend(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	token, _, _ := store.Acquire(ctx, "job-1", time.Minute)
+
+	ok, err := store.Extend(ctx, "job-1", token, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected extend with holder's token to succeed")
+	}
+
+	ok, err = store.Extend(ctx, "job-1", "wrong-token", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected extend with wrong token to fail")
+	}
+
+	store.Expire("job-1")
+
+	ok, err = store.Extend(ctx, "job-1", token, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected extend after expiry to fail")
+	}
+}
+
+func TestMemoryLeaseStore_CompleteReleasesAndRecords(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	token, _, _ := store.Acquire(ctx, "job-1", time.Minute)
+
+	done, err := store.Completed(ctx, "job-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if done {
+		t.Fatal("expected no completion record before Complete")
+	}
+
+	if err := store.Complete(ctx, "job-1", token, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done, err = store.Completed(ctx, "job-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !done {
+		t.Fatal("expected completion record after Complete")
+	}
+
+	// Complete also released the lease
+	if _, ok, _ := store.Acquire(ctx, "job-1", time.Minute); !ok {
+		t.Fatal("expected lease to be released by Complete")
+	}
+}
+
+func TestMemoryLeaseStore_FencesIncrease(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	token, fence1, ok, _ := store.AcquireFenced(ctx, "job-1", time.Minute)
+	if !ok || fence1 != 1 {
+		t.Fatalf("expected first fence 1, got %d (ok %v)", fence1, ok)
+	}
+	if _, _, ok, _ := store.AcquireFenced(ctx, "job-1", time.Minute); ok {
+		t.Fatal("expected second acquire to fail")
+	}
+
+	_ = store.Release(ctx, "job-1", token)
+	if _, fence2, _, _ := store.AcquireFenced(ctx, "job-1", time.Minute); fence2 != 2 {
+		t.Fatalf("expected fence 2 after reacquire, got %d", fence2)
+	}
+}
+
+func newClockedMemoryLeaseStore() (*MemoryLeaseStore, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	return NewMemoryLeaseStore().WithClock(clock.Now), clock
+}
+
+func TestMemoryLeaseStore_TTLExpiry(t *testing.T) {
+	store, clock := newClockedMemoryLeaseStore()
+	ctx := context.Background()
+
+	token, ok, _ := store.Acquire(ctx, "job-1", time.Second)
+	if !ok {
+		t.Fatal("expected to acquire lease")
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	if _, ok, _ := store.Acquire(ctx, "job-1", time.Second); ok {
+		t.Fatal("expected lease to still be held before TTL")
+	}
+
+	clock.Advance(time.Millisecond)
+	if ok, _ := store.Extend(ctx, "job-1", token, time.Second); ok {
+		t.Fatal("expected extend of an expired lease to fail")
+	}
+	if _, ok, _ := store.Acquire(ctx, "job-1", time.Second); !ok {
+		t.Fatal("expected to acquire after TTL expiry")
+	}
+}
+
+func TestMemoryLeaseStore_ExtendResetsTTL(t *testing.T) {
+	store, clock := newClockedMemoryLeaseStore()
+	ctx := context.Background()
+
+	token, _, _ := store.Acquire(ctx, "job-1", time.Second)
+	clock.Advance(800 * time.Millisecond)
+	if ok, _ := store.Extend(ctx, "job-1", token, 5*time.Second); !ok {
+		t.Fatal("expected extend to succeed")
+	}
+	if ttl, _ := store.TTL(ctx, "job-1"); ttl != 5*time.Second {
+		t.Fatalf("expected TTL 5s after extend, got %v", ttl)
+	}
+
+	clock.Advance(4 * time.Second)
+	if _, ok, _ := store.Acquire(ctx, "job-1", time.Second); ok {
+		t.Fatal("expected extended lease to still be held")
+	}
+}
+
+func TestMemoryLeaseStore_TTL(t *testing.T) {
+	store, clock := newClockedMemoryLeaseStore()
+	ctx := context.Background()
+
+	if ttl, _ := store.TTL(ctx, "job-1"); ttl >= 0 {
+		t.Fatalf("expected negative TTL for unheld key, got %v", ttl)
+	}
+
+	_, _, _ = store.Acquire(ctx, "job-1", 10*time.Second)
+	clock.Advance(3 * time.Second)
+	if ttl, _ := store.TTL(ctx, "job-1"); ttl != 7*time.Second {
+		t.Fatalf("expected 7s left, got %v", ttl)
+	}
+}
+
+func TestMemoryLeaseStore_CompletionRetention(t *testing.T) {
+	store, clock := newClockedMemoryLeaseStore()
+	ctx := context.Background()
+
+	token, _, _ := store.Acquire(ctx, "job-1", time.Minute)
+	if err := store.Complete(ctx, "job-1", token, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clock.Advance(59 * time.Minute)
+	if done, _ := store.Completed(ctx, "job-1"); !done {
+		t.Fatal("expected completion record within retention")
+	}
+	clock.Advance(time.Minute)
+	if done, _ := store.Completed(ctx, "job-1"); done {
+		t.Fatal("expected completion record to expire after retention")
+	}
+
+	if err := store.Complete(ctx, "job-1", token, 0); err == nil {
+		t.Fatal("expected error for zero retention")
+	}
+}
+
+func TestMemoryLeaseStore_JanitorReclaimsExpired(t *testing.T) {
+	store, clock := newClockedMemoryLeaseStore()
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("job-%d", i)
+		token, _, _ := store.Acquire(ctx, key, time.Second)
+		if i%2 == 0 {
+			_ = store.Complete(ctx, key, token, time.Second)
+		}
+	}
+	_, _, _ = store.Acquire(ctx, "long", time.Hour)
+
+	clock.Advance(time.Second)
+	store.sweep()
+
+	if leases, done := store.size(); leases != 1 || done != 0 {
+		t.Fatalf("expected only the long lease to remain, got %d leases and %d records", leases, done)
+	}
+}
+
+func TestMemoryLeaseStore_JanitorWithoutInterval(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		NewMemoryLeaseStore().RunJanitor(context.Background(), 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected RunJanitor to return for a zero interval")
+	}
+}
+
+func TestMemoryLeaseStore_ConcurrentAcquire(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var winners atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := store.Acquire(ctx, "job-1", time.Minute); ok {
+				winners.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := winners.Load(); n != 1 {
+		t.Fatalf("expected exactly one winner, got %d", n)
+	}
+}
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyFunc derives the lease and idempotency key for a message. A producer
+// that re-sends the same business event gets a new MessageID, so dedupe
+// needs a key taken from the event itself.
+type KeyFunc func(msg *Message) (string, error)
+
+// MessageIDKey keys messages by their SQS MessageID. It is the default.
+func MessageIDKey(msg *Message) (string, error) {
+	return msg.MessageID, nil
+}
+
+// AttributeKey keys messages by the named message attribute.
+func AttributeKey(name string) KeyFunc {
+	return func(msg *Message) (string, error) {
+		v := msg.Attributes[name]
+		if v == "" {
+			return "", fmt.Errorf("message attribute %q missing", name)
+		}
+		return v, nil
+	}
+}
+
+// JSONPathKey keys messages by a string or number in the JSON body, found
+// by a dot-separated path such as "order.id" or "items.0.sku".
+func JSONPathKey(path string) KeyFunc {
+	segments := strings.Split(path, ".")
+	return func(msg *Message) (string, error) {
+		dec := json.NewDecoder(strings.NewReader(msg.Body))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return "", fmt.Errorf("decode body: %w", err)
+		}
+
+		for _, seg := range segments {
+			switch node := v.(type) {
+			case map[string]any:
+				v = node[seg]
+			case []any:
+				i, err := strconv.Atoi(seg)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", fmt.Errorf("json path %q: no index %q", path, seg)
+				}
+				v = node[i]
+			default:
+				v = nil
+			}
+			if v == nil {
+				return "", fmt.Errorf("json path %q not found", path)
+			}
+		}
+
+		switch val := v.(type) {
+		case string:
+			if val == "" {
+				return "", fmt.Errorf("json path %q is empty", path)
+			}
+			return val, nil
+		case json.Number:
+			return val.String(), nil
+		}
+		return "", fmt.Errorf("json path %q is not a string or number", path)
+	}
+}
+
+// BodySHA256Key keys messages by the hex SHA-256 of their body, so identical
+// payloads are treated as the same event.
+func BodySHA256Key(msg *Message) (string, error) {
+	sum := sha256.Sum256([]byte(msg.Body))
+	return hex.EncodeToString(sum[:]), nil
+}
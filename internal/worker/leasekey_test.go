@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"testing"
+)
+
+func TestAttributeKey(t *testing.T) {
+	fn := AttributeKey("event-id")
+
+	key, err := fn(&Message{Attributes: map[string]string{"event-id": "evt-1"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key != "evt-1" {
+		t.Errorf("expected key evt-1, got %q", key)
+	}
+
+	if _, err := fn(&Message{}); err == nil {
+		t.Error("expected error for missing attribute")
+	}
+}
+
+func TestJSONPathKey(t *testing.T) {
+	body := `{"order":{"id":"ord-7","seq":12345678901234567890},"items":[{"sku":"a"},{"sku":"b"}],"empty":""}`
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "order.id", want: "ord-7"},
+		{path: "order.seq", want: "12345678901234567890"},
+		{path: "items.1.sku", want: "b"},
+		{path: "order", wantErr: true},
+		{path: "order.missing", wantErr: true},
+		{path: "items.5.sku", wantErr: true},
+		{path: "order.id.deeper", wantErr: true},
+		{path: "empty", wantErr: true},
+	}
+	for _, tt := range tests {
+		key, err := JSONPathKey(tt.path)(&Message{Body: body})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got key %q", tt.path, key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+			continue
+		}
+		if key != tt.want {
+			t.Errorf("%s: expected key %q, got %q", tt.path, tt.want, key)
+		}
+	}
+
+	if _, err := JSONPathKey("id")(&Message{Body: "not json"}); err == nil {
+		t.Error("expected error for invalid JSON body")
+	}
+}
+
+func TestBodySHA256Key(t *testing.T) {
+	a, _ := BodySHA256Key(&Message{MessageID: "1", Body: "hello"})
+	b, _ := BodySHA256Key(&Message{MessageID: "2", Body: "hello"})
+	c, _ := BodySHA256Key(&Message{MessageID: "3", Body: "world"})
+
+	if a != b {
+		t.Error("expected identical bodies to share a key")
+	}
+	if a == c {
+		t.Error("expected different bodies to have different keys")
+	}
+	if a != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("unexpected hash %q", a)
+	}
+}
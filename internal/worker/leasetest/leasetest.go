@@ -0,0 +1,355 @@
+// Package leasetest checks that a worker.LeaseStore behaves the way the
+// Runner relies on.
+package leasetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+)
+
+// Factory returns an empty store for one subtest. Use t.Cleanup to tear it
+// down, and t.Skip if the backend is unreachable.
+type Factory func(t *testing.T) worker.LeaseStore
+
+// TTL is the lease duration used by expiry checks. Stores must expire
+// leases within a few multiples of it.
+const TTL = 200 * time.Millisecond
+
+// RunConformance runs the behavioural suite against stores made by factory.
+// Checks for CompletionStore, FencingLeaseStore and LeaseTTLStore run only
+// if the store implements them.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("Exclusive", func(t *testing.T) { testExclusive(t, factory(t)) })
+	t.Run("KeysIndependent", func(t *testing.T) { testKeysIndependent(t, factory(t)) })
+	t.Run("ReleaseWrongToken", func(t *testing.T) { testReleaseWrongToken(t, factory(t)) })
+	t.Run("TTLExpiry", func(t *testing.T) { testTTLExpiry(t, factory(t)) })
+	t.Run("ConcurrentAcquire", func(t *testing.T) { testConcurrentAcquire(t, factory(t)) })
+	t.Run("Extend", func(t *testing.T) { testExtend(t, factory(t)) })
+	t.Run("ExtendAfterExpiry", func(t *testing.T) { testExtendAfterExpiry(t, factory(t)) })
+	t.Run("InvalidTTL", func(t *testing.T) { testInvalidTTL(t, factory(t)) })
+	t.Run("ContextCancelled", func(t *testing.T) { testContextCancelled(t, factory(t)) })
+	t.Run("Completion", func(t *testing.T) { testCompletion(t, factory(t)) })
+	t.Run("Fencing", func(t *testing.T) { testFencing(t, factory(t)) })
+	t.Run("RemainingTTL", func(t *testing.T) { testRemainingTTL(t, factory(t)) })
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func mustAcquire(t *testing.T, ctx context.Context, store worker.LeaseStore, key string, ttl time.Duration) string {
+	t.Helper()
+	token, ok, err := store.Acquire(ctx, key, ttl)
+	if err != nil {
+		t.Fatalf("acquire %s: %v", key, err)
+	}
+	if !ok {
+		t.Fatalf("expected to acquire %s", key)
+	}
+	if token == "" {
+		t.Fatalf("expected a token for %s", key)
+	}
+	return token
+}
+
+func expectHeld(t *testing.T, ctx context.Context, store worker.LeaseStore, key string) {
+	t.Helper()
+	_, ok, err := store.Acquire(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatalf("acquire %s: %v", key, err)
+	}
+	if ok {
+		t.Fatalf("expected %s to be held", key)
+	}
+}
+
+// waitExpired polls until key can be acquired again, allowing for stores
+// that expire lazily or with coarse timers.
+func waitExpired(t *testing.T, ctx context.Context, store worker.LeaseStore, key string) string {
+	t.Helper()
+	deadline := time.Now().Add(10 * TTL)
+	for time.Now().Before(deadline) {
+		token, ok, err := store.Acquire(ctx, key, time.Minute)
+		if err != nil {
+			t.Fatalf("acquire %s: %v", key, err)
+		}
+		if ok {
+			return token
+		}
+		time.Sleep(TTL / 4)
+	}
+	t.Fatalf("expected %s to expire within %v", key, 10*TTL)
+	return ""
+}
+
+func testExclusive(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	token := mustAcquire(t, ctx, store, "exclusive", time.Minute)
+	expectHeld(t, ctx, store, "exclusive")
+
+	if err := store.Release(ctx, "exclusive", token); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	again := mustAcquire(t, ctx, store, "exclusive", time.Minute)
+	if again == token {
+		t.Fatal("expected a fresh token on reacquire")
+	}
+}
+
+func testKeysIndependent(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	mustAcquire(t, ctx, store, "independent-a", time.Minute)
+	mustAcquire(t, ctx, store, "independent-b", time.Minute)
+}
+
+func testReleaseWrongToken(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	token := mustAcquire(t, ctx, store, "wrong-token", time.Minute)
+	if err := store.Release(ctx, "wrong-token", token+"-stale"); err != nil {
+		t.Fatalf("release with wrong token: %v", err)
+	}
+	expectHeld(t, ctx, store, "wrong-token")
+
+	// Releasing a key nobody holds is harmless
+	if err := store.Release(ctx, "never-held", token); err != nil {
+		t.Fatalf("release of unheld key: %v", err)
+	}
+}
+
+func testTTLExpiry(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	stale := mustAcquire(t, ctx, store, "expiry", TTL)
+	expectHeld(t, ctx, store, "expiry")
+
+	fresh := waitExpired(t, ctx, store, "expiry")
+
+	// The expired holder can't release the new holder's lease
+	if err := store.Release(ctx, "expiry", stale); err != nil {
+		t.Fatalf("release with stale token: %v", err)
+	}
+	expectHeld(t, ctx, store, "expiry")
+	_ = store.Release(ctx, "expiry", fresh)
+}
+
+func testConcurrentAcquire(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	const racers = 32
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+	start := make(chan struct{})
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, ok, err := store.Acquire(ctx, "race", time.Minute)
+			if err != nil {
+				t.Errorf("acquire: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if winners != 1 {
+		t.Fatalf("expected exactly one of %d racers to win, got %d", racers, winners)
+	}
+}
+
+func testExtend(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	token := mustAcquire(t, ctx, store, "extend", TTL)
+
+	ok, err := store.Extend(ctx, "extend", token+"-stale", time.Minute)
+	if err != nil {
+		t.Fatalf("extend with wrong token: %v", err)
+	}
+	if ok {
+		t.Fatal("expected extend with wrong token to fail")
+	}
+
+	ok, err = store.Extend(ctx, "extend", token, time.Minute)
+	if err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected extend to succeed")
+	}
+
+	// Outlives the original TTL
+	time.Sleep(2 * TTL)
+	expectHeld(t, ctx, store, "extend")
+
+	if err := store.Release(ctx, "extend", token); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	ok, err = store.Extend(ctx, "extend", token, time.Minute)
+	if err != nil {
+		t.Fatalf("extend after release: %v", err)
+	}
+	if ok {
+		t.Fatal("expected extend after release to fail")
+	}
+}
+
+func testExtendAfterExpiry(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	stale := mustAcquire(t, ctx, store, "extend-expired", TTL)
+	fresh := waitExpired(t, ctx, store, "extend-expired")
+
+	ok, err := store.Extend(ctx, "extend-expired", stale, time.Minute)
+	if err != nil {
+		t.Fatalf("extend with stale token: %v", err)
+	}
+	if ok {
+		t.Fatal("expected stale holder not to extend the new holder's lease")
+	}
+	_ = store.Release(ctx, "extend-expired", fresh)
+}
+
+func testInvalidTTL(t *testing.T, store worker.LeaseStore) {
+	ctx := testContext(t)
+
+	if _, ok, err := store.Acquire(ctx, "invalid-ttl", 0); err == nil || ok {
+		t.Fatalf("expected error for zero TTL, got ok=%v err=%v", ok, err)
+	}
+	token := mustAcquire(t, ctx, store, "invalid-ttl", time.Minute)
+	if _, err := store.Extend(ctx, "invalid-ttl", token, -time.Second); err == nil {
+		t.Fatal("expected error extending with negative TTL")
+	}
+}
+
+func testContextCancelled(t *testing.T, store worker.LeaseStore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, ok, err := store.Acquire(ctx, "cancelled", time.Minute); err == nil || ok {
+		t.Fatalf("expected acquire with cancelled context to fail, got ok=%v err=%v", ok, err)
+	}
+	if _, err := store.Extend(ctx, "cancelled", "token", time.Minute); err == nil {
+		t.Fatal("expected extend with cancelled context to fail")
+	}
+	if err := store.Release(ctx, "cancelled", "token"); err == nil {
+		t.Fatal("expected release with cancelled context to fail")
+	}
+
+	// Nothing was taken by the failed acquire
+	mustAcquire(t, testContext(t), store, "cancelled", time.Minute)
+}
+
+func testCompletion(t *testing.T, store worker.LeaseStore) {
+	completions, ok := store.(worker.CompletionStore)
+	if !ok {
+		t.Skip("store does not implement CompletionStore")
+	}
+	ctx := testContext(t)
+
+	token := mustAcquire(t, ctx, store, "complete", time.Minute)
+	done, err := completions.Completed(ctx, "complete")
+	if err != nil {
+		t.Fatalf("completed: %v", err)
+	}
+	if done {
+		t.Fatal("expected no completion record before Complete")
+	}
+
+	if err := completions.Complete(ctx, "complete", token, TTL); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if done, _ := completions.Completed(ctx, "complete"); !done {
+		t.Fatal("expected completion record after Complete")
+	}
+	// Complete released the lease
+	mustAcquire(t, ctx, store, "complete", time.Minute)
+
+	// A stale token records completion but leaves the holder's lease alone
+	if err := completions.Complete(ctx, "complete", token, TTL); err != nil {
+		t.Fatalf("complete with stale token: %v", err)
+	}
+	expectHeld(t, ctx, store, "complete")
+
+	deadline := time.Now().Add(10 * TTL)
+	for {
+		done, err := completions.Completed(ctx, "complete")
+		if err != nil {
+			t.Fatalf("completed: %v", err)
+		}
+		if !done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected completion record to expire within %v", 10*TTL)
+		}
+		time.Sleep(TTL / 4)
+	}
+}
+
+func testFencing(t *testing.T, store worker.LeaseStore) {
+	fencing, ok := store.(worker.FencingLeaseStore)
+	if !ok {
+		t.Skip("store does not implement FencingLeaseStore")
+	}
+	ctx := testContext(t)
+
+	token, first, ok, err := fencing.AcquireFenced(ctx, "fence", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected to acquire, ok=%v err=%v", ok, err)
+	}
+	if _, _, ok, _ := fencing.AcquireFenced(ctx, "fence", time.Minute); ok {
+		t.Fatal("expected fenced acquire of a held key to fail")
+	}
+
+	_ = store.Release(ctx, "fence", token)
+	_, second, ok, err := fencing.AcquireFenced(ctx, "fence", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected to reacquire, ok=%v err=%v", ok, err)
+	}
+	if second <= first {
+		t.Fatalf("expected fence to increase, got %d then %d", first, second)
+	}
+}
+
+func testRemainingTTL(t *testing.T, store worker.LeaseStore) {
+	ttlStore, ok := store.(worker.LeaseTTLStore)
+	if !ok {
+		t.Skip("store does not implement LeaseTTLStore")
+	}
+	ctx := testContext(t)
+
+	remaining, err := ttlStore.TTL(ctx, "remaining")
+	if err != nil {
+		t.Fatalf("ttl: %v", err)
+	}
+	if remaining >= 0 {
+		t.Fatalf("expected negative TTL for unheld key, got %v", remaining)
+	}
+
+	mustAcquire(t, ctx, store, "remaining", time.Minute)
+	remaining, err = ttlStore.TTL(ctx, "remaining")
+	if err != nil {
+		t.Fatalf("ttl: %v", err)
+	}
+	if remaining <= 0 || remaining > time.Minute {
+		t.Fatalf("expected TTL within (0, 1m], got %v", remaining)
+	}
+}
@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PauseSwitch is an external pause toggle shared by every replica watching
+// it, e.g. during downstream maintenance.
+type PauseSwitch interface {
+	Paused(ctx context.Context) (bool, error)
+}
+
+// FilePauseSwitch reports paused while the file at path exists.
+type FilePauseSwitch struct {
+	path string
+}
+
+func NewFilePauseSwitch(path string) *FilePauseSwitch {
+	return &FilePauseSwitch{path: path}
+}
+
+func (f *FilePauseSwitch) Paused(ctx context.Context) (bool, error) {
+	_, err := os.Stat(f.path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// RedisPauseSwitch reports paused while key exists in Redis.
+type RedisPauseSwitch struct {
+	client redis.UniversalClient
+	key    string
+}
+
+func NewRedisPauseSwitch(client redis.UniversalClient, key string) *RedisPauseSwitch {
+	return &RedisPauseSwitch{client: client, key: key}
+}
+
+func (r *RedisPauseSwitch) Paused(ctx context.Context) (bool, error) {
+	n, err := r.client.Exists(ctx, r.key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Status is a snapshot of the Runner's state.
+type Status struct {
+	// Paused reports whether receiving is paused, locally or by the switch.
+	Paused bool
+	// LocalPaused is true between Pause and Resume.
+	LocalPaused bool
+	// RemotePaused is the last value read from the pause switch.
+	RemotePaused bool
+	// InFlight counts handlers currently running.
+	InFlight int
+	// Breaker is the circuit breaker state, empty when none is configured.
+	Breaker string
+	// LeaseContended counts messages skipped because their lease was held
+	// by another worker.
+	LeaseContended int64
+	// LeaseStoreDown is true while the lease store is failing health checks.
+	LeaseStoreDown bool
+	// Unleased counts messages processed without a lease under fail-open.
+	Unleased int64
+}
+
+// Pause stops receiving new messages. Handlers already running finish
+// normally, and a receive already in progress still dispatches what it gets.
+func (r *Runner) Pause() {
+	r.setPaused(func() { r.localPaused = true })
+}
+
+// Resume undoes Pause. Receiving stays paused while the pause switch is on.
+func (r *Runner) Resume() {
+	r.setPaused(func() { r.localPaused = false })
+}
+
+// WithPauseSwitch polls sw every interval while Run is active and pauses
+// receiving whenever it reports paused.
+func (r *Runner) WithPauseSwitch(sw PauseSwitch, interval time.Duration) *Runner {
+	r.pauseSwitch = sw
+	r.pauseInterval = interval
+	return r
+}
+
+func (r *Runner) Status() Status {
+	r.pauseMu.Lock()
+	s := Status{
+		Paused:       r.localPaused || r.remotePaused,
+		LocalPaused:  r.localPaused,
+		RemotePaused: r.remotePaused,
+	}
+	r.pauseMu.Unlock()
+
+	s.InFlight = int(r.inFlight.Load())
+	s.LeaseContended = r.contended.Load()
+	s.LeaseStoreDown = r.leaseDown.Load()
+	s.Unleased = r.unleased.Load()
+	if r.breaker != nil {
+		s.Breaker = r.breaker.State().String()
+	}
+	return s
+}
+
+func (r *Runner) setPaused(update func()) {
+	r.pauseMu.Lock()
+	was := r.localPaused || r.remotePaused
+	update()
+	local, remote := r.localPaused, r.remotePaused
+	now := local || remote
+	if was != now {
+		close(r.pauseChanged)
+		r.pauseChanged = make(chan struct{})
+	}
+	r.pauseMu.Unlock()
+
+	switch {
+	case !was && now:
+		fmt.Printf("runner paused (local=%v switch=%v)\n", local, remote)
+		r.emit(Event{Type: EventPaused})
+	case was && !now:
+		fmt.Printf("runner resumed (local=%v switch=%v)\n", local, remote)
+		r.emit(Event{Type: EventResumed})
+	}
+}
+
+// waitUnpaused blocks while receiving is paused.
+func (r *Runner) waitUnpaused(ctx context.Context) error {
+	for {
+		r.pauseMu.Lock()
+		paused := r.localPaused || r.remotePaused
+		changed := r.pauseChanged
+		r.pauseMu.Unlock()
+
+		if !paused {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// watchPauseSwitch polls the pause switch every interval until ctx is done.
+func (r *Runner) watchPauseSwitch(ctx context.Context) {
+	interval := r.pauseInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.pollPauseSwitch(ctx)
+		}
+	}
+}
+
+func (r *Runner) pollPauseSwitch(ctx context.Context) {
+	paused, err := r.pauseSwitch.Paused(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			// Keep the last known value rather than flapping on errors
+			fmt.Printf("pause switch error: %v\n", err)
+		}
+		return
+	}
+	r.setPaused(func() { r.remotePaused = paused })
+}
@@ -0,0 +1,34 @@
+//go:build integration
+
+package worker_test
+
+import (
+	"testing"
+
+	"go-sqs-worker/internal/worker"
+)
+
+func TestRedisPauseSwitch(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	sw := worker.NewRedisPauseSwitch(client, "worker:paused")
+
+	paused, err := sw.Paused(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if paused {
+		t.Fatal("expected not paused without key")
+	}
+
+	if err := client.Set(ctx, "worker:paused", "maintenance", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	paused, err = sw.Paused(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !paused {
+		t.Fatal("expected paused while key exists")
+	}
+}
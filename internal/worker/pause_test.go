@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFilePauseSwitch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paused")
+	sw := NewFilePauseSwitch(path)
+	ctx := context.Background()
+
+	paused, err := sw.Paused(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if paused {
+		t.Fatal("expected not paused without file")
+	}
+
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	paused, err = sw.Paused(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !paused {
+		t.Fatal("expected paused while file exists")
+	}
+}
+
+func TestRunner_PauseResume(t *testing.T) {
+	const numMessages = 5
+
+	var processed atomic.Int32
+	client := &fakeSQS{messages: makeMessages(numMessages)}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		processed.Add(1)
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 2, 2)
+	runner.Pause()
+
+	if s := runner.Status(); !s.Paused || !s.LocalPaused {
+		t.Fatalf("expected paused status, got %+v", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if got := processed.Load(); got != 0 {
+		t.Fatalf("processed %d messages while paused, want 0", got)
+	}
+
+	runner.Resume()
+	if s := runner.Status(); s.Paused {
+		t.Fatalf("expected running status, got %+v", s)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for processed.Load() != numMessages {
+		select {
+		case <-deadline:
+			t.Fatalf("processed %d messages after resume, want %d", processed.Load(), numMessages)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+func TestRunner_PauseSwitch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paused")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var processed atomic.Int32
+	client := &fakeSQS{messages: makeMessages(3)}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		processed.Add(1)
+		return nil
+	}
+
+	var events []EventType
+	eventCh := make(chan EventType, 4)
+	runner := NewRunner(poller, handler, 2, 2).
+		WithPauseSwitch(NewFilePauseSwitch(path), 10*time.Millisecond).
+		WithEventHandler(func(e Event) { eventCh <- e.Type })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if got := processed.Load(); got != 0 {
+		t.Fatalf("processed %d messages while switch is on, want 0", got)
+	}
+	if s := runner.Status(); !s.RemotePaused {
+		t.Fatalf("expected remote paused status, got %+v", s)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for len(events) < 2 {
+		select {
+		case e := <-eventCh:
+			events = append(events, e)
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for pause events, got %v", events)
+		}
+	}
+	if events[0] != EventPaused || events[1] != EventResumed {
+		t.Fatalf("expected paused then resumed events, got %v", events)
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
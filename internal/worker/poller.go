@@ -3,8 +3,11 @@ package worker
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
 type Poller struct {
@@ -17,6 +20,11 @@ type Message struct {
 	MessageID     string
 	Body          string
 	ReceiptHandle *string
+	// Attributes holds the string-valued message attributes.
+	Attributes map[string]string
+	// ReceiveCount is how many times the message has been delivered,
+	// including this time, or 0 if the source doesn't say.
+	ReceiveCount int
 }
 
 type Handler func(ctx context.Context, msg *Message) error
@@ -63,26 +71,92 @@ func (p *Poller) Delete(ctx context.Context, msg *Message) error {
 	return nil
 }
 
+// ChangeVisibility makes msg visible again after timeout, rounded up to whole
+// seconds. A zero timeout releases the message immediately.
+func (p *Poller) ChangeVisibility(ctx context.Context, msg *Message, timeout time.Duration) error {
+	seconds := int32((timeout + time.Second - 1) / time.Second)
+	_, err := p.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &p.queueURL,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: seconds,
+	})
+	if err != nil {
+		return fmt.Errorf("change visibility: %w", err)
+	}
+	return nil
+}
+
 func (p *Poller) ReceiveOne(ctx context.Context) (*Message, error) {
+	msgs, err := p.Receive(ctx, 1)
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	return msgs[0], nil
+}
+
+// Receive returns up to max messages, at most 10 as SQS allows.
+func (p *Poller) Receive(ctx context.Context, max int) ([]*Message, error) {
+	if max < 1 {
+		max = 1
+	}
+	if max > 10 {
+		max = 10
+	}
 	out, err := p.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-		QueueUrl:            &p.queueURL,
-		MaxNumberOfMessages: 1,
-		WaitTimeSeconds:     5,
+		QueueUrl:              &p.queueURL,
+		MaxNumberOfMessages:   int32(max),
+		WaitTimeSeconds:       p.waitTimeSeconds,
+		MessageAttributeNames: []string{"All"},
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+			types.MessageSystemAttributeNameApproximateReceiveCount,
+		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("receive: %w", err)
 	}
 
-	if len(out.Messages) == 0 {
-		return nil, nil
+	msgs := make([]*Message, 0, len(out.Messages))
+	for _, m := range out.Messages {
+		var attrs map[string]string
+		for name, v := range m.MessageAttributes {
+			if v.StringValue == nil {
+				continue
+			}
+			if attrs == nil {
+				attrs = make(map[string]string, len(m.MessageAttributes))
+			}
+			attrs[name] = *v.StringValue
+		}
+		receiveCount, _ := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		msgs = append(msgs, &Message{
+			MessageID:     *m.MessageId,
+			Body:          *m.Body,
+			ReceiptHandle: m.ReceiptHandle,
+			Attributes:    attrs,
+			ReceiveCount:  receiveCount,
+		})
 	}
+	return msgs, nil
+}
 
-	msg := out.Messages[0]
-	return &Message{
-		MessageID:     *msg.MessageId,
-		Body:          *msg.Body,
-		ReceiptHandle: msg.ReceiptHandle,
-	}, nil
+// Ack deletes msg from the queue.
+func (p *Poller) Ack(ctx context.Context, msg *Message) error {
+	return p.Delete(ctx, msg)
+}
+
+// Nack makes msg visible again after delay.
+func (p *Poller) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return p.ChangeVisibility(ctx, msg, delay)
+}
+
+// Extend resets msg's visibility timeout to d from now.
+func (p *Poller) Extend(ctx context.Context, msg *Message, d time.Duration) error {
+	return p.ChangeVisibility(ctx, msg, d)
+}
+
+// LongPolling reports whether receives wait on the server for messages.
+func (p *Poller) LongPolling() bool {
+	return p.waitTimeSeconds > 0
 }
 
 func (p *Poller) WithWaitTimeSeconds(seconds int32) *Poller {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
@@ -124,3 +125,91 @@ func TestPoller_ProcessOne_NoDeleteOnHandlerError(t *testing.T) {
 		t.Error("message should not be deleted on handler error")
 	}
 }
+
+func TestPoller_ReceiveOne_CopiesStringAttributes(t *testing.T) {
+	t.Parallel()
+
+	msgID := "test-123"
+	msgBody := "hello"
+	tenant := "acme"
+
+	client := &fakeSQS{
+		messages: []types.Message{
+			{
+				MessageId: &msgID,
+				Body:      &msgBody,
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					"tenant":  {DataType: &msgBody, StringValue: &tenant},
+					"payload": {DataType: &msgBody, BinaryValue: []byte("x")},
+				},
+			},
+		},
+	}
+
+	p := NewPoller(client, "http://example.com/queue")
+	msg, err := p.ReceiveOne(context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := msg.Attributes["tenant"]; got != tenant {
+		t.Errorf("expected tenant attribute %q, got %q", tenant, got)
+	}
+	if _, ok := msg.Attributes["payload"]; ok {
+		t.Error("binary attributes should not be copied")
+	}
+}
+
+func TestPoller_ChangeVisibility_RoundsUpToSeconds(t *testing.T) {
+	t.Parallel()
+
+	receiptHandle := "receipt-abc"
+	client := &fakeSQS{}
+
+	p := NewPoller(client, "http://example.com/queue")
+	err := p.ChangeVisibility(context.Background(), &Message{ReceiptHandle: &receiptHandle}, 1200*time.Millisecond)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := client.GetVisibility(receiptHandle); v != 2 {
+		t.Errorf("expected visibility 2s, got %d", v)
+	}
+}
+
+func TestPoller_Receive_CapsBatchAtTen(t *testing.T) {
+	t.Parallel()
+
+	client := &fakeSQS{messages: makeMessages(15)}
+	p := NewPoller(client, "http://example.com/queue")
+
+	msgs, err := p.Receive(context.Background(), 50)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(msgs) != 10 {
+		t.Fatalf("expected 10 messages, got %d", len(msgs))
+	}
+}
+
+func TestPoller_Receive_ReportsReceiveCount(t *testing.T) {
+	t.Parallel()
+
+	messages := makeMessages(2)
+	messages[0].Attributes = map[string]string{
+		string(types.MessageSystemAttributeNameApproximateReceiveCount): "3",
+	}
+	client := &fakeSQS{messages: messages}
+	p := NewPoller(client, "http://example.com/queue")
+
+	msgs, err := p.Receive(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msgs[0].ReceiveCount != 3 {
+		t.Errorf("expected receive count 3, got %d", msgs[0].ReceiveCount)
+	}
+	if msgs[1].ReceiveCount != 0 {
+		t.Errorf("expected receive count 0 when SQS omits it, got %d", msgs[1].ReceiveCount)
+	}
+}
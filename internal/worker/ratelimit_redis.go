@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitKeyPrefix = "ratelimit:"
+
+// gcraScript implements the generic cell rate algorithm. KEYS[1] holds the
+// theoretical arrival time (TAT) in microseconds. ARGV[1] is the emission
+// interval and ARGV[2] the burst, both in microseconds. Returns 0 when the
+// request is allowed, otherwise the microseconds until it would be.
+var gcraScript = redis.NewScript(`
+local now = redis.call("TIME")
+local now_us = tonumber(now[1]) * 1000000 + tonumber(now[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now_us then
+	tat = now_us
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - burst
+if allow_at > now_us then
+	return allow_at - now_us
+end
+
+redis.call("SET", KEYS[1], string.format("%.0f", new_tat), "PX", math.ceil((new_tat - now_us) / 1000))
+return 0
+`)
+
+// RateLimiter is a rate limit shared across processes. Allow consumes one
+// token for key; when none is available it returns ok == false and how long
+// until the next one.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (retryAfter time.Duration, ok bool, err error)
+}
+
+// RedisRateLimiter enforces a fleet-wide rate per key using GCRA in Redis.
+type RedisRateLimiter struct {
+	client   redis.UniversalClient
+	interval time.Duration
+	burst    int
+}
+
+func NewRedisRateLimiter(client redis.UniversalClient, perSecond float64, burst int) (*RedisRateLimiter, error) {
+	if perSecond <= 0 {
+		return nil, fmt.Errorf("rate must be > 0")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RedisRateLimiter{
+		client:   client,
+		interval: time.Duration(float64(time.Second) / perSecond),
+		burst:    burst,
+	}, nil
+}
+
+func (r *RedisRateLimiter) Allow(ctx context.Context, key string) (time.Duration, bool, error) {
+	interval := r.interval.Microseconds()
+	wait, err := gcraScript.Run(ctx, r.client, []string{rateLimitKeyPrefix + key},
+		interval, interval*int64(r.burst)).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	if wait > 0 {
+		return time.Duration(wait) * time.Microsecond, false, nil
+	}
+	return 0, true, nil
+}
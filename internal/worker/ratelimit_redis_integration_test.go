@@ -0,0 +1,65 @@
+//go:build integration
+
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+)
+
+func TestRedisRateLimiter_BurstThenLimited(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	limiter, err := worker.NewRedisRateLimiter(client, 10, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Burst is available immediately
+	for i := 0; i < 3; i++ {
+		_, ok, err := limiter.Allow(ctx, "tenant-a")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok {
+			t.Fatalf("expected request %d within burst to be allowed", i+1)
+		}
+	}
+
+	// Next one must wait roughly one emission interval (100ms)
+	retryAfter, ok, err := limiter.Allow(ctx, "tenant-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected request beyond burst to be limited")
+	}
+	if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Fatalf("expected retryAfter in (0, 100ms], got %v", retryAfter)
+	}
+
+	// Other keys have their own bucket
+	if _, ok, _ := limiter.Allow(ctx, "tenant-b"); !ok {
+		t.Fatal("expected a different key to be allowed")
+	}
+
+	time.Sleep(retryAfter + 20*time.Millisecond)
+
+	if _, ok, _ := limiter.Allow(ctx, "tenant-a"); !ok {
+		t.Fatal("expected request to be allowed after retryAfter")
+	}
+}
+
+func TestRedisRateLimiter_SharedAcrossInstances(t *testing.T) {
+	client, ctx := newTestRedis(t)
+	a, _ := worker.NewRedisRateLimiter(client, 1, 1)
+	b, _ := worker.NewRedisRateLimiter(client, 1, 1)
+
+	if _, ok, _ := a.Allow(ctx, "shared"); !ok {
+		t.Fatal("expected first request to be allowed")
+	}
+	if _, ok, _ := b.Allow(ctx, "shared"); ok {
+		t.Fatal("expected second limiter to see the first one's token")
+	}
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisOptions describes a Redis deployment. One address with no
+// MasterName is a single node, MasterName selects Sentinel failover (Addrs
+// are the sentinels) and several addresses without it a cluster.
+type RedisOptions struct {
+	Addrs      []string
+	Username   string
+	Password   string
+	DB         int
+	MasterName string
+	PoolSize   int
+	// ClusterMode treats a single address as a cluster, e.g. an ElastiCache
+	// cluster-mode configuration endpoint.
+	ClusterMode bool
+
+	TLS bool
+	// TLSCAFile is a PEM bundle to verify the server with instead of the
+	// system roots. Setting it implies TLS.
+	TLSCAFile string
+}
+
+// NewRedisClient builds a client for whichever deployment opts describes.
+func NewRedisClient(opts RedisOptions) (redis.UniversalClient, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("redis: no addresses")
+	}
+	if opts.DB != 0 && opts.Cluster() {
+		return nil, errors.New("redis: cluster mode only supports DB 0")
+	}
+
+	tlsConfig, err := redisTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:         opts.Addrs,
+		Username:      opts.Username,
+		Password:      opts.Password,
+		DB:            opts.DB,
+		MasterName:    opts.MasterName,
+		PoolSize:      opts.PoolSize,
+		TLSConfig:     tlsConfig,
+		IsClusterMode: opts.ClusterMode,
+	}), nil
+}
+
+// Cluster reports whether opts describe a Redis cluster.
+func (opts RedisOptions) Cluster() bool {
+	return opts.MasterName == "" && (opts.ClusterMode || len(opts.Addrs) > 1)
+}
+
+func redisTLSConfig(opts RedisOptions) (*tls.Config, error) {
+	if !opts.TLS && opts.TLSCAFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.TLSCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(opts.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("redis tls ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("redis tls ca: no certificates in %s", opts.TLSCAFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewRedisClient_SelectsDeploymentMode(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RedisOptions
+		want string
+	}{
+		{"single", RedisOptions{Addrs: []string{"localhost:6379"}}, "*redis.Client"},
+		{"sentinel", RedisOptions{Addrs: []string{"s1:26379", "s2:26379"}, MasterName: "mymaster"}, "*redis.Client"},
+		{"cluster", RedisOptions{Addrs: []string{"n1:6379", "n2:6379"}}, "*redis.ClusterClient"},
+		{"cluster endpoint", RedisOptions{Addrs: []string{"cfg.example.cache.amazonaws.com:6379"}, ClusterMode: true}, "*redis.ClusterClient"},
+	}
+	for _, tt := range tests {
+		client, err := NewRedisClient(tt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		var got string
+		switch client.(type) {
+		case *redis.Client:
+			got = "*redis.Client"
+		case *redis.ClusterClient:
+			got = "*redis.ClusterClient"
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %T", tt.name, tt.want, client)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestNewRedisClient_RejectsInvalidOptions(t *testing.T) {
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(badCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts RedisOptions
+	}{
+		{"no addresses", RedisOptions{}},
+		{"cluster with db", RedisOptions{Addrs: []string{"n1:6379", "n2:6379"}, DB: 2}},
+		{"cluster endpoint with db", RedisOptions{Addrs: []string{"n1:6379"}, ClusterMode: true, DB: 2}},
+		{"missing ca file", RedisOptions{Addrs: []string{"localhost:6379"}, TLSCAFile: "/does/not/exist.pem"}},
+		{"invalid ca file", RedisOptions{Addrs: []string{"localhost:6379"}, TLSCAFile: badCA}},
+	}
+	for _, tt := range tests {
+		if _, err := NewRedisClient(tt.opts); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.name)
+		}
+	}
+}
+
+func TestRedisLeaseStore_HashTagsKeysOnCluster(t *testing.T) {
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"n1:6379"}})
+	defer cluster.Close()
+	single := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer single.Close()
+
+	cs := NewRedisLeaseStore(cluster)
+	if got := cs.leaseKey("job-1"); got != "lease:{job-1}" {
+		t.Errorf("expected cluster lease key lease:{job-1}, got %q", got)
+	}
+	if got := cs.doneKey("job-1"); got != "done:{job-1}" {
+		t.Errorf("expected cluster done key done:{job-1}, got %q", got)
+	}
+
+	ss := NewRedisLeaseStore(single).WithKeyPrefix("tenant-a:lease:").WithCompletionPrefix("tenant-a:done:")
+	if got := ss.leaseKey("job-1"); got != "tenant-a:lease:job-1" {
+		t.Errorf("expected prefixed lease key, got %q", got)
+	}
+	if got := ss.doneKey("job-1"); got != "tenant-a:done:job-1" {
+		t.Errorf("expected prefixed done key, got %q", got)
+	}
+}
+
+func TestRedisLeaseStore_HashTagsFromOptions(t *testing.T) {
+	opts := RedisOptions{Addrs: []string{"localhost:6379"}, ClusterMode: true}
+	if !opts.Cluster() {
+		t.Fatal("expected a single address in cluster mode to be a cluster")
+	}
+	// A client whose concrete type doesn't give the deployment away
+	single := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer single.Close()
+
+	store := NewRedisLeaseStore(single).WithHashTags(opts.Cluster())
+	if got := store.leaseKey("job-1"); got != "lease:{job-1}" {
+		t.Errorf("expected hash-tagged lease key, got %q", got)
+	}
+	if (RedisOptions{Addrs: []string{"s1:26379", "s2:26379"}, MasterName: "mymaster"}).Cluster() {
+		t.Error("expected Sentinel not to be a cluster")
+	}
+}
@@ -2,45 +2,235 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 type Runner struct {
-	poller      *Poller
+	source      Source
 	handler     Handler
 	maxInFlight int
 	concurrency int
 	leaseStore  LeaseStore
 	leaseTTL    time.Duration
+	limiter     *rate.Limiter
+
+	leaseKey            KeyFunc
+	completions         CompletionStore
+	completionRetention time.Duration
+	contention          ContentionPolicy
+	contended           atomic.Int64
+
+	dedupe *dedupeSet
+
+	failover    LeaseFailover
+	localLeases *MemoryLeaseStore
+	leaseDown   atomic.Bool
+	// leaseRetryAt is when fail-closed next tries a store without Ping
+	leaseRetryAt atomic.Int64
+	unleased     atomic.Int64
+
+	sharedLimiter RateLimiter
+	rateKeyAttr   string
+
+	breaker *breaker
+	onEvent func(Event)
+
+	pauseMu       sync.Mutex
+	localPaused   bool
+	remotePaused  bool
+	pauseChanged  chan struct{}
+	pauseSwitch   PauseSwitch
+	pauseInterval time.Duration
+
+	inFlight atomic.Int32
+
+	clock          Clock
+	handlerTimeout time.Duration
+	visibility     time.Duration
+
+	receiveBatch   int
+	receiveBackoff backoff
+	idleBackoff    *backoff
+	idleAfter      int
+}
+
+// delivery is a received message plus the breaker generation it was
+// admitted under.
+type delivery struct {
+	msg        *Message
+	breakerGen uint64
 }
 
-func NewRunner(poller *Poller, handler Handler, maxInFlight int, concurrency int) *Runner {
+func NewRunner(source Source, handler Handler, maxInFlight int, concurrency int) *Runner {
 	return &Runner{
-		poller:      poller,
-		handler:     handler,
-		maxInFlight: maxInFlight,
-		concurrency: concurrency,
+		source:         source,
+		receiveBatch:   1,
+		handler:        handler,
+		maxInFlight:    maxInFlight,
+		concurrency:    concurrency,
+		leaseKey:       MessageIDKey,
+		failover:       LeaseFailover{}.withDefaults(),
+		pauseChanged:   make(chan struct{}),
+		receiveBackoff: backoff{base: 200 * time.Millisecond, max: 30 * time.Second},
+		clock:          systemClock{},
+		handlerTimeout: 30 * time.Second,
 	}
 }
 
+// WithLeaseStore guards each message with a lease held for ttl, renewed in
+// the background while its handler runs.
 func (r *Runner) WithLeaseStore(store LeaseStore, ttl time.Duration) *Runner {
 	r.leaseStore = store
 	r.leaseTTL = ttl
 	return r
 }
 
+// WithRateLimit caps dispatch at perSecond messages per second with bursts of
+// up to burst. The feeder waits for a token before each receive, so a
+// saturated limiter also slows down receives instead of pulling messages that
+// would sit in the buffer while their visibility timeout runs out.
+func (r *Runner) WithRateLimit(perSecond float64, burst int) *Runner {
+	if burst < 1 {
+		burst = 1
+	}
+	r.limiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+	return r
+}
+
+// storeErrorDelay is how long a message is hidden when the shared limiter
+// or the completion store can't be reached.
+const storeErrorDelay = time.Second
+
+// WithSharedRateLimit checks every message against a limiter shared by all
+// replicas. If keyAttribute is set, that message attribute (e.g. a tenant ID)
+// selects the bucket; messages without it share a single bucket. A limited
+// message is not failed: its visibility is pushed out until the limiter
+// expects a free token, or for storeErrorDelay if the limiter errors.
+func (r *Runner) WithSharedRateLimit(limiter RateLimiter, keyAttribute string) *Runner {
+	r.sharedLimiter = limiter
+	r.rateKeyAttr = keyAttribute
+	return r
+}
+
+// WithCircuitBreaker stops receiving while handlers keep failing. When the
+// breaker trips, the receive loop pauses for cfg.OpenDuration, then lets
+// cfg.HalfOpenProbes messages through to test recovery. Messages that fail or
+// are still buffered while it is open are released with
+// cfg.ReleaseVisibility instead of being left invisible.
+func (r *Runner) WithCircuitBreaker(cfg BreakerConfig) *Runner {
+	r.breaker = newBreaker(cfg, func(from, to BreakerState) {
+		fmt.Printf("circuit breaker %s -> %s\n", from, to)
+		r.emit(Event{Type: EventBreakerStateChange, State: to.String()})
+	})
+	return r
+}
+
+// WithReceiveBatch lets one receive fetch up to n messages when that many
+// in-flight slots are free. Batches are only taken while the circuit
+// breaker is closed and the rate limit has tokens to spare.
+func (r *Runner) WithReceiveBatch(n int) *Runner {
+	r.receiveBatch = max(n, 1)
+	return r
+}
+
+// WithReceiveBackoff sets the jittered exponential backoff applied after
+// receive errors. Defaults to 200ms doubling up to 30s.
+func (r *Runner) WithReceiveBackoff(base, max time.Duration) *Runner {
+	r.receiveBackoff = backoff{base: base, max: max}
+	return r
+}
+
+// WithIdleBackoff backs off between receives after `after` consecutive empty
+// receives, starting at base and doubling up to max. It only applies to
+// sources that aren't long polling; a long poll already waits for messages.
+func (r *Runner) WithIdleBackoff(after int, base, max time.Duration) *Runner {
+	r.idleAfter = after
+	r.idleBackoff = &backoff{base: base, max: max}
+	return r
+}
+
+// WithVisibilityExtension keeps a message hidden while its handler runs,
+// by extending its visibility to d from now every d/3. Set d to the
+// source's visibility timeout so a long handler isn't delivered to another
+// worker part way through. Off by default.
+func (r *Runner) WithVisibilityExtension(d time.Duration) *Runner {
+	r.visibility = d
+	return r
+}
+
+// WithLeaseKey sets how the lease and idempotency key is derived from a
+// message; the default is MessageIDKey. A message whose key can't be
+// extracted is a permanent failure and is deleted.
+func (r *Runner) WithLeaseKey(fn KeyFunc) *Runner {
+	r.leaseKey = fn
+	return r
+}
+
+// WithIdempotency keeps a completion record for retention after each
+// successful message, and acknowledges later deliveries of the same key
+// without calling the handler. It requires a lease store that implements
+// CompletionStore, such as RedisLeaseStore.
+func (r *Runner) WithIdempotency(retention time.Duration) *Runner {
+	r.completionRetention = retention
+	return r
+}
+
 func (r *Runner) Run(ctx context.Context) error {
+	if r.completionRetention > 0 {
+		completions, ok := r.leaseStore.(CompletionStore)
+		if !ok {
+			return errors.New("idempotency requires a lease store that implements CompletionStore")
+		}
+		r.completions = completions
+	}
+	if r.dedupe != nil {
+		r.dedupe.now = r.clock.Now
+	}
+	if r.breaker != nil {
+		r.breaker.now, r.breaker.after = r.clock.Now, r.clock.After
+	}
+	if r.contention == ContentionDeleteDuplicate {
+		if _, ok := r.leaseStore.(CompletionStore); !ok {
+			return errors.New("delete-duplicate contention policy requires a lease store that implements CompletionStore")
+		}
+	}
+
 	// allow for buffering all messages at `maxInFlight` that don't have a worker available
 	messageBufferSize := r.maxInFlight - r.concurrency
 	if messageBufferSize < 0 {
 		messageBufferSize = 0 // unbuffered if workers >= maxInFlight
 	}
-	msgCh := make(chan *Message, messageBufferSize)
+	msgCh := make(chan delivery, messageBufferSize)
 	sem := make(chan struct{}, r.maxInFlight)
 	var wg sync.WaitGroup
 
+	// Stops background goroutines when Run returns early on a fatal error
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	if r.failover.Policy == LeaseFailLocal && r.leaseStore != nil && r.leaseTTL > 0 {
+		r.localLeases = NewMemoryLeaseStore()
+		go r.localLeases.RunJanitor(runCtx, r.leaseTTL)
+	}
+	if checker, ok := r.leaseStore.(LeaseHealthChecker); ok {
+		go r.watchLeaseStore(runCtx, checker)
+	}
+
+	if r.pauseSwitch != nil {
+		// Read the switch once up front so a paused fleet never receives on start
+		r.pollPauseSwitch(runCtx)
+		go r.watchPauseSwitch(runCtx)
+	}
+
+	idle := r.idleBackoff != nil && !longPolling(r.source)
+	var fatalErr error
+
 	// Start worker pool based on concurrency
 	for i := 0; i < r.concurrency; i++ {
 		wg.Add(1)
@@ -52,6 +242,7 @@ func (r *Runner) Run(ctx context.Context) error {
 
 	go func() {
 		defer close(msgCh)
+		emptyReceives := 0
 		for {
 			select {
 			case <-ctx.Done():
@@ -59,77 +250,354 @@ func (r *Runner) Run(ctx context.Context) error {
 			case sem <- struct{}{}: // acquire slot before receive
 			}
 
-			msg, err := r.poller.ReceiveOne(ctx)
+			if err := r.waitUnpaused(ctx); err != nil {
+				<-sem
+				return
+			}
+
+			if r.leaseBlocksReceive() {
+				// Fail-closed: nothing could run until the lease store is back
+				<-sem
+				if r.sleep(ctx, r.failover.HealthInterval) != nil {
+					return
+				}
+				continue
+			}
+
+			var gen uint64
+			if r.breaker != nil {
+				var err error
+				if gen, err = r.breaker.acquire(ctx); err != nil {
+					<-sem
+					return
+				}
+			}
+
+			if r.limiter != nil {
+				if err := r.limiter.Wait(ctx); err != nil {
+					// Wait fails early when the next token lands past the
+					// deadline; no more receives can happen before then.
+					r.releaseBreaker(gen)
+					<-sem
+					<-ctx.Done()
+					return
+				}
+			}
+
+			// Widen to a batch with whatever other slots are free
+			batch := 1
+			for batch < r.receiveBatch && r.widenBatch(sem) {
+				batch++
+			}
+
+			msgs, err := r.source.Receive(ctx, batch)
 			if err != nil {
-				<-sem // release on error
+				r.releaseBreaker(gen)
+				releaseSlots(sem, batch) // release on error
 				if ctx.Err() != nil {
 					return
 				}
-				fmt.Printf("receive error: %v\n", err)
+				if IsFatalReceiveError(err) {
+					fatalErr = err
+					return
+				}
+				delay := r.receiveBackoff.next()
+				fmt.Printf("receive error (retrying in %v): %v\n", delay, err)
+				if r.sleep(ctx, delay) != nil {
+					return
+				}
 				continue
 			}
-			if msg == nil {
-				<-sem // release if no message
+			r.receiveBackoff.reset()
+
+			if len(msgs) == 0 {
+				r.releaseBreaker(gen)
+				releaseSlots(sem, batch) // release if no message
+				if idle {
+					emptyReceives++
+					if emptyReceives >= r.idleAfter && r.sleep(ctx, r.idleBackoff.next()) != nil {
+						return
+					}
+				}
 				continue
 			}
+			if idle {
+				emptyReceives = 0
+				r.idleBackoff.reset()
+			}
+			releaseSlots(sem, batch-len(msgs))
 
-			select {
-			case msgCh <- msg:
-			case <-ctx.Done():
-				<-sem
-				return
+			for i, msg := range msgs {
+				select {
+				case msgCh <- delivery{msg: msg, breakerGen: gen}:
+				case <-ctx.Done():
+					r.releaseBreaker(gen)
+					releaseSlots(sem, len(msgs)-i)
+					return
+				}
 			}
 		}
 	}()
 
 	wg.Wait()
+	// Workers exit only after the feeder closes msgCh, so fatalErr is settled
+	if fatalErr != nil {
+		return fatalErr
+	}
 	return ctx.Err()
 }
 
-func (r *Runner) worker(ctx context.Context, msgCh <-chan *Message, sem <-chan struct{}, workerID int) {
-	for msg := range msgCh {
-		var token string
+// widenBatch takes another free slot for the next receive, unless that
+// would bypass the breaker's probe limit or the rate limit.
+func (r *Runner) widenBatch(sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+	default:
+		return false
+	}
+	if r.breaker != nil && r.breaker.State() != BreakerClosed || r.limiter != nil && !r.limiter.Allow() {
+		<-sem
+		return false
+	}
+	return true
+}
 
-		// Acquire lease if store configured
-		if r.leaseStore != nil {
-			var ok bool
-			var err error
-			token, ok, err = r.leaseStore.Acquire(ctx, msg.MessageID, r.leaseTTL)
-			if err != nil {
-				fmt.Printf("worker %d lease error: %v\n", workerID, err)
-				<-sem
-				continue
+func releaseSlots(sem <-chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		<-sem
+	}
+}
+
+func (r *Runner) worker(ctx context.Context, msgCh <-chan delivery, sem <-chan struct{}, workerID int) {
+	for d := range msgCh {
+		ran, err := r.processOnce(ctx, d.msg, workerID)
+		if r.breaker != nil {
+			if ran && ctx.Err() == nil {
+				r.breaker.record(d.breakerGen, err != nil)
+			} else {
+				r.breaker.release(d.breakerGen)
 			}
-			if !ok {
-				// Another worker has it, skip
-				<-sem
-				continue
+			if err != nil && r.breaker.State() == BreakerOpen {
+				r.nack(d.msg, r.breaker.cfg.ReleaseVisibility, workerID)
 			}
 		}
+		<-sem
+	}
+}
 
-		handlerCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+func (r *Runner) releaseBreaker(gen uint64) {
+	if r.breaker != nil {
+		r.breaker.release(gen)
+	}
+}
 
-		if err := r.handler(handlerCtx, msg); err != nil {
-			cancel()
-			if r.leaseStore != nil {
-				_ = r.leaseStore.Release(ctx, msg.MessageID, token)
-			}
-			<-sem
-			fmt.Printf("worker %d handler error: %v\n", workerID, err)
-			continue
+// process handles one message. It reports whether the handler ran and, if
+// so, the handler's error.
+func (r *Runner) process(ctx context.Context, msg *Message, workerID int) (bool, error) {
+	if r.breaker != nil && r.breaker.State() == BreakerOpen {
+		// Received before the breaker tripped; don't feed it to a failing dependency
+		r.nack(msg, r.breaker.cfg.ReleaseVisibility, workerID)
+		return false, nil
+	}
+
+	if r.sharedLimiter != nil {
+		retryAfter, ok, err := r.sharedLimiter.Allow(ctx, msg.Attributes[r.rateKeyAttr])
+		if err != nil {
+			fmt.Printf("worker %d rate limit error: %v\n", workerID, err)
+			r.nack(msg, storeErrorDelay, workerID)
+			return false, nil
 		}
+		if !ok {
+			// Over the shared rate: hand it back to SQS for later instead of failing it
+			r.nack(msg, retryAfter, workerID)
+			return false, nil
+		}
+	}
+
+	var key string
+	var lease leaseGrant
+
+	// Acquire lease if store configured
+	if r.leaseStore != nil {
+		var err error
+		if key, err = r.leaseKey(msg); err != nil {
+			r.dropPermanent(msg, Permanent(fmt.Errorf("lease key: %w", err)), workerID)
+			return false, nil
+		}
+
+		var ok bool
+		if lease, ok = r.acquireLease(ctx, msg, key, workerID); !ok {
+			return false, nil
+		}
+	}
+
+	if lease.completions != nil {
+		done, err := lease.completions.Completed(ctx, key)
+		if err != nil {
+			fmt.Printf("worker %d completion lookup error: %v\n", workerID, err)
+			_ = lease.store.Release(ctx, key, lease.token)
+			r.nack(msg, storeErrorDelay, workerID)
+			return false, nil
+		}
+		if done {
+			// Processed under an earlier delivery; acknowledge without running the handler
+			r.ack(msg, workerID)
+			_ = lease.store.Release(ctx, key, lease.token)
+			r.emit(Event{Type: EventDuplicate, MessageID: msg.MessageID, Key: key})
+			return false, nil
+		}
+	}
+
+	// Cancelled with ErrLeaseLost if the lease can't be kept alive
+	leaseCtx, loseLease := context.WithCancelCause(ctx)
+	defer loseLease(nil)
+	handlerCtx, cancel := r.withTimeout(leaseCtx, r.handlerTimeout)
+
+	if lease.store != nil {
+		handlerCtx = contextWithLease(handlerCtx, Lease{Key: key, Token: lease.token, Fence: lease.fence})
+	}
+	stopRenew := func() {}
+	if lease.store != nil || r.visibility > 0 {
+		stopRenew = r.keepAlive(leaseCtx, msg, key, lease, loseLease, workerID)
+	}
+
+	r.inFlight.Add(1)
+	err := r.handler(handlerCtx, msg)
+	r.inFlight.Add(-1)
+	stopRenew()
+	if err != nil {
 		cancel()
+		if lease.store != nil {
+			_ = lease.store.Release(ctx, key, lease.token)
+		}
+		if IsPermanent(err) {
+			r.dropPermanent(msg, err, workerID)
+			// A bad message says nothing about downstream health
+			return true, nil
+		}
+		fmt.Printf("worker %d handler error: %v\n", workerID, err)
+		r.reportFailure(msg, err, workerID)
+		return true, err
+	}
+	cancel()
 
-		delCtx, delCancel := context.WithTimeout(context.Background(), 2*time.Second)
-		if err := r.poller.Delete(delCtx, msg); err != nil {
-			fmt.Printf("worker %d delete error: %v\n", workerID, err)
+	// Record completion before deleting, so a failed delete is deduped on redelivery
+	released := false
+	if lease.completions != nil {
+		doneCtx, doneCancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := lease.completions.Complete(doneCtx, key, lease.token, r.completionRetention); err != nil {
+			fmt.Printf("worker %d completion record error: %v\n", workerID, err)
+		} else {
+			released = true
 		}
-		delCancel()
+		doneCancel()
+	}
 
-		if r.leaseStore != nil {
-			_ = r.leaseStore.Release(ctx, msg.MessageID, token)
+	r.ack(msg, workerID)
+
+	if lease.store != nil && !released {
+		_ = lease.store.Release(ctx, key, lease.token)
+	}
+	return true, nil
+}
+
+// dropPermanent deletes a message that can never succeed, so it isn't
+// redelivered until it lands in the DLQ.
+func (r *Runner) dropPermanent(msg *Message, err error, workerID int) {
+	fmt.Printf("worker %d permanent failure, deleting %s: %v\n", workerID, msg.MessageID, err)
+	r.ack(msg, workerID)
+	r.emit(Event{Type: EventPermanentFailure, MessageID: msg.MessageID, Err: err})
+}
+
+func (r *Runner) ack(msg *Message, workerID int) {
+	ackCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.source.Ack(ackCtx, msg); err != nil {
+		fmt.Printf("worker %d ack error: %v\n", workerID, err)
+	}
+}
+
+// keepAlive renews the message's lease every third of its TTL, and its
+// visibility every third of the extension set by WithVisibilityExtension,
+// while the handler runs. If the store reports the lease gone, or renewals
+// keep failing until it would have expired, lose is called with
+// ErrLeaseLost so the handler stops work it no longer owns. The returned
+// func stops renewing and waits for the renewer to exit.
+func (r *Runner) keepAlive(ctx context.Context, msg *Message, key string, lease leaseGrant, lose context.CancelCauseFunc, workerID int) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		interval := r.visibility / 3
+		if lease.store != nil && (interval <= 0 || r.leaseTTL/3 < interval) {
+			interval = r.leaseTTL / 3
 		}
+		lastRenewed := r.clock.Now()
 
-		<-sem
+		for {
+			tick, stopTick := r.clock.After(interval)
+			select {
+			case <-done:
+				stopTick()
+				return
+			case <-ctx.Done():
+				stopTick()
+				return
+			case <-tick:
+			}
+
+			if r.visibility > 0 {
+				if err := r.source.Extend(ctx, msg, r.visibility); err != nil && ctx.Err() == nil {
+					fmt.Printf("worker %d visibility extend error: %v\n", workerID, err)
+				}
+			}
+			if lease.store == nil {
+				continue
+			}
+
+			ok, err := lease.store.Extend(ctx, key, lease.token, r.leaseTTL)
+			if err == nil && ok {
+				lastRenewed = r.clock.Now()
+				continue
+			}
+			if err != nil {
+				fmt.Printf("worker %d lease renew error: %v\n", workerID, err)
+				// The lease may still be ours; keep trying until it would have expired
+				if r.clock.Now().Sub(lastRenewed)+interval < r.leaseTTL {
+					continue
+				}
+			}
+			fmt.Printf("worker %d lease lost: %s\n", workerID, key)
+			r.emit(Event{Type: EventLeaseLost, MessageID: msg.MessageID, Key: key, Err: err})
+			lose(ErrLeaseLost)
+			return
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reportFailure tells a FailureReporter source that msg's handler failed.
+func (r *Runner) reportFailure(msg *Message, err error, workerID int) {
+	reporter, ok := r.source.(FailureReporter)
+	if !ok {
+		return
+	}
+	failCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := reporter.Failed(failCtx, msg, err); err != nil {
+		fmt.Printf("worker %d failure report error: %v\n", workerID, err)
+	}
+}
+
+func (r *Runner) nack(msg *Message, delay time.Duration, workerID int) {
+	nackCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.source.Nack(nackCtx, msg, delay); err != nil {
+		fmt.Printf("worker %d nack error: %v\n", workerID, err)
 	}
 }
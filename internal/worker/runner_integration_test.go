@@ -6,6 +6,7 @@ package worker_test
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -16,6 +17,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 
+	"go-sqs-worker/internal/sqslocal"
 	"go-sqs-worker/internal/worker"
 )
 
@@ -38,13 +40,24 @@ func TestRunner_Integration_ProcessesAll(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Without SQS_ENDPOINT, e.g. ElasticMQ, run against an embedded server
+	endpoint := os.Getenv("SQS_ENDPOINT")
+	if endpoint == "" {
+		srv, err := sqslocal.Start("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("start sqslocal: %v", err)
+		}
+		defer srv.Close()
+		endpoint = srv.URL
+	}
+
 	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
-		o.BaseEndpoint = aws.String("http://localhost:9324")
+		o.BaseEndpoint = aws.String(endpoint)
 	})
 
 	_, err = client.ListQueues(ctx, &sqs.ListQueuesInput{})
 	if err != nil {
-		t.Fatalf("elasticmq unreachable: %v", err)
+		t.Fatalf("sqs unreachable at %s: %v", endpoint, err)
 	}
 
 	qName := fmt.Sprintf("test-runner-%d", time.Now().UnixNano())
@@ -0,0 +1,139 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/sqsfake"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// waitUntil polls cond until it holds or a second has passed.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func startRunner(t *testing.T, client *sqsfake.Client, queueURL string, handler worker.Handler) {
+	t.Helper()
+	poller := worker.NewPoller(client, queueURL).WithWaitTimeSeconds(0)
+	runner := worker.NewRunner(poller, handler, 1, 1).
+		WithIdleBackoff(1, time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRunner_SQSFakeRedeliversAfterVisibilityTimeout(t *testing.T) {
+	clk := &testClock{now: time.Unix(1700000000, 0)}
+	client := sqsfake.New().WithClock(clk.Now)
+	queueURL := client.MustCreateQueue("jobs", map[string]string{"VisibilityTimeout": "30"})
+	sendBody(t, client, queueURL, "flaky")
+
+	var mu sync.Mutex
+	var counts []int
+	startRunner(t, client, queueURL, func(ctx context.Context, msg *worker.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, msg.ReceiveCount)
+		if len(counts) == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	calls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(counts)
+	}
+
+	waitUntil(t, "first delivery", func() bool { return calls() == 1 })
+	// Hidden until the clock passes the visibility timeout, however long we wait
+	time.Sleep(20 * time.Millisecond)
+	if calls() != 1 || client.Stats(queueURL).InFlight != 1 {
+		t.Fatalf("expected the failed message to stay in flight, got %d calls", calls())
+	}
+
+	clk.Advance(30 * time.Second)
+	waitUntil(t, "redelivery", func() bool { return len(client.Messages(queueURL)) == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 2 {
+		t.Fatalf("expected deliveries with receive counts [1 2], got %v", counts)
+	}
+}
+
+func TestRunner_SQSFakeRedrivesPoisonMessage(t *testing.T) {
+	clk := &testClock{now: time.Unix(1700000000, 0)}
+	client := sqsfake.New().WithClock(clk.Now)
+	dlqURL := client.MustCreateQueue("jobs-dlq", nil)
+	queueURL := client.MustCreateQueue("jobs", map[string]string{
+		"VisibilityTimeout": "30",
+		"RedrivePolicy":     `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:jobs-dlq","maxReceiveCount":"3"}`,
+	})
+	sendBody(t, client, queueURL, "poison")
+
+	var mu sync.Mutex
+	calls := 0
+	startRunner(t, client, queueURL, func(ctx context.Context, msg *worker.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return errors.New("always fails")
+	})
+
+	for i := 1; i <= 3; i++ {
+		waitUntil(t, "delivery", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return calls == i
+		})
+		clk.Advance(30 * time.Second)
+	}
+	waitUntil(t, "redrive", func() bool { return len(client.Messages(dlqURL)) == 1 })
+	if n := len(client.Messages(queueURL)); n != 0 {
+		t.Fatalf("expected the source queue empty, got %d", n)
+	}
+}
+
+func sendBody(t *testing.T, client *sqsfake.Client, queueURL, body string) {
+	t.Helper()
+	if _, err := client.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: &queueURL, MessageBody: &body}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
@@ -3,12 +3,15 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
@@ -18,16 +21,20 @@ type fakeSQS struct {
 	messages       []types.Message
 	nextIndex      int
 	deletedHandles map[string]bool
+	visibility     map[string]int32
 	inFlight       int32
 	maxInFlight    int32
+	receiveCalls   atomic.Int32
 	err            error
 
 	// Hooks - set by individual tests
-	OnReceive func(msg types.Message)
-	OnDelete  func(handle string)
+	OnReceive          func(msg types.Message)
+	OnDelete           func(handle string)
+	OnChangeVisibility func(handle string, timeout int32)
 }
 
 func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.receiveCalls.Add(1)
 	if f.err != nil {
 		return nil, f.err
 	}
@@ -38,11 +45,13 @@ func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessage
 		return &sqs.ReceiveMessageOutput{Messages: nil}, nil
 	}
 
-	msg := f.messages[f.nextIndex]
-	f.nextIndex++
+	n := max(int(params.MaxNumberOfMessages), 1)
+	end := min(f.nextIndex+n, len(f.messages))
+	msgs := append([]types.Message(nil), f.messages[f.nextIndex:end]...)
+	f.nextIndex = end
 	f.mu.Unlock()
 
-	current := atomic.AddInt32(&f.inFlight, 1)
+	current := atomic.AddInt32(&f.inFlight, int32(len(msgs)))
 	for {
 		maxSeenInFlight := atomic.LoadInt32(&f.maxInFlight)
 		if current <= maxSeenInFlight || atomic.CompareAndSwapInt32(&f.maxInFlight, maxSeenInFlight, current) {
@@ -51,10 +60,12 @@ func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessage
 	}
 
 	if f.OnReceive != nil {
-		f.OnReceive(msg)
+		for _, msg := range msgs {
+			f.OnReceive(msg)
+		}
 	}
 
-	return &sqs.ReceiveMessageOutput{Messages: []types.Message{msg}}, nil
+	return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
 }
 
 func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
@@ -77,6 +88,31 @@ func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageIn
 	return &sqs.DeleteMessageOutput{}, nil
 }
 
+func (f *fakeSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	if f.visibility == nil {
+		f.visibility = make(map[string]int32)
+	}
+	handle := ""
+	if params.ReceiptHandle != nil {
+		handle = *params.ReceiptHandle
+		f.visibility[handle] = params.VisibilityTimeout
+	}
+	f.mu.Unlock()
+
+	if f.OnChangeVisibility != nil {
+		f.OnChangeVisibility(handle, params.VisibilityTimeout)
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) GetVisibility(handle string) (int32, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.visibility[handle]
+	return v, ok
+}
+
 func (f *fakeSQS) GetMaxInFlight() int32 {
 	return atomic.LoadInt32(&f.maxInFlight)
 }
@@ -301,3 +337,761 @@ func TestRunner_ProcessesAllMessages(t *testing.T) {
 		t.Fatal("timeout waiting for runner to exit")
 	}
 }
+
+func TestRunner_RateLimit_SpacesDispatch(t *testing.T) {
+	const numMessages = 5
+	const perSecond = 20
+
+	allDeleted := make(chan struct{})
+	var deleted atomic.Int32
+
+	client := &fakeSQS{
+		messages: makeMessages(numMessages),
+		OnDelete: func(handle string) {
+			if deleted.Add(1) == int32(numMessages) {
+				close(allDeleted)
+			}
+		},
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 5, 5).WithRateLimit(perSecond, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-allDeleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for messages to process")
+	}
+
+	// The first token is available immediately, every following one after 1/perSecond.
+	minElapsed := time.Duration(numMessages-1) * time.Second / perSecond
+	if elapsed := time.Since(start); elapsed < minElapsed {
+		t.Errorf("processed %d messages in %v, want at least %v", numMessages, elapsed, minElapsed)
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+type fakeRateLimiter struct {
+	mu         sync.Mutex
+	allowed    map[string]int
+	keys       []string
+	retryAfter time.Duration
+	err        error
+}
+
+func (f *fakeRateLimiter) Allow(ctx context.Context, key string) (time.Duration, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = append(f.keys, key)
+	if f.err != nil {
+		return 0, false, f.err
+	}
+	if f.allowed[key] > 0 {
+		f.allowed[key]--
+		return 0, true, nil
+	}
+	return f.retryAfter, false, nil
+}
+
+func TestRunner_SharedRateLimit_DelaysLimitedMessages(t *testing.T) {
+	tenant := func(id, tenant string) types.Message {
+		return types.Message{
+			MessageId:     &id,
+			Body:          &id,
+			ReceiptHandle: &id,
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"tenant": {DataType: aws.String("String"), StringValue: aws.String(tenant)},
+			},
+		}
+	}
+
+	settled := make(chan struct{})
+	var settledCount atomic.Int32
+	settle := func() {
+		if settledCount.Add(1) == 3 {
+			close(settled)
+		}
+	}
+
+	client := &fakeSQS{
+		messages: []types.Message{
+			tenant("1", "a"),
+			tenant("2", "a"),
+			tenant("3", "b"),
+		},
+		OnDelete:           func(handle string) { settle() },
+		OnChangeVisibility: func(handle string, timeout int32) { settle() },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	var processed atomic.Int32
+	handler := func(ctx context.Context, msg *Message) error {
+		processed.Add(1)
+		return nil
+	}
+
+	limiter := &fakeRateLimiter{
+		allowed:    map[string]int{"a": 1, "b": 1},
+		retryAfter: 1500 * time.Millisecond,
+	}
+	// One worker so messages are checked in receive order
+	runner := NewRunner(poller, handler, 1, 1).WithSharedRateLimit(limiter, "tenant")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-settled:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for messages to settle")
+	}
+
+	if got := processed.Load(); got != 2 {
+		t.Errorf("processed %d messages, want 2", got)
+	}
+	if got := client.GetDeletedCount(); got != 2 {
+		t.Errorf("deleted %d messages, want 2", got)
+	}
+	if v, ok := client.GetVisibility("2"); !ok || v != 2 {
+		t.Errorf("visibility of limited message = %d (set %v), want 2", v, ok)
+	}
+	if got := strings.Join(limiter.keys, ","); got != "a,a,b" {
+		t.Errorf("limiter keys = %q, want %q", got, "a,a,b")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+func TestRunner_SharedRateLimit_ReleasesOnLimiterError(t *testing.T) {
+	changed := make(chan int32, 1)
+	client := &fakeSQS{
+		messages: makeMessages(1),
+		OnChangeVisibility: func(handle string, timeout int32) {
+			select {
+			case changed <- timeout:
+			default:
+			}
+		},
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	var processed atomic.Int32
+	handler := func(ctx context.Context, msg *Message) error {
+		processed.Add(1)
+		return nil
+	}
+	limiter := &fakeRateLimiter{err: errors.New("redis down")}
+	runner := NewRunner(poller, handler, 1, 1).WithSharedRateLimit(limiter, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case v := <-changed:
+		if v != 1 {
+			t.Errorf("visibility = %d, want 1", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the message to be released")
+	}
+	if got := processed.Load(); got != 0 {
+		t.Errorf("processed %d messages, want 0", got)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRunner_CircuitBreaker_StopsReceivingWhenOpen(t *testing.T) {
+	released := make(chan struct{})
+
+	client := &fakeSQS{
+		messages: makeMessages(10),
+		OnChangeVisibility: func(handle string, timeout int32) {
+			close(released)
+		},
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		return errors.New("database down")
+	}
+
+	var mu sync.Mutex
+	var events []Event
+	runner := NewRunner(poller, handler, 1, 1).
+		WithCircuitBreaker(BreakerConfig{
+			MaxFailures:       2,
+			OpenDuration:      time.Minute,
+			ReleaseVisibility: 3 * time.Second,
+		}).
+		WithEventHandler(func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-released:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for breaker to trip")
+	}
+
+	// Give the feeder a chance to (wrongly) keep receiving
+	time.Sleep(50 * time.Millisecond)
+
+	client.mu.Lock()
+	received := client.nextIndex
+	client.mu.Unlock()
+	if received != 2 {
+		t.Errorf("received %d messages, want 2", received)
+	}
+	if v, _ := client.GetVisibility("2"); v != 3 {
+		t.Errorf("visibility of tripping message = %d, want 3", v)
+	}
+
+	mu.Lock()
+	if len(events) != 1 || events[0].Type != EventBreakerStateChange || events[0].State != "open" {
+		t.Errorf("expected one breaker open event, got %+v", events)
+	}
+	mu.Unlock()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+type countingLeaseStore struct {
+	*MemoryLeaseStore
+	extends atomic.Int32
+}
+
+func (s *countingLeaseStore) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	s.extends.Add(1)
+	return s.MemoryLeaseStore.Extend(ctx, key, token, ttl)
+}
+
+func TestRunner_RenewsLeaseWhileHandlerRuns(t *testing.T) {
+	store := &countingLeaseStore{MemoryLeaseStore: NewMemoryLeaseStore()}
+	deleted := make(chan struct{})
+
+	client := &fakeSQS{
+		messages: makeMessages(1),
+		OnDelete: func(handle string) { close(deleted) },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		// Outlive the 30ms lease several times over
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+
+	runner := NewRunner(poller, handler, 1, 1).WithLeaseStore(store, 30*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-deleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for message to be deleted")
+	}
+
+	if got := store.extends.Load(); got < 3 {
+		t.Errorf("expected the lease to be renewed at least 3 times, got %d", got)
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+func TestRunner_CancelsHandlerWhenLeaseLost(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	cause := make(chan error, 1)
+
+	client := &fakeSQS{messages: makeMessages(1)}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		store.Expire(msg.MessageID)
+		select {
+		case <-ctx.Done():
+			cause <- context.Cause(ctx)
+			return ctx.Err()
+		case <-time.After(time.Second):
+			cause <- nil
+			return nil
+		}
+	}
+
+	var lost atomic.Int32
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(store, 30*time.Millisecond).
+		WithEventHandler(func(e Event) {
+			if e.Type == EventLeaseLost {
+				lost.Add(1)
+			}
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case err := <-cause:
+		if !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("expected handler context cause ErrLeaseLost, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for handler")
+	}
+
+	if got := lost.Load(); got != 1 {
+		t.Errorf("expected one lease lost event, got %d", got)
+	}
+	if got := client.GetDeletedCount(); got != 0 {
+		t.Errorf("expected no delete after losing the lease, got %d", got)
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+func TestRunner_Idempotency_SkipsCompletedKeys(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	// "1" was completed under an earlier delivery
+	token, _, _ := store.Acquire(ctx, "1", time.Minute)
+	if err := store.Complete(ctx, "1", token, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	allDeleted := make(chan struct{})
+	var deleted atomic.Int32
+	client := &fakeSQS{
+		messages: makeMessages(2),
+		OnDelete: func(handle string) {
+			if deleted.Add(1) == 2 {
+				close(allDeleted)
+			}
+		},
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	var mu sync.Mutex
+	var handled []string
+	handler := func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, msg.MessageID)
+		return nil
+	}
+
+	var duplicates atomic.Int32
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(store, time.Minute).
+		WithIdempotency(time.Hour).
+		WithEventHandler(func(e Event) {
+			if e.Type == EventDuplicate {
+				duplicates.Add(1)
+			}
+		})
+
+	runCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(runCtx)
+	}()
+
+	select {
+	case <-allDeleted:
+	case <-runCtx.Done():
+		t.Fatal("timeout waiting for messages to be deleted")
+	}
+
+	mu.Lock()
+	if len(handled) != 1 || handled[0] != "2" {
+		t.Errorf("expected only message 2 to be handled, got %v", handled)
+	}
+	mu.Unlock()
+
+	if got := duplicates.Load(); got != 1 {
+		t.Errorf("expected one duplicate event, got %d", got)
+	}
+	if ok, _ := store.Completed(ctx, "2"); !ok {
+		t.Error("expected completion record for message 2")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+// completedErrLeaseStore fails every completion lookup.
+type completedErrLeaseStore struct {
+	*MemoryLeaseStore
+}
+
+func (s completedErrLeaseStore) Completed(ctx context.Context, key string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestRunner_Idempotency_ReleasesOnLookupError(t *testing.T) {
+	changed := make(chan int32, 1)
+	client := &fakeSQS{
+		messages: makeMessages(1),
+		OnChangeVisibility: func(handle string, timeout int32) {
+			select {
+			case changed <- timeout:
+			default:
+			}
+		},
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	var processed atomic.Int32
+	handler := func(ctx context.Context, msg *Message) error {
+		processed.Add(1)
+		return nil
+	}
+	store := completedErrLeaseStore{NewMemoryLeaseStore()}
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(store, time.Minute).
+		WithIdempotency(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case v := <-changed:
+		if v != 1 {
+			t.Errorf("visibility = %d, want 1", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the message to be released")
+	}
+	if got := processed.Load(); got != 0 {
+		t.Errorf("processed %d messages, want 0", got)
+	}
+	if ttl, _ := store.TTL(context.Background(), "1"); ttl > 0 {
+		t.Errorf("expected the lease released, %v left", ttl)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRunner_Idempotency_RequiresCompletionStore(t *testing.T) {
+	poller := NewPoller(&fakeSQS{}, "http://example.com/queue")
+	handler := func(ctx context.Context, msg *Message) error {
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 1, 1).WithIdempotency(time.Hour)
+
+	if err := runner.Run(context.Background()); err == nil {
+		t.Fatal("expected error without a completion-capable lease store")
+	}
+}
+
+func TestRunner_LeaseKey_DedupesResentEvents(t *testing.T) {
+	event := func(id, eventID string) types.Message {
+		return types.Message{
+			MessageId:     &id,
+			Body:          &id,
+			ReceiptHandle: &id,
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"event-id": {DataType: aws.String("String"), StringValue: aws.String(eventID)},
+			},
+		}
+	}
+	noKey := "3"
+
+	allDeleted := make(chan struct{})
+	var deleted atomic.Int32
+	client := &fakeSQS{
+		messages: []types.Message{
+			event("1", "evt-a"),
+			event("2", "evt-a"),
+			{MessageId: &noKey, Body: &noKey, ReceiptHandle: &noKey},
+		},
+		OnDelete: func(handle string) {
+			if deleted.Add(1) == 3 {
+				close(allDeleted)
+			}
+		},
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	var processed atomic.Int32
+	handler := func(ctx context.Context, msg *Message) error {
+		processed.Add(1)
+		return nil
+	}
+
+	var mu sync.Mutex
+	var events []Event
+	runner := NewRunner(poller, handler, 1, 1).
+		WithLeaseStore(NewMemoryLeaseStore(), time.Minute).
+		WithLeaseKey(AttributeKey("event-id")).
+		WithIdempotency(time.Hour).
+		WithEventHandler(func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-allDeleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for messages to be deleted")
+	}
+
+	if got := processed.Load(); got != 1 {
+		t.Errorf("processed %d messages, want 1", got)
+	}
+
+	mu.Lock()
+	if len(events) != 2 ||
+		events[0].Type != EventDuplicate || events[0].Key != "evt-a" ||
+		events[1].Type != EventPermanentFailure || !IsPermanent(events[1].Err) {
+		t.Errorf("expected duplicate then permanent failure events, got %+v", events)
+	}
+	mu.Unlock()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+func TestRunner_PermanentHandlerErrorDeletes(t *testing.T) {
+	deleted := make(chan struct{})
+	client := &fakeSQS{
+		messages: makeMessages(1),
+		OnDelete: func(handle string) { close(deleted) },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	handler := func(ctx context.Context, msg *Message) error {
+		return Permanent(errors.New("malformed payload"))
+	}
+
+	runner := NewRunner(poller, handler, 1, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-deleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for permanently failed message to be deleted")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for runner to exit")
+	}
+}
+
+func TestRunner_LeaseFromContext(t *testing.T) {
+	deleted := make(chan struct{})
+	client := &fakeSQS{
+		messages: makeMessages(1),
+		OnDelete: func(handle string) { close(deleted) },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	store := NewMemoryLeaseStore()
+	// Advance the fence so the handler sees a later one
+	_, _, _, _ = store.AcquireFenced(context.Background(), "other", time.Minute)
+
+	var got Lease
+	var found bool
+	handler := func(ctx context.Context, msg *Message) error {
+		got, found = LeaseFromContext(ctx)
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 1, 1).WithLeaseStore(store, 45*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	select {
+	case <-deleted:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for message to be processed")
+	}
+	cancel()
+	<-done
+
+	if !found {
+		t.Fatal("expected lease in handler context")
+	}
+	if got.Key != "1" || got.Token == "" || got.Fence != 2 {
+		t.Errorf("unexpected lease %+v", got)
+	}
+	if _, ok := LeaseFromContext(context.Background()); ok {
+		t.Error("expected no lease outside a handler")
+	}
+}
+
+func TestRunner_ReceiveBatch(t *testing.T) {
+	const total = 20
+	var wg sync.WaitGroup
+	wg.Add(total)
+	client := &fakeSQS{
+		messages: makeMessages(total),
+		OnDelete: func(handle string) { wg.Done() },
+	}
+	poller := NewPoller(client, "http://example.com/queue")
+
+	release := make(chan struct{})
+	handler := func(ctx context.Context, msg *Message) error {
+		<-release
+		return nil
+	}
+
+	runner := NewRunner(poller, handler, 10, 10).WithReceiveBatch(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	// Let the first batch fill every slot before anything completes
+	for client.GetMaxInFlight() < 10 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	firstFill := client.receiveCalls.Load()
+	close(release)
+
+	processed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(processed)
+	}()
+	select {
+	case <-processed:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for messages to be processed")
+	}
+	cancel()
+	<-done
+
+	if got := client.GetMaxInFlight(); got > 10 {
+		t.Errorf("expected at most 10 in flight, got %d", got)
+	}
+	if firstFill >= 10 {
+		t.Errorf("expected batched receives, took %d calls to fill 10 slots", firstFill)
+	}
+}
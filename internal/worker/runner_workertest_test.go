@@ -0,0 +1,239 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/workertest"
+)
+
+func TestRunner_DrainsInFlightOnShutdown(t *testing.T) {
+	h := workertest.New(t)
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error {
+		// Finish the work even though shutdown cancelled the context
+		<-ctx.Done()
+		close(cancelled)
+		<-release
+		return nil
+	}, 1, 1))
+
+	id := h.Send("slow")
+	h.WaitFor(workertest.EventReceived, id, 1)
+	h.Shutdown()
+
+	// The Runner has seen the shutdown but waits for the in-flight handler
+	<-cancelled
+	if h.Stopped() {
+		t.Fatal("expected Run to wait for the in-flight handler")
+	}
+	h.ExpectNotAcked(id)
+
+	close(release)
+	if err := h.Stop(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	h.ExpectAcked(id)
+	if n := h.Source.InFlight(); n != 0 {
+		t.Fatalf("expected nothing left in flight, got %d", n)
+	}
+}
+
+func TestRunner_DrainLeavesUnstartedMessagesForRedelivery(t *testing.T) {
+	h := workertest.New(t)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error {
+		calls.Add(1)
+		<-release
+		return ctx.Err()
+	}, 1, 1))
+
+	first := h.Send("first")
+	second := h.Send("second")
+	h.WaitFor(workertest.EventReceived, first, 1)
+	h.Shutdown()
+	close(release)
+	_ = h.Stop()
+
+	// The handler saw the cancelled context and failed; nothing was deleted
+	h.ExpectNotAcked(first)
+	if calls.Load() != 1 || len(h.Log.Filter(workertest.EventReceived, second)) != 0 {
+		t.Fatalf("expected only the first message handled, got %d calls", calls.Load())
+	}
+	if h.Source.Visible() != 1 || h.Source.InFlight() != 1 {
+		t.Fatalf("expected 1 visible and 1 in flight, got %d and %d", h.Source.Visible(), h.Source.InFlight())
+	}
+}
+
+func TestRunner_HandlerTimeout(t *testing.T) {
+	h := workertest.New(t)
+	var attempts atomic.Int32
+	errs := make(chan error, 1)
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error {
+		if attempts.Add(1) > 1 {
+			return nil
+		}
+		// Contexts derived from the handler's see the deadline too
+		child, cancel := context.WithCancel(ctx)
+		defer cancel()
+		<-child.Done()
+		errs <- child.Err()
+		return ctx.Err()
+	}, 1, 1).WithHandlerTimeout(10 * time.Second))
+
+	id := h.Send("stuck")
+	h.WaitFor(workertest.EventReceived, id, 1)
+	// The visibility timeout and the handler timeout
+	h.WaitForTimers(2)
+	h.Advance(10 * time.Second)
+
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	h.WaitFor(workertest.EventFailed, id, 1)
+	h.ExpectNotAcked(id)
+
+	h.Advance(20 * time.Second)
+	h.ExpectRedelivered(id, 1)
+	h.ExpectAcked(id)
+}
+
+func TestRunner_RenewsLeaseOnClock(t *testing.T) {
+	h := workertest.New(t)
+	h.Source.WithVisibilityTimeout(time.Hour)
+	release := make(chan struct{})
+	lost := make(chan error, 1)
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			lost <- context.Cause(ctx)
+			return ctx.Err()
+		}
+	}, 1, 1).WithLeaseStore(h.Leases, 30*time.Second).WithHandlerTimeout(time.Hour))
+
+	id := h.Send("long")
+	h.WaitFor(workertest.EventLeaseAcquired, id, 1)
+	for i := 1; i <= 3; i++ {
+		// The visibility timeout, the handler timeout and the renewal tick
+		h.WaitForTimers(3)
+		h.Advance(10 * time.Second)
+		if e := h.WaitFor(workertest.EventLeaseExtended, id, i); e.Stale {
+			t.Fatalf("renewal %d failed", i)
+		}
+	}
+
+	close(release)
+	h.ExpectAcked(id)
+	h.WaitFor(workertest.EventLeaseReleased, id, 1)
+	select {
+	case err := <-lost:
+		t.Fatalf("handler cancelled: %v", err)
+	default:
+	}
+}
+
+func TestRunner_ExtendsVisibilityWhileHandling(t *testing.T) {
+	h := workertest.New(t)
+	release := make(chan struct{})
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error {
+		<-release
+		return nil
+	}, 1, 1).WithVisibilityExtension(30 * time.Second).WithHandlerTimeout(time.Hour))
+
+	id := h.Send("long")
+	h.WaitFor(workertest.EventReceived, id, 1)
+	for i := 1; i <= 6; i++ {
+		// The visibility timeout, the handler timeout and the renewal tick
+		h.WaitForTimers(3)
+		h.Advance(10 * time.Second)
+		if e := h.WaitFor(workertest.EventExtended, id, i); e.Stale || e.Delay != 30*time.Second {
+			t.Fatalf("unexpected extension %d: %v", i, e)
+		}
+	}
+
+	// A minute past the original visibility timeout, still ours
+	close(release)
+	h.ExpectAcked(id)
+	h.ExpectRedelivered(id, 0)
+}
+
+// unpingableLeases fails Acquire while down and has no Ping to probe it.
+type unpingableLeases struct {
+	worker.LeaseStore
+	down atomic.Bool
+}
+
+func (s *unpingableLeases) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	if s.down.Load() {
+		return "", false, errors.New("connection refused")
+	}
+	return s.LeaseStore.Acquire(ctx, key, ttl)
+}
+
+func TestRunner_LeaseFailClosed_BacksOffWithoutPing(t *testing.T) {
+	h := workertest.New(t)
+	store := &unpingableLeases{LeaseStore: h.Leases}
+	store.down.Store(true)
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error { return nil }, 1, 1).
+		WithLeaseStore(store, 30*time.Second).
+		WithLeaseFailover(worker.LeaseFailover{HealthInterval: 5 * time.Second, ReleaseVisibility: 5 * time.Second}))
+
+	ids := []string{h.Send("a"), h.Send("b"), h.Send("c")}
+	h.ExpectNacked(ids[0], 5*time.Second)
+	// The release and the receive backoff
+	h.WaitForTimers(2)
+	if n := len(h.Log.Filter(workertest.EventReceived, "")); n != 1 {
+		t.Fatalf("expected receiving to back off after the failed acquire, got %d receives", n)
+	}
+
+	// Tried again with one message, still down
+	h.Advance(5 * time.Second)
+	h.ExpectNacked(ids[1], 5*time.Second)
+	h.WaitForTimers(2)
+	if n := len(h.Log.Filter(workertest.EventReceived, "")); n != 2 {
+		t.Fatalf("expected one receive per interval, got %d receives", n)
+	}
+
+	store.down.Store(false)
+	h.Advance(5 * time.Second)
+	for _, id := range ids {
+		h.ExpectAcked(id)
+	}
+}
+
+func TestRunner_BreakerOpenDurationOnClock(t *testing.T) {
+	h := workertest.New(t)
+	var calls atomic.Int32
+	h.Start(h.Runner(func(ctx context.Context, msg *worker.Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("downstream down")
+		}
+		return nil
+	}, 1, 1).WithCircuitBreaker(worker.BreakerConfig{MaxFailures: 1, OpenDuration: time.Minute, ReleaseVisibility: 5 * time.Second}))
+
+	first := h.Send("a")
+	h.ExpectNacked(first, 5*time.Second)
+	second := h.Send("b")
+	// The release and the open period
+	h.WaitForTimers(2)
+
+	// The released message is visible again, but the breaker is still open
+	h.Advance(30 * time.Second)
+	h.WaitForTimers(1)
+	if n := len(h.Log.Filter(workertest.EventReceived, "")); n != 1 {
+		t.Fatalf("expected no receives while open, got %d", n)
+	}
+
+	h.Advance(30 * time.Second)
+	h.ExpectAcked(second)
+	h.ExpectAcked(first)
+	h.ExpectRedelivered(first, 1)
+}
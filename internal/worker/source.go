@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// Source is a message broker the Runner consumes from. Poller is the SQS
+// implementation.
+type Source interface {
+	// Receive returns up to max messages, or none if nothing arrived in
+	// the source's wait time.
+	Receive(ctx context.Context, max int) ([]*Message, error)
+	// Ack removes a processed message for good.
+	Ack(ctx context.Context, msg *Message) error
+	// Nack hands msg back for redelivery after delay; zero redelivers it
+	// straight away.
+	Nack(ctx context.Context, msg *Message, delay time.Duration) error
+	// Extend keeps msg from being redelivered for another d while it is
+	// still being worked on.
+	Extend(ctx context.Context, msg *Message, d time.Duration) error
+}
+
+// LongPoller is implemented by sources whose Receive waits for messages to
+// arrive. The Runner's idle backoff only applies to sources that don't.
+type LongPoller interface {
+	LongPolling() bool
+}
+
+// FailureReporter is implemented by sources that want to hear when a
+// handler fails with a retryable error, rather than waiting for the
+// message's visibility timeout to run out, e.g. to answer a pushed request.
+type FailureReporter interface {
+	Failed(ctx context.Context, msg *Message, err error) error
+}
+
+func longPolling(s Source) bool {
+	lp, ok := s.(LongPoller)
+	return ok && lp.LongPolling()
+}
@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChanSource is an in-memory Source backed by a buffered channel, for
+// embedding a Runner in a process or driving one from tests. Received
+// messages that aren't acked within the visibility timeout are delivered
+// again, like SQS.
+type ChanSource struct {
+	ch         chan *Message
+	visibility time.Duration
+	waitTime   time.Duration
+
+	mu sync.Mutex
+	// inFlight maps receipt handles to the timer that redelivers them
+	inFlight map[string]*time.Timer
+}
+
+// NewChanSource creates a source holding up to capacity waiting messages.
+func NewChanSource(capacity int) *ChanSource {
+	return &ChanSource{
+		ch:         make(chan *Message, capacity),
+		visibility: 30 * time.Second,
+		waitTime:   time.Second,
+		inFlight:   make(map[string]*time.Timer),
+	}
+}
+
+// WithVisibilityTimeout sets how long a received message stays hidden
+// before it is delivered again. Defaults to 30s.
+func (s *ChanSource) WithVisibilityTimeout(d time.Duration) *ChanSource {
+	s.visibility = d
+	return s
+}
+
+// WithWaitTime sets how long Receive waits for a message. Defaults to 1s.
+func (s *ChanSource) WithWaitTime(d time.Duration) *ChanSource {
+	s.waitTime = d
+	return s
+}
+
+// Send enqueues a message with a fresh ID, blocking while the source is
+// full.
+func (s *ChanSource) Send(ctx context.Context, body string, attrs map[string]string) (string, error) {
+	msg := &Message{MessageID: uuid.New().String(), Body: body, Attributes: attrs}
+	select {
+	case s.ch <- msg:
+		return msg.MessageID, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Len reports how many messages are waiting to be received.
+func (s *ChanSource) Len() int {
+	return len(s.ch)
+}
+
+// InFlight reports how many received messages are neither acked nor due
+// for redelivery.
+func (s *ChanSource) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inFlight)
+}
+
+func (s *ChanSource) Receive(ctx context.Context, max int) ([]*Message, error) {
+	timer := time.NewTimer(s.waitTime)
+	defer timer.Stop()
+
+	var msgs []*Message
+	select {
+	case msg := <-s.ch:
+		msgs = append(msgs, s.deliver(msg))
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Fill the batch with whatever else is already waiting
+	for len(msgs) < max {
+		select {
+		case msg := <-s.ch:
+			msgs = append(msgs, s.deliver(msg))
+		default:
+			return msgs, nil
+		}
+	}
+	return msgs, nil
+}
+
+// deliver hands out msg under a new receipt handle, so acks from an
+// earlier delivery don't affect this one.
+func (s *ChanSource) deliver(msg *Message) *Message {
+	handle := uuid.New().String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Only one delivery of msg is outstanding at a time, so msg is ours
+	msg.ReceiveCount++
+	delivered := *msg
+	delivered.ReceiptHandle = &handle
+	s.inFlight[handle] = time.AfterFunc(s.visibility, func() { s.requeue(handle, msg) })
+	return &delivered
+}
+
+// requeue puts msg back once its handle's visibility runs out.
+func (s *ChanSource) requeue(handle string, msg *Message) {
+	s.mu.Lock()
+	_, ok := s.inFlight[handle]
+	delete(s.inFlight, handle)
+	s.mu.Unlock()
+
+	if ok {
+		// Don't block the timer goroutine on a full channel
+		go func() { s.ch <- msg }()
+	}
+}
+
+// reset moves the redelivery of msg to d from now. It reports false if msg
+// was already acked or redelivered.
+func (s *ChanSource) reset(msg *Message, d time.Duration) bool {
+	if msg.ReceiptHandle == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timer, ok := s.inFlight[*msg.ReceiptHandle]
+	if !ok {
+		return false
+	}
+	if !timer.Stop() {
+		// Already firing; the requeue will go ahead
+		return false
+	}
+	timer.Reset(d)
+	return true
+}
+
+func (s *ChanSource) Ack(ctx context.Context, msg *Message) error {
+	if msg.ReceiptHandle == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timer, ok := s.inFlight[*msg.ReceiptHandle]; ok {
+		timer.Stop()
+		delete(s.inFlight, *msg.ReceiptHandle)
+	}
+	return nil
+}
+
+func (s *ChanSource) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	s.reset(msg, delay)
+	return nil
+}
+
+func (s *ChanSource) Extend(ctx context.Context, msg *Message, d time.Duration) error {
+	s.reset(msg, d)
+	return nil
+}
+
+// LongPolling reports true: Receive waits for messages.
+func (s *ChanSource) LongPolling() bool {
+	return true
+}
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sendN(t *testing.T, s *ChanSource, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := s.Send(context.Background(), fmt.Sprintf("msg-%d", i), nil); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+}
+
+func TestChanSource_ReceiveBatch(t *testing.T) {
+	s := NewChanSource(10)
+	sendN(t, s, 3)
+
+	msgs, err := s.Receive(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	if s.Len() != 1 || s.InFlight() != 2 {
+		t.Fatalf("expected 1 waiting and 2 in flight, got %d/%d", s.Len(), s.InFlight())
+	}
+}
+
+func TestChanSource_ReceiveWaitsThenReturnsEmpty(t *testing.T) {
+	s := NewChanSource(1).WithWaitTime(20 * time.Millisecond)
+
+	start := time.Now()
+	msgs, err := s.Receive(context.Background(), 1)
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("expected no messages, got %d (err %v)", len(msgs), err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expected receive to wait")
+	}
+}
+
+func TestChanSource_RedeliversAfterVisibilityTimeout(t *testing.T) {
+	s := NewChanSource(1).WithVisibilityTimeout(20 * time.Millisecond)
+	sendN(t, s, 1)
+	ctx := context.Background()
+
+	first, _ := s.Receive(ctx, 1)
+	second, _ := s.Receive(ctx, 1)
+	if len(second) != 1 || second[0].MessageID != first[0].MessageID {
+		t.Fatal("expected unacked message to be redelivered")
+	}
+	if *second[0].ReceiptHandle == *first[0].ReceiptHandle {
+		t.Fatal("expected a new receipt handle on redelivery")
+	}
+	if first[0].ReceiveCount != 1 || second[0].ReceiveCount != 2 {
+		t.Fatalf("expected receive counts 1 and 2, got %d and %d", first[0].ReceiveCount, second[0].ReceiveCount)
+	}
+
+	// The stale handle can't ack the new delivery
+	_ = s.Ack(ctx, first[0])
+	if s.InFlight() != 1 {
+		t.Fatal("expected stale ack to be ignored")
+	}
+	_ = s.Ack(ctx, second[0])
+	if s.InFlight() != 0 {
+		t.Fatal("expected ack to settle the message")
+	}
+}
+
+func TestChanSource_NackAndExtend(t *testing.T) {
+	s := NewChanSource(1).WithVisibilityTimeout(time.Hour).WithWaitTime(50 * time.Millisecond)
+	sendN(t, s, 1)
+	ctx := context.Background()
+
+	msgs, _ := s.Receive(ctx, 1)
+	_ = s.Nack(ctx, msgs[0], 0)
+	again, _ := s.Receive(ctx, 1)
+	if len(again) != 1 {
+		t.Fatal("expected nacked message to come back")
+	}
+
+	s.WithVisibilityTimeout(20 * time.Millisecond)
+	_ = s.Extend(ctx, again[0], time.Hour)
+	if msgs, _ := s.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected extended message to stay hidden")
+	}
+}
+
+func TestRunner_ChanSource(t *testing.T) {
+	const total = 20
+	source := NewChanSource(total).WithWaitTime(10 * time.Millisecond)
+	sendN(t, source, total)
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	wg.Add(total)
+	handler := func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen[msg.Body] {
+			seen[msg.Body] = true
+			wg.Done()
+		}
+		return nil
+	}
+
+	runner := NewRunner(source, handler, 5, 3).WithReceiveBatch(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	processed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(processed)
+	}()
+	select {
+	case <-processed:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for messages")
+	}
+
+	// Acks land just after the handlers return
+	deadline := time.Now().Add(time.Second)
+	for source.InFlight() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := source.InFlight(); n != 0 {
+		t.Errorf("expected every message acked, %d still in flight", n)
+	}
+}
@@ -0,0 +1,614 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	walFileName = "queue.wal"
+	// walHeaderSize is the length and CRC32 prefixed to every record
+	walHeaderSize = 8
+	// walMaxRecord guards replay against a corrupt length prefix
+	walMaxRecord = 64 << 20
+
+	walEnqueue    = "enq"
+	walReceive    = "recv"
+	walVisibility = "vis"
+	walAck        = "ack"
+)
+
+var walCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is one entry in the log. An enqueue record carries the whole
+// message; compaction rewrites live messages as enqueue records with their
+// receive count and visibility.
+type walRecord struct {
+	Op    string            `json:"op"`
+	ID    string            `json:"id"`
+	Body  string            `json:"body,omitempty"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+	Count int               `json:"count,omitempty"`
+	// Until is when the message becomes visible, in Unix milliseconds
+	Until int64 `json:"until,omitempty"`
+}
+
+type fileMessage struct {
+	id           string
+	body         string
+	attrs        map[string]string
+	receiveCount int
+	until        int64
+	removed      bool
+	// deadLettering is set while the message is sent to the dead-letter
+	// queue, so no other Receive claims it meanwhile
+	deadLettering bool
+}
+
+// FileQueue is a durable queue in a local write-ahead log, for edge boxes
+// that must keep accepting work while offline. It behaves like SQS:
+// received messages stay hidden for the visibility timeout and come back if
+// they aren't acked, each delivery bumps the receive count, and with
+// WithDeadLetter a message received too often moves to a dead-letter queue.
+//
+// Every change is appended to the log and, unless WithSyncWrites(false),
+// fsynced before it takes effect, so a crash loses nothing that was
+// acknowledged to the caller. A torn record at the end of the log is
+// dropped on open. The log is compacted to the live messages once it is
+// mostly superseded records.
+type FileQueue struct {
+	dir          string
+	visibility   time.Duration
+	waitTime     time.Duration
+	syncWrites   bool
+	compactAfter int
+	now          func() time.Time
+
+	deadLetter  *FileQueue
+	maxReceives int
+
+	mu   sync.Mutex
+	file *os.File
+	msgs map[string]*fileMessage
+	// order is the delivery order; removed counts the acked messages it
+	// still holds
+	order   []*fileMessage
+	removed int
+	// records counts the records in the log
+	records int
+	notify  chan struct{}
+}
+
+// OpenFileQueue opens the queue in dir, creating it if needed, and replays
+// its log.
+func OpenFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &FileQueue{
+		dir:          dir,
+		visibility:   30 * time.Second,
+		waitTime:     time.Second,
+		syncWrites:   true,
+		compactAfter: 1000,
+		now:          time.Now,
+		msgs:         make(map[string]*fileMessage),
+		notify:       make(chan struct{}),
+	}
+
+	path := filepath.Join(dir, walFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.replay(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("replay %s: %w", path, err)
+	}
+	q.file = f
+	return q, syncDir(dir)
+}
+
+// WithVisibilityTimeout sets how long a received message stays hidden
+// before it is delivered again. Defaults to 30s.
+func (q *FileQueue) WithVisibilityTimeout(d time.Duration) *FileQueue {
+	q.visibility = d
+	return q
+}
+
+// WithWaitTime sets how long Receive waits for a message. Defaults to 1s.
+func (q *FileQueue) WithWaitTime(d time.Duration) *FileQueue {
+	q.waitTime = d
+	return q
+}
+
+// WithDeadLetter moves a message that has already been received
+// maxReceives times to dlq instead of delivering it again.
+func (q *FileQueue) WithDeadLetter(dlq *FileQueue, maxReceives int) *FileQueue {
+	q.deadLetter = dlq
+	q.maxReceives = maxReceives
+	return q
+}
+
+// WithSyncWrites controls whether each write is fsynced. Turning it off is
+// faster but a power cut can lose the latest changes. Defaults to true.
+func (q *FileQueue) WithSyncWrites(sync bool) *FileQueue {
+	q.syncWrites = sync
+	return q
+}
+
+// WithCompactThreshold sets how many records the log must hold before it
+// is compacted; it is only compacted while most of them are superseded.
+// Defaults to 1000.
+func (q *FileQueue) WithCompactThreshold(n int) *FileQueue {
+	q.compactAfter = n
+	return q
+}
+
+// WithClock replaces time.Now, e.g. to drive visibility from a fake clock.
+func (q *FileQueue) WithClock(now func() time.Time) *FileQueue {
+	q.now = now
+	return q
+}
+
+// replay rebuilds the queue from f and leaves f positioned for appends. A
+// short or corrupt record ends the log: it can only be a write that was cut
+// off, so it and anything after it are truncated.
+func (q *FileQueue) replay(f *os.File) error {
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readWALRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Printf("file queue %s: dropping torn log tail at offset %d: %v\n", q.dir, offset, err)
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		q.apply(rec)
+		offset += n
+	}
+	_, err := f.Seek(offset, io.SeekStart)
+	return err
+}
+
+func readWALRecord(r io.Reader) (walRecord, int64, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return walRecord{}, 0, errors.New("short header")
+		}
+		return walRecord{}, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > walMaxRecord {
+		return walRecord{}, 0, fmt.Errorf("record length %d out of range", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return walRecord{}, 0, errors.New("short record")
+	}
+	if crc32.Checksum(payload, walCRC) != binary.BigEndian.Uint32(header[4:]) {
+		return walRecord{}, 0, errors.New("checksum mismatch")
+	}
+	var rec walRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return walRecord{}, 0, err
+	}
+	return rec, int64(walHeaderSize + size), nil
+}
+
+func appendWALRecord(buf []byte, rec walRecord) []byte {
+	payload, _ := json.Marshal(rec)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, walCRC))
+	return append(buf, payload...)
+}
+
+// apply updates the in-memory state with rec. Callers hold q.mu, except
+// during replay.
+func (q *FileQueue) apply(rec walRecord) {
+	q.records++
+	if rec.Op == walEnqueue {
+		m := &fileMessage{id: rec.ID, body: rec.Body, attrs: rec.Attrs, receiveCount: rec.Count, until: rec.Until}
+		q.msgs[rec.ID] = m
+		q.order = append(q.order, m)
+		return
+	}
+	m, ok := q.msgs[rec.ID]
+	if !ok {
+		return
+	}
+	switch rec.Op {
+	case walReceive:
+		m.receiveCount = rec.Count
+		m.until = rec.Until
+	case walVisibility:
+		m.until = rec.Until
+	case walAck:
+		m.removed = true
+		delete(q.msgs, rec.ID)
+		q.removed++
+	}
+}
+
+// write appends recs to the log in one write and applies them once they
+// are durable. Callers hold q.mu.
+func (q *FileQueue) write(recs ...walRecord) error {
+	if q.file == nil {
+		return errors.New("file queue closed")
+	}
+	var buf []byte
+	for _, rec := range recs {
+		buf = appendWALRecord(buf, rec)
+	}
+	offset, err := q.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(buf); err != nil {
+		// Cut off a partial record so later appends aren't lost behind it
+		if terr := q.file.Truncate(offset); terr == nil {
+			_, _ = q.file.Seek(offset, io.SeekStart)
+		}
+		return fmt.Errorf("append to log: %w", err)
+	}
+	if q.syncWrites {
+		if err := q.file.Sync(); err != nil {
+			return fmt.Errorf("sync log: %w", err)
+		}
+	}
+	for _, rec := range recs {
+		q.apply(rec)
+	}
+	// The records are durable either way; a failed compaction is retried
+	// on a later write
+	if err := q.maybeCompact(); err != nil {
+		fmt.Printf("file queue %s: %v\n", q.dir, err)
+	}
+	return nil
+}
+
+// wake releases Receive calls waiting for a message. Callers hold q.mu.
+func (q *FileQueue) wake() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// Send appends a message and returns its ID.
+func (q *FileQueue) Send(ctx context.Context, body string, attrs map[string]string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.write(walRecord{Op: walEnqueue, ID: id, Body: body, Attrs: attrs}); err != nil {
+		return "", err
+	}
+	q.wake()
+	return id, nil
+}
+
+// Receive returns up to max visible messages in the order they were sent,
+// waiting up to the wait time for one to become available.
+func (q *FileQueue) Receive(ctx context.Context, max int) ([]*Message, error) {
+	if max < 1 {
+		max = 1
+	}
+	deadline := time.NewTimer(q.waitTime)
+	defer deadline.Stop()
+
+	for {
+		q.mu.Lock()
+		msgs, dead, next, err := q.claim(max)
+		notify := q.notify
+		q.mu.Unlock()
+		// Sent without q.mu held, so queues that dead-letter into each
+		// other can't deadlock
+		if dlErr := q.sendDeadLetters(dead); err == nil {
+			err = dlErr
+		}
+		if err != nil || len(msgs) > 0 {
+			return msgs, err
+		}
+
+		// Wake up for new messages or the next one to become visible
+		var due <-chan time.Time
+		var timer *time.Timer
+		if next > 0 {
+			timer = time.NewTimer(next)
+			due = timer.C
+		}
+		select {
+		case <-notify:
+		case <-due:
+		case <-deadline.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// claim receives up to max visible messages and returns those out of
+// receives to be dead-lettered. If none are visible it reports how long
+// until the next one is. Callers hold q.mu.
+func (q *FileQueue) claim(max int) ([]*Message, []*fileMessage, time.Duration, error) {
+	now := q.now().UnixMilli()
+	until := now + q.visibility.Milliseconds()
+
+	var (
+		recs []walRecord
+		dead []*fileMessage
+		next int64
+	)
+	for _, m := range q.order {
+		if len(recs) >= max {
+			break
+		}
+		if m.removed || m.deadLettering {
+			continue
+		}
+		if m.until > now {
+			if next == 0 || m.until-now < next {
+				next = m.until - now
+			}
+			continue
+		}
+		if q.deadLetter != nil && m.receiveCount >= q.maxReceives {
+			m.deadLettering = true
+			dead = append(dead, m)
+			continue
+		}
+		recs = append(recs, walRecord{Op: walReceive, ID: m.id, Count: m.receiveCount + 1, Until: until})
+	}
+
+	if len(recs) == 0 {
+		return nil, dead, time.Duration(next) * time.Millisecond, nil
+	}
+	if err := q.write(recs...); err != nil {
+		return nil, dead, 0, err
+	}
+
+	msgs := make([]*Message, 0, len(recs))
+	for _, rec := range recs {
+		m := q.msgs[rec.ID]
+		handle := fileReceipt(m.id, m.receiveCount)
+		msgs = append(msgs, &Message{
+			MessageID:     m.id,
+			Body:          m.body,
+			ReceiptHandle: &handle,
+			Attributes:    m.attrs,
+			ReceiveCount:  m.receiveCount,
+		})
+	}
+	return msgs, dead, 0, nil
+}
+
+// sendDeadLetters moves dead from this queue to the dead-letter queue. It
+// is called without q.mu held; on error the rest are left to be claimed
+// again.
+func (q *FileQueue) sendDeadLetters(dead []*fileMessage) error {
+	for i, m := range dead {
+		// Durable in the dead-letter queue before it leaves this one
+		_, err := q.deadLetter.Send(context.Background(), m.body, m.attrs)
+		q.mu.Lock()
+		if err != nil {
+			err = fmt.Errorf("dead-letter %s: %w", m.id, err)
+		} else if !m.removed {
+			err = q.write(walRecord{Op: walAck, ID: m.id})
+		}
+		if err != nil {
+			for _, m := range dead[i:] {
+				m.deadLettering = false
+			}
+			q.mu.Unlock()
+			return err
+		}
+		q.mu.Unlock()
+		fmt.Printf("file queue %s: dead-lettered %s after %d receives\n", q.dir, m.id, m.receiveCount)
+	}
+	return nil
+}
+
+// fileReceipt identifies one delivery of a message, so a handle from an
+// earlier delivery no longer matches once it is received again.
+func fileReceipt(id string, receiveCount int) string {
+	return id + ":" + strconv.Itoa(receiveCount)
+}
+
+// current returns the message msg's receipt handle still refers to. Callers
+// hold q.mu.
+func (q *FileQueue) current(msg *Message) (*fileMessage, bool) {
+	if msg.ReceiptHandle == nil {
+		return nil, false
+	}
+	id, count, ok := strings.Cut(*msg.ReceiptHandle, ":")
+	if !ok {
+		return nil, false
+	}
+	m, ok := q.msgs[id]
+	if !ok || strconv.Itoa(m.receiveCount) != count {
+		return nil, false
+	}
+	return m, true
+}
+
+// Ack removes msg. It is a no-op if msg has since been received again.
+func (q *FileQueue) Ack(ctx context.Context, msg *Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok := q.current(msg)
+	if !ok {
+		return nil
+	}
+	return q.write(walRecord{Op: walAck, ID: m.id})
+}
+
+// Nack makes msg visible again after delay.
+func (q *FileQueue) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return q.setVisibility(msg, delay)
+}
+
+// Extend keeps msg hidden for another d.
+func (q *FileQueue) Extend(ctx context.Context, msg *Message, d time.Duration) error {
+	return q.setVisibility(msg, d)
+}
+
+func (q *FileQueue) setVisibility(msg *Message, d time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok := q.current(msg)
+	if !ok {
+		return nil
+	}
+	until := q.now().Add(d).UnixMilli()
+	if err := q.write(walRecord{Op: walVisibility, ID: m.id, Until: until}); err != nil {
+		return err
+	}
+	q.wake()
+	return nil
+}
+
+// LongPolling reports true: Receive waits for messages.
+func (q *FileQueue) LongPolling() bool {
+	return true
+}
+
+// Len reports how many messages are in the queue, in flight or not.
+func (q *FileQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs)
+}
+
+// maybeCompact drops removed messages from the delivery order and compacts
+// the log once it holds compactAfter records and more than half of them are
+// superseded. Callers hold q.mu.
+func (q *FileQueue) maybeCompact() error {
+	if q.removed > len(q.order)/2 {
+		live := q.order[:0]
+		for _, m := range q.order {
+			if !m.removed {
+				live = append(live, m)
+			}
+		}
+		clear(q.order[len(live):])
+		q.order = live
+		q.removed = 0
+	}
+	if q.records < q.compactAfter || q.records < 2*len(q.msgs) {
+		return nil
+	}
+	return q.compact()
+}
+
+// Compact rewrites the log to hold only the live messages.
+func (q *FileQueue) Compact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.compact()
+}
+
+// compact writes the live messages to a new log and renames it over the
+// old one, so a crash part way leaves one or the other intact. Callers hold
+// q.mu.
+func (q *FileQueue) compact() error {
+	if q.file == nil {
+		return errors.New("file queue closed")
+	}
+	path := filepath.Join(q.dir, walFileName)
+	tmpPath := path + ".compact"
+
+	var buf []byte
+	live := 0
+	for _, m := range q.order {
+		if m.removed {
+			continue
+		}
+		buf = appendWALRecord(buf, walRecord{
+			Op:    walEnqueue,
+			ID:    m.id,
+			Body:  m.body,
+			Attrs: m.attrs,
+			Count: m.receiveCount,
+			Until: m.until,
+		})
+		live++
+	}
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	_, err = compactWrite(tmp, buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		// The old log is still in place and complete
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("compact: %w", err)
+	}
+
+	// The new log is in place either way; only the rename may not be durable
+	_ = q.file.Close()
+	q.file = tmp
+	q.records = live
+	if err := syncDir(q.dir); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	return nil
+}
+
+// compactWrite writes a compacted log; tests replace it to inject failures.
+var compactWrite = (*os.File).Write
+
+// Close closes the log. Later calls to modify the queue fail.
+func (q *FileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
+
+// syncDir makes a file created or renamed in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,554 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func openTestFileQueue(t *testing.T, dir string, clock *fakeClock) *FileQueue {
+	t.Helper()
+	q, err := OpenFileQueue(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = q.Close() })
+	q.WithWaitTime(10 * time.Millisecond).WithVisibilityTimeout(30 * time.Second)
+	if clock != nil {
+		q.WithClock(clock.Now)
+	}
+	return q
+}
+
+func newFileQueueClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1_700_000_000, 0)}
+}
+
+func receiveOne(t *testing.T, q *FileQueue) *Message {
+	t.Helper()
+	msgs, err := q.Receive(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	return msgs[0]
+}
+
+func TestFileQueue_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	q := openTestFileQueue(t, dir, nil)
+
+	for i := 0; i < 3; i++ {
+		if _, err := q.Send(ctx, fmt.Sprintf("msg-%d", i), map[string]string{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	first := receiveOne(t, q)
+	if first.Body != "msg-0" || first.Attributes["n"] != "0" || first.ReceiveCount != 1 {
+		t.Fatalf("unexpected message: %+v", first)
+	}
+	if err := q.Ack(ctx, first); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	_ = q.Close()
+
+	q = openTestFileQueue(t, dir, nil)
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 messages after reopen, got %d", q.Len())
+	}
+	msgs, err := q.Receive(ctx, 10)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Body != "msg-1" || msgs[1].Body != "msg-2" {
+		t.Fatalf("expected msg-1 and msg-2 in order, got %v", msgs)
+	}
+}
+
+func TestFileQueue_VisibilityTimeout(t *testing.T) {
+	clock := newFileQueueClock()
+	q := openTestFileQueue(t, t.TempDir(), clock)
+	ctx := context.Background()
+	_, _ = q.Send(ctx, "retry me", nil)
+
+	first := receiveOne(t, q)
+	if msgs, _ := q.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected message hidden within the visibility timeout")
+	}
+
+	clock.Advance(31 * time.Second)
+	second := receiveOne(t, q)
+	if second.MessageID != first.MessageID || second.ReceiveCount != 2 {
+		t.Fatalf("expected redelivery with receive count 2, got %+v", second)
+	}
+
+	// The first delivery's handle is stale
+	_ = q.Ack(ctx, first)
+	if q.Len() != 1 {
+		t.Fatal("expected stale ack to be ignored")
+	}
+	_ = q.Ack(ctx, second)
+	if q.Len() != 0 {
+		t.Fatal("expected ack to remove the message")
+	}
+}
+
+func TestFileQueue_NackAndExtend(t *testing.T) {
+	clock := newFileQueueClock()
+	q := openTestFileQueue(t, t.TempDir(), clock)
+	ctx := context.Background()
+	_, _ = q.Send(ctx, "later", nil)
+
+	msg := receiveOne(t, q)
+	_ = q.Nack(ctx, msg, 5*time.Second)
+	if msgs, _ := q.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected message hidden for the nack delay")
+	}
+	clock.Advance(5 * time.Second)
+	msg = receiveOne(t, q)
+
+	_ = q.Extend(ctx, msg, time.Hour)
+	clock.Advance(time.Minute)
+	if msgs, _ := q.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected extended message to stay hidden")
+	}
+}
+
+func TestFileQueue_ReceiveCountSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFileQueueClock()
+	q := openTestFileQueue(t, dir, clock)
+	_, _ = q.Send(context.Background(), "crashy", nil)
+	receiveOne(t, q)
+	_ = q.Close()
+
+	q = openTestFileQueue(t, dir, clock)
+	if msgs, _ := q.Receive(context.Background(), 1); len(msgs) != 0 {
+		t.Fatal("expected message to stay hidden across a restart")
+	}
+	clock.Advance(31 * time.Second)
+	if msg := receiveOne(t, q); msg.ReceiveCount != 2 {
+		t.Fatalf("expected receive count 2, got %d", msg.ReceiveCount)
+	}
+}
+
+func TestFileQueue_DeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFileQueueClock()
+	dlq := openTestFileQueue(t, filepath.Join(dir, "dead-letter"), clock)
+	q := openTestFileQueue(t, dir, clock).WithDeadLetter(dlq, 2)
+	ctx := context.Background()
+	_, _ = q.Send(ctx, "poison", map[string]string{"tenant": "a"})
+
+	for i := 1; i <= 2; i++ {
+		if msg := receiveOne(t, q); msg.ReceiveCount != i {
+			t.Fatalf("expected receive count %d, got %d", i, msg.ReceiveCount)
+		}
+		clock.Advance(31 * time.Second)
+	}
+
+	if msgs, _ := q.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatalf("expected message to be dead-lettered, got %v", msgs)
+	}
+	if q.Len() != 0 || dlq.Len() != 1 {
+		t.Fatalf("expected message moved to the dead-letter queue, got %d/%d", q.Len(), dlq.Len())
+	}
+	dead := receiveOne(t, dlq)
+	if dead.Body != "poison" || dead.Attributes["tenant"] != "a" || dead.ReceiveCount != 1 {
+		t.Fatalf("unexpected dead-lettered message: %+v", dead)
+	}
+}
+
+func TestFileQueue_DeadLettersWithoutHoldingItsLock(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFileQueueClock()
+	dlq := openTestFileQueue(t, filepath.Join(dir, "dead-letter"), clock)
+	q := openTestFileQueue(t, dir, clock).WithDeadLetter(dlq, 1)
+	id, _ := q.Send(context.Background(), "poison", nil)
+	receiveOne(t, q)
+	clock.Advance(31 * time.Second)
+
+	// With the dead-letter queue busy, q must stay free for its own callers
+	dlq.mu.Lock()
+	received := make(chan error, 1)
+	go func() {
+		_, err := q.Receive(context.Background(), 1)
+		received <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if q.mu.TryLock() {
+			sending := q.msgs[id].deadLettering
+			q.mu.Unlock()
+			if sending {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			dlq.mu.Unlock()
+			t.Fatal("expected q unlocked while sending to the dead-letter queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	dlq.mu.Unlock()
+
+	if err := <-received; err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if q.Len() != 0 || dlq.Len() != 1 {
+		t.Fatalf("expected message moved to the dead-letter queue, got %d/%d", q.Len(), dlq.Len())
+	}
+}
+
+func TestFileQueue_DropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	q := openTestFileQueue(t, dir, nil)
+	_, _ = q.Send(ctx, "one", nil)
+	_, _ = q.Send(ctx, "two", nil)
+	_ = q.Close()
+
+	// A crash part way through appending a record
+	path := filepath.Join(dir, walFileName)
+	before, _ := os.Stat(path)
+	torn := appendWALRecord(nil, walRecord{Op: walEnqueue, ID: "torn", Body: "three"})
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write(torn[:len(torn)-3])
+	_ = f.Close()
+
+	q = openTestFileQueue(t, dir, nil)
+	if q.Len() != 2 {
+		t.Fatalf("expected the 2 complete messages, got %d", q.Len())
+	}
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Fatalf("expected torn record truncated, size %d -> %d", before.Size(), after.Size())
+	}
+
+	// Appends after recovery aren't hidden behind the torn record
+	_, _ = q.Send(ctx, "three", nil)
+	_ = q.Close()
+	q = openTestFileQueue(t, dir, nil)
+	if q.Len() != 3 {
+		t.Fatalf("expected 3 messages, got %d", q.Len())
+	}
+}
+
+func TestFileQueue_DropsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	q := openTestFileQueue(t, dir, nil)
+	_, _ = q.Send(context.Background(), "one", nil)
+	_ = q.Close()
+
+	path := filepath.Join(dir, walFileName)
+	data, _ := os.ReadFile(path)
+	data[len(data)-2] ^= 0xff
+	_ = os.WriteFile(path, data, 0o644)
+
+	q = openTestFileQueue(t, dir, nil)
+	if q.Len() != 0 {
+		t.Fatalf("expected corrupt record dropped, got %d messages", q.Len())
+	}
+}
+
+func TestFileQueue_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFileQueueClock()
+	q := openTestFileQueue(t, dir, clock).WithCompactThreshold(20)
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		_, _ = q.Send(ctx, "done", nil)
+		_ = q.Ack(ctx, receiveOne(t, q))
+	}
+	_, _ = q.Send(ctx, "live", nil)
+	live := receiveOne(t, q)
+
+	path := filepath.Join(dir, walFileName)
+	if info, _ := os.Stat(path); info.Size() > 4096 {
+		t.Fatalf("expected log to be compacted, size %d", info.Size())
+	}
+
+	if err := q.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = q.Close()
+
+	// Compaction keeps receive counts and visibility
+	q = openTestFileQueue(t, dir, clock)
+	if q.Len() != 1 {
+		t.Fatalf("expected 1 live message, got %d", q.Len())
+	}
+	if msgs, _ := q.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected in-flight message to stay hidden")
+	}
+	clock.Advance(31 * time.Second)
+	msg := receiveOne(t, q)
+	if msg.MessageID != live.MessageID || msg.ReceiveCount != 2 {
+		t.Fatalf("unexpected message after compaction: %+v", msg)
+	}
+}
+
+func TestFileQueue_FailedCompactionKeepsLog(t *testing.T) {
+	dir := t.TempDir()
+	q := openTestFileQueue(t, dir, nil)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, _ = q.Send(ctx, fmt.Sprintf("msg-%d", i), nil)
+	}
+
+	compactWrite = func(f *os.File, b []byte) (int, error) {
+		n, _ := f.Write(b[:len(b)/2])
+		return n, errors.New("disk full")
+	}
+	defer func() { compactWrite = (*os.File).Write }()
+
+	if err := q.Compact(); err == nil {
+		t.Fatal("expected compaction to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, walFileName+".compact")); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial log removed, got %v", err)
+	}
+	// The queue keeps appending to the original log
+	if _, err := q.Send(ctx, "after", nil); err != nil {
+		t.Fatalf("send after failed compaction: %v", err)
+	}
+	_ = q.Close()
+
+	q = openTestFileQueue(t, dir, nil)
+	if q.Len() != 4 {
+		t.Fatalf("expected 4 messages after reopen, got %d", q.Len())
+	}
+}
+
+func TestFileQueue_ReceiveWakesOnSend(t *testing.T) {
+	q := openTestFileQueue(t, t.TempDir(), nil).WithWaitTime(5 * time.Second)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = q.Send(context.Background(), "wake", nil)
+	}()
+
+	start := time.Now()
+	msgs, err := q.Receive(context.Background(), 1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("expected a message, got %d (err %v)", len(msgs), err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expected receive to wake up on send")
+	}
+}
+
+func TestRunner_FileQueue(t *testing.T) {
+	const total = 20
+	q := openTestFileQueue(t, t.TempDir(), nil)
+	for i := 0; i < total; i++ {
+		_, _ = q.Send(context.Background(), fmt.Sprint(i), nil)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	done := make(chan struct{})
+	handler := func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[msg.Body] = true
+		if len(seen) == total {
+			close(done)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewRunner(q, handler, 5, 3).WithReceiveBatch(5).Run(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for messages")
+	}
+	deadline := time.Now().Add(time.Second)
+	for q.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-errCh
+
+	if q.Len() != 0 {
+		t.Fatalf("expected every message acked, %d left", q.Len())
+	}
+}
+
+type fakeSQSSender struct {
+	mu       sync.Mutex
+	failures int
+	reject   map[string]bool
+	bodies   []string
+	// refuse, if set, can fail a whole request
+	refuse   func(*sqs.SendMessageBatchInput) error
+	payloads []int
+}
+
+func (f *fakeSQSSender) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("network unreachable")
+	}
+	if f.refuse != nil {
+		if err := f.refuse(params); err != nil {
+			return nil, err
+		}
+	}
+	payload := 0
+	for _, entry := range params.Entries {
+		payload += len(aws.ToString(entry.MessageBody))
+	}
+	f.payloads = append(f.payloads, payload)
+
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		body := aws.ToString(entry.MessageBody)
+		if f.reject[body] {
+			delete(f.reject, body)
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError")})
+			continue
+		}
+		f.bodies = append(f.bodies, body)
+		out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{Id: entry.Id})
+	}
+	return out, nil
+}
+
+func (f *fakeSQSSender) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.bodies...)
+}
+
+func TestForwarder_DrainsQueueAfterOutage(t *testing.T) {
+	dir := t.TempDir()
+	dlq := openTestFileQueue(t, filepath.Join(dir, "dead-letter"), nil)
+	q := openTestFileQueue(t, dir, nil).WithDeadLetter(dlq, 1)
+	for i := 0; i < 15; i++ {
+		_, _ = q.Send(context.Background(), fmt.Sprint(i), nil)
+	}
+
+	sender := &fakeSQSSender{failures: 3, reject: map[string]bool{"12": true}}
+	fwd := NewForwarder(q, sender, "http://example.com/queue").WithBackoff(time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fwd.Run(ctx)
+	}()
+
+	for (len(sender.sent()) < 14 || dlq.Len() < 1) && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-errCh
+
+	// The outage didn't count against the dead-letter limit...
+	if got := len(sender.sent()); got != 14 {
+		t.Fatalf("expected 14 messages forwarded, got %d", got)
+	}
+	// ...but the rejected message was received twice
+	if q.Len() != 0 || dlq.Len() != 1 {
+		t.Fatalf("expected rejected message dead-lettered, got %d/%d", q.Len(), dlq.Len())
+	}
+}
+
+func TestForwarder_SplitsBatchesByPayload(t *testing.T) {
+	dir := t.TempDir()
+	dlq := openTestFileQueue(t, filepath.Join(dir, "dead-letter"), nil)
+	q := openTestFileQueue(t, dir, nil).WithDeadLetter(dlq, 1)
+	ctx := context.Background()
+	big := strings.Repeat("x", 100*1024)
+	for i := 0; i < 5; i++ {
+		_, _ = q.Send(ctx, big, nil)
+	}
+	// Over the limit on its own
+	_, _ = q.Send(ctx, strings.Repeat("y", maxBatchPayload+1), nil)
+
+	sender := &fakeSQSSender{refuse: func(in *sqs.SendMessageBatchInput) error {
+		size := 0
+		for _, entry := range in.Entries {
+			size += len(aws.ToString(entry.MessageBody))
+		}
+		if size > maxBatchPayload {
+			return &types.BatchRequestTooLong{Message: aws.String("too long")}
+		}
+		return nil
+	}}
+	fwd := NewForwarder(q, sender, "http://example.com/queue").WithBackoff(time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fwd.Run(ctx)
+	}()
+	for (len(sender.sent()) < 5 || dlq.Len() < 1) && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-errCh
+
+	if got := len(sender.sent()); got != 5 {
+		t.Fatalf("expected 5 messages forwarded, got %d", got)
+	}
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	for _, n := range sender.payloads {
+		if n > maxBatchPayload {
+			t.Fatalf("sent a %d byte batch", n)
+		}
+	}
+	// The oversized message was refused rather than stalling the rest
+	if q.Len() != 0 || dlq.Len() != 1 {
+		t.Fatalf("expected the oversized message dead-lettered, got %d/%d", q.Len(), dlq.Len())
+	}
+}
+
+func TestForwarder_StopsOnMissingQueue(t *testing.T) {
+	q := openTestFileQueue(t, t.TempDir(), nil)
+	_, _ = q.Send(context.Background(), "a", nil)
+
+	sender := &fakeSQSSender{refuse: func(*sqs.SendMessageBatchInput) error {
+		return &types.QueueDoesNotExist{Message: aws.String("no such queue")}
+	}}
+	fwd := NewForwarder(q, sender, "http://example.com/queue").WithBackoff(time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var notExist *types.QueueDoesNotExist
+	if err := fwd.Run(ctx); !errors.As(err, &notExist) {
+		t.Fatalf("expected QueueDoesNotExist, got %v", err)
+	}
+	// Released for when the queue exists again
+	if msgs, _ := q.Receive(context.Background(), 1); len(msgs) != 1 {
+		t.Fatal("expected the message released")
+	}
+}
@@ -0,0 +1,583 @@
+package worker
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HTTPSource is a Source fed by HTTP POSTs, for producers that can only
+// push, such as SNS HTTP(S) subscriptions or webhooks. Each request is held
+// open until its message is settled and is answered with the outcome:
+//
+//   - 200 once the message is acked, including permanent failures the
+//     Runner drops
+//   - 500 when the handler fails with a retryable error
+//   - 503 with Retry-After when the message is nacked, the response timeout
+//     runs out or the source is closed
+//   - 429 with Retry-After when maxInFlight requests are already open
+//
+// so the sender's own retries take the place of redelivery. Serve it with
+// any http.Server; it implements http.Handler.
+//
+// Requests carrying an x-amz-sns-message-type header are treated as SNS:
+// notifications are unwrapped to their Message and MessageAttributes, and
+// subscription confirmations are answered by visiting the SubscribeURL.
+// Their signatures are checked against the SNS signing certificate unless
+// verification is turned off.
+type HTTPSource struct {
+	slots           chan struct{}
+	ch              chan *pushedMessage
+	responseTimeout time.Duration
+	waitTime        time.Duration
+	maxBodyBytes    int64
+	idHeader        string
+	attrHeaders     []string
+	verifySNS       bool
+	topicARNs       map[string]bool
+	httpClient      *http.Client
+
+	mu      sync.Mutex
+	pending map[string]*pushedMessage
+	closed  chan struct{}
+	once    sync.Once
+
+	certMu sync.Mutex
+	certs  map[string]*x509.Certificate
+}
+
+// pushedMessage is a message waiting for its request to be answered.
+type pushedMessage struct {
+	msg    *Message
+	result chan pushResult
+	// answered is set once the request has been answered; guarded by mu
+	answered bool
+}
+
+type pushResult struct {
+	status     int
+	retryAfter time.Duration
+}
+
+// NewHTTPSource creates a source that holds up to maxInFlight requests
+// open at once and turns further ones away with 429.
+func NewHTTPSource(maxInFlight int) *HTTPSource {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	return &HTTPSource{
+		slots:           make(chan struct{}, maxInFlight),
+		ch:              make(chan *pushedMessage, maxInFlight),
+		responseTimeout: 15 * time.Second,
+		waitTime:        time.Second,
+		maxBodyBytes:    256 * 1024,
+		verifySNS:       true,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		pending:         make(map[string]*pushedMessage),
+		closed:          make(chan struct{}),
+		certs:           make(map[string]*x509.Certificate),
+	}
+}
+
+// WithResponseTimeout sets how long a request waits for its message to be
+// settled before it is answered with 503. Keep it below the sender's own
+// timeout (15s for SNS). Defaults to 15s.
+func (s *HTTPSource) WithResponseTimeout(d time.Duration) *HTTPSource {
+	s.responseTimeout = d
+	return s
+}
+
+// WithWaitTime sets how long Receive waits for a request. Defaults to 1s.
+func (s *HTTPSource) WithWaitTime(d time.Duration) *HTTPSource {
+	s.waitTime = d
+	return s
+}
+
+// WithMaxBodyBytes caps request bodies; larger ones get 413. Defaults to
+// 256KiB, the SQS message limit.
+func (s *HTTPSource) WithMaxBodyBytes(n int64) *HTTPSource {
+	s.maxBodyBytes = n
+	return s
+}
+
+// WithIDHeader takes non-SNS message IDs from the named header, e.g. a
+// webhook's delivery ID, so retried deliveries can be deduplicated.
+// Without it, or when the header is missing, each request gets a new ID.
+func (s *HTTPSource) WithIDHeader(name string) *HTTPSource {
+	s.idHeader = name
+	return s
+}
+
+// WithAttributeHeaders copies the named request headers into non-SNS
+// messages' attributes, keyed by header name as given.
+func (s *HTTPSource) WithAttributeHeaders(names ...string) *HTTPSource {
+	s.attrHeaders = names
+	return s
+}
+
+// WithSNSVerification turns SNS signature checks on or off. On by default;
+// only turn it off behind something else that authenticates SNS.
+func (s *HTTPSource) WithSNSVerification(verify bool) *HTTPSource {
+	s.verifySNS = verify
+	return s
+}
+
+// WithTopicARNs only accepts SNS messages from the given topics. By
+// default any topic is accepted.
+func (s *HTTPSource) WithTopicARNs(arns ...string) *HTTPSource {
+	s.topicARNs = make(map[string]bool, len(arns))
+	for _, arn := range arns {
+		s.topicARNs[arn] = true
+	}
+	return s
+}
+
+// WithHTTPClient replaces the client used to confirm SNS subscriptions and
+// fetch signing certificates.
+func (s *HTTPSource) WithHTTPClient(client *http.Client) *HTTPSource {
+	s.httpClient = client
+	return s
+}
+
+// Close answers open and future requests with 503, so senders retry
+// against another instance. Call it once the Runner has stopped.
+func (s *HTTPSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *HTTPSource) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case <-s.closed:
+		unavailable(w, time.Second)
+		return
+	default:
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, s.maxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "read body", http.StatusBadRequest)
+		return
+	}
+
+	var msg *Message
+	if snsType := req.Header.Get("x-amz-sns-message-type"); snsType != "" {
+		msg, err = s.snsMessage(req.Context(), snsType, body)
+		if err != nil {
+			fmt.Printf("http source: rejected SNS %s: %v\n", snsType, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if msg == nil {
+			// A handshake, not a message
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	} else {
+		msg = s.webhookMessage(req, body)
+	}
+
+	p := &pushedMessage{msg: msg, result: make(chan pushResult, 1)}
+	// Holding a slot guarantees room in the channel
+	s.ch <- p
+
+	timer := time.NewTimer(s.responseTimeout)
+	defer timer.Stop()
+	var result pushResult
+	select {
+	case result = <-p.result:
+	case <-timer.C:
+		result = pushResult{status: http.StatusServiceUnavailable}
+	case <-s.closed:
+		result = pushResult{status: http.StatusServiceUnavailable, retryAfter: time.Second}
+	case <-req.Context().Done():
+		// The sender gave up; a late ack is a no-op
+	}
+	s.abandon(p)
+	// An outcome that raced the timeout still wins
+	select {
+	case result = <-p.result:
+	default:
+	}
+	if req.Context().Err() != nil {
+		return
+	}
+
+	switch result.status {
+	case http.StatusOK:
+		w.WriteHeader(http.StatusOK)
+	case http.StatusServiceUnavailable:
+		unavailable(w, result.retryAfter)
+	default:
+		http.Error(w, http.StatusText(result.status), result.status)
+	}
+}
+
+func unavailable(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		secs := int((retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+}
+
+func (s *HTTPSource) webhookMessage(req *http.Request, body []byte) *Message {
+	msg := &Message{Body: string(body)}
+	if s.idHeader != "" {
+		msg.MessageID = req.Header.Get(s.idHeader)
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.New().String()
+	}
+	for _, name := range s.attrHeaders {
+		if value := req.Header.Get(name); value != "" {
+			if msg.Attributes == nil {
+				msg.Attributes = make(map[string]string)
+			}
+			msg.Attributes[name] = value
+		}
+	}
+	return msg
+}
+
+// abandon stops p from being delivered or settled once its request has
+// been answered.
+func (s *HTTPSource) abandon(p *pushedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.answered = true
+	if p.msg.ReceiptHandle != nil {
+		delete(s.pending, *p.msg.ReceiptHandle)
+	}
+}
+
+func (s *HTTPSource) Receive(ctx context.Context, max int) ([]*Message, error) {
+	timer := time.NewTimer(s.waitTime)
+	defer timer.Stop()
+
+	var msgs []*Message
+	for len(msgs) == 0 {
+		select {
+		case p := <-s.ch:
+			if msg := s.deliver(p); msg != nil {
+				msgs = append(msgs, msg)
+			}
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for len(msgs) < max {
+		select {
+		case p := <-s.ch:
+			if msg := s.deliver(p); msg != nil {
+				msgs = append(msgs, msg)
+			}
+		default:
+			return msgs, nil
+		}
+	}
+	return msgs, nil
+}
+
+// deliver registers p under a new receipt handle. It returns nil if p's
+// request has already been answered, since the sender will retry it.
+func (s *HTTPSource) deliver(p *pushedMessage) *Message {
+	handle := uuid.New().String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.answered {
+		return nil
+	}
+	p.msg.ReceiveCount = 1
+	p.msg.ReceiptHandle = &handle
+	s.pending[handle] = p
+	delivered := *p.msg
+	return &delivered
+}
+
+// settle answers msg's request, if it is still open.
+func (s *HTTPSource) settle(msg *Message, result pushResult) error {
+	if msg.ReceiptHandle == nil {
+		return errors.New("missing receipt handle")
+	}
+	s.mu.Lock()
+	p, ok := s.pending[*msg.ReceiptHandle]
+	delete(s.pending, *msg.ReceiptHandle)
+	s.mu.Unlock()
+	if ok {
+		p.result <- result
+	}
+	return nil
+}
+
+// Ack answers the request with 200.
+func (s *HTTPSource) Ack(ctx context.Context, msg *Message) error {
+	return s.settle(msg, pushResult{status: http.StatusOK})
+}
+
+// Nack answers the request with 503, asking the sender to retry after
+// delay.
+func (s *HTTPSource) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return s.settle(msg, pushResult{status: http.StatusServiceUnavailable, retryAfter: delay})
+}
+
+// Failed answers the request with 500.
+func (s *HTTPSource) Failed(ctx context.Context, msg *Message, err error) error {
+	return s.settle(msg, pushResult{status: http.StatusInternalServerError})
+}
+
+// Extend is a no-op: the request stays open only until the response
+// timeout.
+func (s *HTTPSource) Extend(ctx context.Context, msg *Message, d time.Duration) error {
+	return nil
+}
+
+func (s *HTTPSource) LongPolling() bool {
+	return true
+}
+
+// snsEnvelope is the JSON body SNS posts to HTTP(S) endpoints.
+type snsEnvelope struct {
+	Type              string
+	MessageId         string
+	Token             string
+	TopicArn          string
+	Subject           string
+	Message           string
+	Timestamp         string
+	SubscribeURL      string
+	SignatureVersion  string
+	Signature         string
+	SigningCertURL    string
+	MessageAttributes map[string]struct {
+		Type  string
+		Value string
+	}
+}
+
+// snsHost matches the hosts SNS serves subscription and certificate URLs
+// from.
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsMessage handles an SNS request. It returns nil for handshakes.
+func (s *HTTPSource) snsMessage(ctx context.Context, snsType string, body []byte) (*Message, error) {
+	var env snsEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("decode envelope: %w", err)
+	}
+	if env.Type != snsType {
+		return nil, fmt.Errorf("type %q doesn't match header", env.Type)
+	}
+	if s.topicARNs != nil && !s.topicARNs[env.TopicArn] {
+		return nil, fmt.Errorf("topic %s not allowed", env.TopicArn)
+	}
+	if s.verifySNS {
+		if err := s.verifySignature(ctx, &env); err != nil {
+			return nil, err
+		}
+	}
+
+	switch env.Type {
+	case "Notification":
+		msg := &Message{MessageID: env.MessageId, Body: env.Message}
+		for name, attr := range env.MessageAttributes {
+			if attr.Type == "Binary" {
+				continue
+			}
+			if msg.Attributes == nil {
+				msg.Attributes = make(map[string]string)
+			}
+			msg.Attributes[name] = attr.Value
+		}
+		return msg, nil
+	case "SubscriptionConfirmation":
+		if err := s.confirmSubscription(ctx, env.SubscribeURL); err != nil {
+			return nil, err
+		}
+		fmt.Printf("http source: confirmed subscription to %s\n", env.TopicArn)
+		return nil, nil
+	case "UnsubscribeConfirmation":
+		fmt.Printf("http source: unsubscribed from %s\n", env.TopicArn)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown message type %q", env.Type)
+}
+
+func (s *HTTPSource) confirmSubscription(ctx context.Context, subscribeURL string) error {
+	if err := checkSNSURL(subscribeURL); err != nil {
+		return fmt.Errorf("subscribe URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("confirm subscription: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirm subscription: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkSNSURL refuses URLs SNS wouldn't have sent, so a forged request
+// can't make us fetch arbitrary URLs.
+func checkSNSURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !snsHost.MatchString(u.Hostname()) {
+		return fmt.Errorf("%s is not an SNS URL", raw)
+	}
+	return nil
+}
+
+// verifySignature checks env against the certificate it names, as
+// described in the SNS "Verifying message signatures" guide.
+func (s *HTTPSource) verifySignature(ctx context.Context, env *snsEnvelope) error {
+	var hash crypto.Hash
+	switch env.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version %q", env.SignatureVersion)
+	}
+	sig, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+	cert, err := s.signingCert(ctx, env.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing cert has no RSA key")
+	}
+	signed := []byte(snsStringToSign(env))
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum(signed)
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+		return fmt.Errorf("bad signature: %w", err)
+	}
+	return nil
+}
+
+// snsStringToSign lists the signed fields in the order SNS signs them.
+func snsStringToSign(env *snsEnvelope) string {
+	var b strings.Builder
+	add := func(name, value string) {
+		b.WriteString(name + "\n" + value + "\n")
+	}
+	add("Message", env.Message)
+	add("MessageId", env.MessageId)
+	if env.Type == "Notification" {
+		if env.Subject != "" {
+			add("Subject", env.Subject)
+		}
+	} else {
+		add("SubscribeURL", env.SubscribeURL)
+	}
+	add("Timestamp", env.Timestamp)
+	if env.Type != "Notification" {
+		add("Token", env.Token)
+	}
+	add("TopicArn", env.TopicArn)
+	add("Type", env.Type)
+	return b.String()
+}
+
+// signingCert fetches and caches the certificate at certURL.
+func (s *HTTPSource) signingCert(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if err := checkSNSURL(certURL); err != nil {
+		return nil, fmt.Errorf("signing cert URL: %w", err)
+	}
+	s.certMu.Lock()
+	cert, ok := s.certs[certURL]
+	s.certMu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch signing cert: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch signing cert: status %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("fetch signing cert: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("signing cert is not PEM")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing cert: %w", err)
+	}
+
+	s.certMu.Lock()
+	s.certs[certURL] = cert
+	s.certMu.Unlock()
+	return cert, nil
+}
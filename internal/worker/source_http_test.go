@@ -0,0 +1,323 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// postAsync serves a POST of body with h and delivers the recorded response
+// on the returned channel.
+func postAsync(h http.Handler, body string, header http.Header) <-chan *httptest.ResponseRecorder {
+	out := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		out <- rec
+	}()
+	return out
+}
+
+func receivePushed(t *testing.T, s *HTTPSource) *Message {
+	t.Helper()
+	msgs, err := s.Receive(context.Background(), 1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d (err %v)", len(msgs), err)
+	}
+	return msgs[0]
+}
+
+func TestHTTPSource_AnswersWithOutcome(t *testing.T) {
+	s := NewHTTPSource(10)
+	ctx := context.Background()
+
+	cases := []struct {
+		name   string
+		settle func(msg *Message) error
+		status int
+		retry  string
+	}{
+		{"ack", func(msg *Message) error { return s.Ack(ctx, msg) }, http.StatusOK, ""},
+		{"failed", func(msg *Message) error { return s.Failed(ctx, msg, errors.New("boom")) }, http.StatusInternalServerError, ""},
+		{"nack", func(msg *Message) error { return s.Nack(ctx, msg, 1500*time.Millisecond) }, http.StatusServiceUnavailable, "2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := postAsync(s, "payload", http.Header{"X-Tenant": {"a"}})
+			msg := receivePushed(t, s)
+			if msg.Body != "payload" || msg.ReceiveCount != 1 || msg.MessageID == "" {
+				t.Fatalf("unexpected message: %+v", msg)
+			}
+			if err := tc.settle(msg); err != nil {
+				t.Fatalf("settle: %v", err)
+			}
+			rec := <-resp
+			if rec.Code != tc.status || rec.Header().Get("Retry-After") != tc.retry {
+				t.Fatalf("expected %d (Retry-After %q), got %d (%q)", tc.status, tc.retry, rec.Code, rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestHTTPSource_Headers(t *testing.T) {
+	s := NewHTTPSource(1).WithIDHeader("X-Delivery-Id").WithAttributeHeaders("X-Tenant")
+
+	resp := postAsync(s, "payload", http.Header{"X-Delivery-Id": {"d-1"}, "X-Tenant": {"a"}})
+	msg := receivePushed(t, s)
+	if msg.MessageID != "d-1" || msg.Attributes["X-Tenant"] != "a" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	_ = s.Ack(context.Background(), msg)
+	<-resp
+}
+
+func TestHTTPSource_Backpressure(t *testing.T) {
+	s := NewHTTPSource(1).WithResponseTimeout(time.Minute)
+
+	first := postAsync(s, "one", nil)
+	msg := receivePushed(t, s)
+
+	rec := <-postAsync(s, "two", nil)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", rec.Code)
+	}
+
+	_ = s.Ack(context.Background(), msg)
+	<-first
+	third := postAsync(s, "three", nil)
+	_ = s.Ack(context.Background(), receivePushed(t, s))
+	if rec := <-third; rec.Code != http.StatusOK {
+		t.Fatalf("expected a freed slot to accept requests, got %d", rec.Code)
+	}
+}
+
+func TestHTTPSource_ResponseTimeout(t *testing.T) {
+	s := NewHTTPSource(1).WithResponseTimeout(20 * time.Millisecond).WithWaitTime(20 * time.Millisecond)
+
+	if rec := <-postAsync(s, "slow", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	// The sender retries it, so it must not be handled as well
+	if msgs, _ := s.Receive(context.Background(), 1); len(msgs) != 0 {
+		t.Fatal("expected answered request not to be delivered")
+	}
+}
+
+func TestHTTPSource_Close(t *testing.T) {
+	s := NewHTTPSource(1).WithResponseTimeout(time.Minute)
+
+	open := postAsync(s, "open", nil)
+	receivePushed(t, s)
+	_ = s.Close()
+	if rec := <-open; rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected open request answered with 503, got %d", rec.Code)
+	}
+	if rec := <-postAsync(s, "late", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after close, got %d", rec.Code)
+	}
+}
+
+func TestHTTPSource_RejectsLargeBodiesAndOtherMethods(t *testing.T) {
+	s := NewHTTPSource(1).WithMaxBodyBytes(4)
+
+	if rec := <-postAsync(s, "too large", nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
+
+// fakeSNS signs envelopes like SNS and serves its certificate and
+// subscribe URLs to the source's HTTP client.
+type fakeSNS struct {
+	t       *testing.T
+	key     *rsa.PrivateKey
+	certPEM []byte
+
+	mu        sync.Mutex
+	confirmed []string
+}
+
+const fakeSNSCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+
+func newFakeSNS(t *testing.T) *fakeSNS {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeSNS{t: t, key: key, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (f *fakeSNS) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte("ok")
+	if req.URL.String() == fakeSNSCertURL {
+		body = f.certPEM
+	} else {
+		f.mu.Lock()
+		f.confirmed = append(f.confirmed, req.URL.String())
+		f.mu.Unlock()
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body)), Request: req}, nil
+}
+
+func (f *fakeSNS) envelope(env snsEnvelope) (string, http.Header) {
+	env.SignatureVersion = "2"
+	env.SigningCertURL = fakeSNSCertURL
+	env.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	digest := sha256.Sum256([]byte(snsStringToSign(&env)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	env.Signature = base64.StdEncoding.EncodeToString(sig)
+	body, err := json.Marshal(env)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return string(body), http.Header{"X-Amz-Sns-Message-Type": {env.Type}}
+}
+
+func TestHTTPSource_SNSNotification(t *testing.T) {
+	sns := newFakeSNS(t)
+	s := NewHTTPSource(1).WithHTTPClient(&http.Client{Transport: sns})
+
+	env := snsEnvelope{Type: "Notification", MessageId: "sns-1", TopicArn: "arn:aws:sns:us-east-1:123:orders", Message: "hello"}
+	env.MessageAttributes = map[string]struct {
+		Type  string
+		Value string
+	}{"tenant": {Type: "String", Value: "a"}}
+	body, header := sns.envelope(env)
+
+	resp := postAsync(s, body, header)
+	msg := receivePushed(t, s)
+	if msg.MessageID != "sns-1" || msg.Body != "hello" || msg.Attributes["tenant"] != "a" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	_ = s.Ack(context.Background(), msg)
+	if rec := <-resp; rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	// Tampering breaks the signature
+	forged := strings.Replace(body, "hello", "evil", 1)
+	if rec := <-postAsync(s, forged, header); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected forged notification rejected, got %d", rec.Code)
+	}
+}
+
+func TestHTTPSource_SNSTopicAllowList(t *testing.T) {
+	sns := newFakeSNS(t)
+	s := NewHTTPSource(1).WithHTTPClient(&http.Client{Transport: sns}).WithTopicARNs("arn:aws:sns:us-east-1:123:orders")
+
+	body, header := sns.envelope(snsEnvelope{Type: "Notification", MessageId: "sns-1", TopicArn: "arn:aws:sns:us-east-1:123:other", Message: "hello"})
+	if rec := <-postAsync(s, body, header); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown topic rejected, got %d", rec.Code)
+	}
+}
+
+func TestHTTPSource_SNSSubscriptionConfirmation(t *testing.T) {
+	sns := newFakeSNS(t)
+	s := NewHTTPSource(1).WithHTTPClient(&http.Client{Transport: sns})
+
+	subscribeURL := "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=t"
+	body, header := sns.envelope(snsEnvelope{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "sub-1",
+		Token:        "t",
+		TopicArn:     "arn:aws:sns:us-east-1:123:orders",
+		Message:      "You have chosen to subscribe",
+		SubscribeURL: subscribeURL,
+	})
+	if rec := <-postAsync(s, body, header); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(sns.confirmed) != 1 || sns.confirmed[0] != subscribeURL {
+		t.Fatalf("expected subscription confirmed, visited %v", sns.confirmed)
+	}
+	if msgs, _ := s.WithWaitTime(10*time.Millisecond).Receive(context.Background(), 1); len(msgs) != 0 {
+		t.Fatal("expected the handshake not to be delivered")
+	}
+
+	// Only SNS URLs are visited
+	body, header = sns.envelope(snsEnvelope{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "sub-2",
+		TopicArn:     "arn:aws:sns:us-east-1:123:orders",
+		SubscribeURL: "http://169.254.169.254/latest/meta-data/",
+	})
+	if rec := <-postAsync(s, body, header); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected non-SNS subscribe URL rejected, got %d", rec.Code)
+	}
+	if len(sns.confirmed) != 1 {
+		t.Fatalf("expected no further visits, got %v", sns.confirmed)
+	}
+}
+
+func TestRunner_HTTPSource(t *testing.T) {
+	source := NewHTTPSource(5).WithWaitTime(10 * time.Millisecond)
+	handler := func(ctx context.Context, msg *Message) error {
+		switch msg.Body {
+		case "fail":
+			return errors.New("transient")
+		case "poison":
+			return Permanent(errors.New("bad payload"))
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runner := NewRunner(source, handler, 5, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+
+	want := map[string]int{
+		"ok":     http.StatusOK,
+		"poison": http.StatusOK,
+		"fail":   http.StatusInternalServerError,
+	}
+	for body, status := range want {
+		if rec := <-postAsync(source, body, nil); rec.Code != status {
+			t.Errorf("%s: expected %d, got %d", body, status, rec.Code)
+		}
+	}
+	cancel()
+	<-done
+}
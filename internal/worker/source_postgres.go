@@ -0,0 +1,275 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultJobTable = "worker_jobs"
+
+// PostgresAckMode is what PostgresSource does with an acknowledged row.
+type PostgresAckMode int
+
+const (
+	// AckDelete deletes the row.
+	AckDelete PostgresAckMode = iota
+	// AckMarkDone keeps the row with done_at set, as an audit trail. Use
+	// DeleteDone to prune it.
+	AckMarkDone
+)
+
+func (m PostgresAckMode) String() string {
+	switch m {
+	case AckDelete:
+		return "delete"
+	case AckMarkDone:
+		return "mark-done"
+	}
+	return "unknown"
+}
+
+// PostgresSource is a job queue in a Postgres table, for work that must be
+// enqueued in the same transaction as the business data it belongs to (see
+// EnqueueTx). Receive claims rows with SELECT ... FOR UPDATE SKIP LOCKED,
+// so concurrent workers never claim the same row, and hides them by moving
+// invisible_until forward by the visibility timeout; a row that isn't acked
+// in time is claimed again, like an SQS message. Call Migrate once to create
+// the table.
+//
+// Receive doesn't wait for rows to arrive, so pair it with the Runner's
+// WithIdleBackoff.
+type PostgresSource struct {
+	db         *sql.DB
+	table      string
+	visibility time.Duration
+	ackMode    PostgresAckMode
+}
+
+func NewPostgresSource(db *sql.DB) *PostgresSource {
+	return &PostgresSource{
+		db:         db,
+		table:      defaultJobTable,
+		visibility: 30 * time.Second,
+	}
+}
+
+// WithTable replaces the "worker_jobs" table name. It is interpolated into
+// queries and must be trusted.
+func (s *PostgresSource) WithTable(table string) *PostgresSource {
+	s.table = table
+	return s
+}
+
+// WithVisibilityTimeout sets how long a claimed row stays hidden before it
+// can be claimed again. Defaults to 30s.
+func (s *PostgresSource) WithVisibilityTimeout(d time.Duration) *PostgresSource {
+	s.visibility = d
+	return s
+}
+
+// WithAckMode selects whether acked rows are deleted (the default) or
+// marked done.
+func (s *PostgresSource) WithAckMode(mode PostgresAckMode) *PostgresSource {
+	s.ackMode = mode
+	return s
+}
+
+// Migrate creates the job table and its index if they don't exist.
+func (s *PostgresSource) Migrate(ctx context.Context) error {
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id              BIGSERIAL PRIMARY KEY,
+	body            TEXT NOT NULL,
+	attributes      JSONB NOT NULL DEFAULT '{}',
+	enqueued_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+	invisible_until TIMESTAMPTZ NOT NULL DEFAULT now(),
+	receive_count   INTEGER NOT NULL DEFAULT 0,
+	done_at         TIMESTAMPTZ
+)`, s.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_ready ON %[1]s (invisible_until, id) WHERE done_at IS NULL`, s.table),
+	}
+	for _, stmt := range stmts {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate job table: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// EnqueueTx inserts a job within tx, so it only becomes visible if tx
+// commits. The job is held back for delay. It returns the job's ID.
+func (s *PostgresSource) EnqueueTx(ctx context.Context, tx *sql.Tx, body string, attrs map[string]string, delay time.Duration) (string, error) {
+	return s.enqueue(ctx, tx, body, attrs, delay)
+}
+
+// Send enqueues a job outside any transaction and returns its ID.
+func (s *PostgresSource) Send(ctx context.Context, body string, attrs map[string]string) (string, error) {
+	return s.enqueue(ctx, s.db, body, attrs, 0)
+}
+
+func (s *PostgresSource) enqueue(ctx context.Context, q sqlQueryer, body string, attrs map[string]string, delay time.Duration) (string, error) {
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	encoded, err := json.Marshal(attrs)
+	if err != nil {
+		return "", err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (body, attributes, invisible_until)
+VALUES ($1, $2, clock_timestamp() + $3 * interval '1 millisecond')
+RETURNING id`, s.table)
+
+	var id int64
+	if err := q.QueryRowContext(ctx, query, body, string(encoded), delay.Milliseconds()).Scan(&id); err != nil {
+		return "", fmt.Errorf("enqueue: %w", err)
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// Receive claims up to max visible rows, oldest first, bumping their
+// receive counts.
+func (s *PostgresSource) Receive(ctx context.Context, max int) ([]*Message, error) {
+	if max < 1 {
+		max = 1
+	}
+	query := fmt.Sprintf(`WITH ready AS (
+	SELECT id FROM %[1]s
+	WHERE done_at IS NULL AND invisible_until <= clock_timestamp()
+	ORDER BY invisible_until, id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+UPDATE %[1]s AS j
+SET invisible_until = clock_timestamp() + $2 * interval '1 millisecond',
+	receive_count = j.receive_count + 1
+FROM ready
+WHERE j.id = ready.id
+RETURNING j.id, j.body, j.attributes, j.receive_count`, s.table)
+
+	rows, err := s.db.QueryContext(ctx, query, max, s.visibility.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("receive: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []*Message
+	for rows.Next() {
+		var (
+			id           int64
+			body         string
+			rawAttrs     []byte
+			receiveCount int
+		)
+		if err := rows.Scan(&id, &body, &rawAttrs, &receiveCount); err != nil {
+			return nil, fmt.Errorf("receive: %w", err)
+		}
+		var attrs map[string]string
+		if err := json.Unmarshal(rawAttrs, &attrs); err != nil {
+			return nil, fmt.Errorf("receive: job %d attributes: %w", id, err)
+		}
+		if len(attrs) == 0 {
+			attrs = nil
+		}
+		messageID := strconv.FormatInt(id, 10)
+		handle := postgresReceipt(messageID, receiveCount)
+		msgs = append(msgs, &Message{
+			MessageID:     messageID,
+			Body:          body,
+			ReceiptHandle: &handle,
+			Attributes:    attrs,
+			ReceiveCount:  receiveCount,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("receive: %w", err)
+	}
+	return msgs, nil
+}
+
+// postgresReceipt identifies one delivery of a row: its receive count
+// changes with every claim, so a handle from an earlier claim no longer
+// matches.
+func postgresReceipt(id string, receiveCount int) string {
+	return id + ":" + strconv.Itoa(receiveCount)
+}
+
+func parsePostgresReceipt(msg *Message) (id, receiveCount int64, err error) {
+	if msg.ReceiptHandle == nil {
+		return 0, 0, errors.New("missing receipt handle")
+	}
+	idPart, countPart, ok := strings.Cut(*msg.ReceiptHandle, ":")
+	if ok {
+		id, err = strconv.ParseInt(idPart, 10, 64)
+	}
+	if ok && err == nil {
+		receiveCount, err = strconv.ParseInt(countPart, 10, 64)
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid receipt handle %q", *msg.ReceiptHandle)
+	}
+	return id, receiveCount, nil
+}
+
+// Ack deletes the row or marks it done, depending on the ack mode. It is a
+// no-op if the row has since been claimed again.
+func (s *PostgresSource) Ack(ctx context.Context, msg *Message) error {
+	id, receiveCount, err := parsePostgresReceipt(msg)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1 AND receive_count = $2`, s.table)
+	if s.ackMode == AckMarkDone {
+		query = fmt.Sprintf(`UPDATE %s SET done_at = clock_timestamp()
+WHERE id = $1 AND receive_count = $2 AND done_at IS NULL`, s.table)
+	}
+	if _, err := s.db.ExecContext(ctx, query, id, receiveCount); err != nil {
+		return fmt.Errorf("ack: %w", err)
+	}
+	return nil
+}
+
+// Nack makes the row claimable again after delay.
+func (s *PostgresSource) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return s.setInvisible(ctx, msg, delay)
+}
+
+// Extend keeps the row hidden for another d.
+func (s *PostgresSource) Extend(ctx context.Context, msg *Message, d time.Duration) error {
+	return s.setInvisible(ctx, msg, d)
+}
+
+func (s *PostgresSource) setInvisible(ctx context.Context, msg *Message, d time.Duration) error {
+	id, receiveCount, err := parsePostgresReceipt(msg)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE %s SET invisible_until = clock_timestamp() + $3 * interval '1 millisecond'
+WHERE id = $1 AND receive_count = $2 AND done_at IS NULL`, s.table)
+	if _, err := s.db.ExecContext(ctx, query, id, receiveCount, d.Milliseconds()); err != nil {
+		return fmt.Errorf("change visibility: %w", err)
+	}
+	return nil
+}
+
+// DeleteDone removes rows marked done more than olderThan ago.
+func (s *PostgresSource) DeleteDone(ctx context.Context, olderThan time.Duration) error {
+	query := fmt.Sprintf(`DELETE FROM %s
+WHERE done_at IS NOT NULL AND done_at < clock_timestamp() - $1 * interval '1 millisecond'`, s.table)
+	_, err := s.db.ExecContext(ctx, query, olderThan.Milliseconds())
+	return err
+}
+
+func (s *PostgresSource) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
@@ -0,0 +1,262 @@
+//go:build integration
+
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+)
+
+func newTestPostgresSource(t *testing.T) (*worker.PostgresSource, context.Context) {
+	t.Helper()
+	db := newTestPostgres(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	source := worker.NewPostgresSource(db).WithVisibilityTimeout(200 * time.Millisecond)
+	if err := source.Migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `TRUNCATE worker_jobs`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	return source, ctx
+}
+
+func TestPostgresSource_ReceiveAck(t *testing.T) {
+	source, ctx := newTestPostgresSource(t)
+
+	id, err := source.Send(ctx, "hello", map[string]string{"tenant": "a"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	msgs, err := source.Receive(ctx, 10)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.MessageID != id || msg.Body != "hello" || msg.Attributes["tenant"] != "a" || msg.ReceiveCount != 1 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+
+	if err := source.Ack(ctx, msg); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if msgs, _ := source.Receive(ctx, 10); len(msgs) != 0 {
+		t.Fatalf("expected acked job to be gone, got %d", len(msgs))
+	}
+}
+
+func TestPostgresSource_RedeliversAfterVisibilityTimeout(t *testing.T) {
+	source, ctx := newTestPostgresSource(t)
+	_, _ = source.Send(ctx, "retry me", nil)
+
+	first, _ := source.Receive(ctx, 1)
+	if len(first) != 1 {
+		t.Fatal("expected first delivery")
+	}
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected job to stay hidden within the visibility timeout")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	second, err := source.Receive(ctx, 1)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(second) != 1 || second[0].ReceiveCount != 2 {
+		t.Fatalf("expected redelivery with receive count 2, got %v", second)
+	}
+
+	// The first claim's handle no longer matches
+	_ = source.Ack(ctx, first[0])
+	_ = source.Nack(ctx, second[0], 0)
+	third, _ := source.Receive(ctx, 1)
+	if len(third) != 1 || third[0].ReceiveCount != 3 {
+		t.Fatal("expected stale ack to be ignored")
+	}
+}
+
+func TestPostgresSource_NackDelay(t *testing.T) {
+	source, ctx := newTestPostgresSource(t)
+	source.WithVisibilityTimeout(time.Minute)
+	_, _ = source.Send(ctx, "later", nil)
+
+	msgs, _ := source.Receive(ctx, 1)
+	if err := source.Nack(ctx, msgs[0], 100*time.Millisecond); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected job to stay hidden for the nack delay")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 1 {
+		t.Fatal("expected job back after the nack delay")
+	}
+}
+
+func TestPostgresSource_SkipLocked(t *testing.T) {
+	source, ctx := newTestPostgresSource(t)
+	source.WithVisibilityTimeout(time.Minute)
+	const total = 40
+	for i := 0; i < total; i++ {
+		if _, err := source.Send(ctx, "job", nil); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msgs, err := source.Receive(ctx, 3)
+				if err != nil {
+					t.Errorf("receive: %v", err)
+					return
+				}
+				if len(msgs) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, msg := range msgs {
+					seen[msg.MessageID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != total {
+		t.Fatalf("expected %d distinct jobs, got %d", total, len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("job %s claimed %d times", id, n)
+		}
+	}
+}
+
+func TestPostgresSource_EnqueueTx(t *testing.T) {
+	db := newTestPostgres(t)
+	source, ctx := newTestPostgresSource(t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.EnqueueTx(ctx, tx, "rolled back", nil, 0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_ = tx.Rollback()
+
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := source.EnqueueTx(ctx, tx, "committed", nil, 0)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// Not visible until the transaction commits
+	if msgs, _ := source.Receive(ctx, 10); len(msgs) != 0 {
+		t.Fatal("expected uncommitted job to be invisible")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, _ := source.Receive(ctx, 10)
+	if len(msgs) != 1 || msgs[0].MessageID != id {
+		t.Fatalf("expected only the committed job, got %v", msgs)
+	}
+}
+
+func TestPostgresSource_MarkDone(t *testing.T) {
+	db := newTestPostgres(t)
+	source, ctx := newTestPostgresSource(t)
+	source.WithAckMode(worker.AckMarkDone)
+	_, _ = source.Send(ctx, "audit me", nil)
+
+	msgs, _ := source.Receive(ctx, 1)
+	if err := source.Ack(ctx, msgs[0]); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+
+	var done int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM worker_jobs WHERE done_at IS NOT NULL`).Scan(&done); err != nil {
+		t.Fatal(err)
+	}
+	if done != 1 {
+		t.Fatalf("expected job kept as done, got %d", done)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected done job not to be redelivered")
+	}
+
+	if err := source.DeleteDone(ctx, 0); err != nil {
+		t.Fatalf("delete done: %v", err)
+	}
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM worker_jobs`).Scan(&done); err != nil {
+		t.Fatal(err)
+	}
+	if done != 0 {
+		t.Fatalf("expected done job pruned, got %d rows", done)
+	}
+}
+
+func TestRunner_PostgresSource(t *testing.T) {
+	source, ctx := newTestPostgresSource(t)
+	_, _ = source.Send(ctx, "flaky", nil)
+
+	var mu sync.Mutex
+	var counts []int
+	done := make(chan struct{})
+	handler := func(ctx context.Context, msg *worker.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, msg.ReceiveCount)
+		if len(counts) == 1 {
+			return errors.New("transient")
+		}
+		close(done)
+		return nil
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	runner := worker.NewRunner(source, handler, 1, 1).
+		WithIdleBackoff(1, 10*time.Millisecond, 50*time.Millisecond)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runner.Run(runCtx)
+	}()
+
+	select {
+	case <-done:
+	case <-runCtx.Done():
+		t.Fatal("timeout waiting for retry")
+	}
+	cancel()
+	<-errCh
+
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 2 {
+		t.Fatalf("expected deliveries with receive counts [1 2], got %v", counts)
+	}
+}
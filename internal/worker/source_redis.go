@@ -0,0 +1,329 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// streamBodyField is the entry field holding the message body; every
+	// other field becomes an attribute.
+	streamBodyField = "body"
+	// streamSourceIDField records a dead-lettered entry's original ID.
+	streamSourceIDField = "source_id"
+)
+
+// streamAckScript acknowledges ARGV[2] only while this consumer (ARGV[3])
+// owns it, so a handle whose entry was reclaimed elsewhere is ignored. With
+// ARGV[4] set the entry is deleted as well.
+var streamAckScript = redis.NewScript(`
+local p = redis.call("XPENDING", KEYS[1], ARGV[1], ARGV[2], ARGV[2], 1, ARGV[3])
+if #p == 0 then
+	return 0
+end
+redis.call("XACK", KEYS[1], ARGV[1], ARGV[2])
+if ARGV[4] == "1" then
+	redis.call("XDEL", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// streamIdleScript sets the idle time of pending entry ARGV[2] to ARGV[4]
+// milliseconds while this consumer (ARGV[3]) owns it, keeping its delivery
+// count.
+var streamIdleScript = redis.NewScript(`
+local p = redis.call("XPENDING", KEYS[1], ARGV[1], ARGV[2], ARGV[2], 1, ARGV[3])
+if #p == 0 then
+	return 0
+end
+redis.call("XCLAIM", KEYS[1], ARGV[1], ARGV[3], 0, ARGV[2], "IDLE", ARGV[4], "RETRYCOUNT", p[1][4], "JUSTID")
+return 1
+`)
+
+// RedisStreamSource consumes a Redis stream through a consumer group. New
+// entries are read with XREADGROUP; entries left pending for longer than
+// the visibility timeout, because their consumer failed or crashed, are
+// reclaimed with XAUTOCLAIM and delivered again, like an SQS visibility
+// timeout running out.
+//
+// Each delivery bumps the entry's delivery count, reported as
+// Message.ReceiveCount. With WithDeadLetter, an entry reclaimed more than
+// maxDeliveries times is moved to the dead-letter stream instead, mirroring
+// an SQS redrive policy. In a cluster, give both streams the same hash tag
+// (e.g. "{orders}" and "{orders}:dlq") as they are written together.
+type RedisStreamSource struct {
+	client   redis.UniversalClient
+	stream   string
+	group    string
+	consumer string
+
+	visibility time.Duration
+	waitTime   time.Duration
+
+	deadLetter    string
+	maxDeliveries int64
+	deleteOnAck   bool
+
+	// cursor is where the next XAUTOCLAIM scan resumes. Receive is only
+	// called from the Runner's feeder, so it needs no lock.
+	cursor string
+}
+
+// NewRedisStreamSource reads stream as consumer within group. Consumer
+// names must be unique per worker process; call EnsureGroup before the
+// first Receive.
+func NewRedisStreamSource(client redis.UniversalClient, stream, group, consumer string) *RedisStreamSource {
+	return &RedisStreamSource{
+		client:     client,
+		stream:     stream,
+		group:      group,
+		consumer:   consumer,
+		visibility: 30 * time.Second,
+		waitTime:   5 * time.Second,
+		cursor:     "0-0",
+	}
+}
+
+// WithVisibilityTimeout sets how long an entry may stay pending before
+// another Receive reclaims it. Defaults to 30s.
+func (s *RedisStreamSource) WithVisibilityTimeout(d time.Duration) *RedisStreamSource {
+	s.visibility = d
+	return s
+}
+
+// WithWaitTime sets how long Receive blocks for new entries. Defaults to 5s;
+// zero makes Receive return at once, and the Runner polls instead.
+func (s *RedisStreamSource) WithWaitTime(d time.Duration) *RedisStreamSource {
+	s.waitTime = d
+	return s
+}
+
+// WithDeadLetter moves entries delivered more than maxDeliveries times to
+// the stream deadLetter, with their original ID in the "source_id" field.
+func (s *RedisStreamSource) WithDeadLetter(deadLetter string, maxDeliveries int) *RedisStreamSource {
+	s.deadLetter = deadLetter
+	s.maxDeliveries = int64(maxDeliveries)
+	return s
+}
+
+// WithDeleteOnAck deletes entries from the stream once acknowledged. Only
+// use it when no other consumer group reads the stream.
+func (s *RedisStreamSource) WithDeleteOnAck() *RedisStreamSource {
+	s.deleteOnAck = true
+	return s
+}
+
+// EnsureGroup creates the stream and consumer group if they don't exist. A
+// new group starts with the entries already in the stream.
+func (s *RedisStreamSource) EnsureGroup(ctx context.Context) error {
+	err := s.client.XGroupCreateMkStream(ctx, s.stream, s.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("create consumer group: %w", err)
+	}
+	return nil
+}
+
+// Send appends a message to the stream and returns its entry ID.
+func (s *RedisStreamSource) Send(ctx context.Context, body string, attrs map[string]string) (string, error) {
+	values := make([]any, 0, 2+2*len(attrs))
+	values = append(values, streamBodyField, body)
+	for k, v := range attrs {
+		values = append(values, k, v)
+	}
+	return s.client.XAdd(ctx, &redis.XAddArgs{Stream: s.stream, Values: values}).Result()
+}
+
+// Receive returns reclaimed entries first, then blocks for new ones if
+// there is room left in the batch.
+func (s *RedisStreamSource) Receive(ctx context.Context, max int) ([]*Message, error) {
+	if max < 1 {
+		max = 1
+	}
+	msgs, err := s.reclaim(ctx, max)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) >= max {
+		return msgs, nil
+	}
+
+	// Don't hold reclaimed entries back waiting for new ones. A Block of 0
+	// would wait forever, so a zero wait time also skips blocking.
+	block := s.waitTime
+	if len(msgs) > 0 || s.waitTime <= 0 {
+		block = -1
+	}
+	streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{s.stream, ">"},
+		Count:    int64(max - len(msgs)),
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return msgs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xreadgroup: %w", err)
+	}
+	for _, stream := range streams {
+		for _, entry := range stream.Messages {
+			msgs = append(msgs, streamMessage(entry, 1))
+		}
+	}
+	return msgs, nil
+}
+
+// reclaim takes over up to max entries pending longer than the visibility
+// timeout, dead-lettering those out of deliveries.
+func (s *RedisStreamSource) reclaim(ctx context.Context, max int) ([]*Message, error) {
+	entries, next, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.stream,
+		Group:    s.group,
+		Consumer: s.consumer,
+		MinIdle:  s.visibility,
+		Start:    s.cursor,
+		Count:    int64(max),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("xautoclaim: %w", err)
+	}
+	s.cursor = next
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	counts, err := s.deliveryCounts(ctx, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*Message, 0, len(entries))
+	for i, entry := range entries {
+		if s.deadLetter != "" && counts[i] > s.maxDeliveries {
+			if err := s.moveToDeadLetter(ctx, entry); err != nil {
+				return nil, err
+			}
+			fmt.Printf("stream entry %s dead-lettered after %d deliveries\n", entry.ID, counts[i]-1)
+			continue
+		}
+		msgs = append(msgs, streamMessage(entry, counts[i]))
+	}
+	return msgs, nil
+}
+
+// deliveryCounts looks up how many times each entry has been delivered,
+// counting the claim that just happened.
+func (s *RedisStreamSource) deliveryCounts(ctx context.Context, entries []redis.XMessage) ([]int64, error) {
+	cmds := make([]*redis.XPendingExtCmd, len(entries))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: s.stream,
+				Group:  s.group,
+				Start:  entry.ID,
+				End:    entry.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("xpending: %w", err)
+	}
+
+	counts := make([]int64, len(entries))
+	for i, cmd := range cmds {
+		counts[i] = 1
+		if pending := cmd.Val(); len(pending) == 1 {
+			counts[i] = pending[0].RetryCount
+		}
+	}
+	return counts, nil
+}
+
+func (s *RedisStreamSource) moveToDeadLetter(ctx context.Context, entry redis.XMessage) error {
+	values := make(map[string]any, len(entry.Values)+1)
+	for k, v := range entry.Values {
+		values[k] = v
+	}
+	values[streamSourceIDField] = entry.ID
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: s.deadLetter, Values: values})
+		pipe.XAck(ctx, s.stream, s.group, entry.ID)
+		pipe.XDel(ctx, s.stream, entry.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("dead-letter %s: %w", entry.ID, err)
+	}
+	return nil
+}
+
+func streamMessage(entry redis.XMessage, receiveCount int64) *Message {
+	id := entry.ID
+	msg := &Message{
+		MessageID:     id,
+		ReceiptHandle: &id,
+		ReceiveCount:  int(receiveCount),
+	}
+	for k, v := range entry.Values {
+		str, _ := v.(string)
+		if k == streamBodyField {
+			msg.Body = str
+			continue
+		}
+		if msg.Attributes == nil {
+			msg.Attributes = make(map[string]string, len(entry.Values))
+		}
+		msg.Attributes[k] = str
+	}
+	return msg
+}
+
+// Ack acknowledges msg in the consumer group. It is a no-op if the entry
+// has since been reclaimed by another consumer.
+func (s *RedisStreamSource) Ack(ctx context.Context, msg *Message) error {
+	deleteFlag := "0"
+	if s.deleteOnAck {
+		deleteFlag = "1"
+	}
+	err := streamAckScript.Run(ctx, s.client, []string{s.stream}, s.group, msg.MessageID, s.consumer, deleteFlag).Err()
+	if err != nil {
+		return fmt.Errorf("xack: %w", err)
+	}
+	return nil
+}
+
+// Nack makes msg reclaimable after delay by backdating its idle time. A
+// delay longer than the visibility timeout is capped to it.
+func (s *RedisStreamSource) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return s.setIdle(ctx, msg, s.visibility-delay)
+}
+
+// Extend resets msg's idle time so it isn't reclaimed for another d. Streams
+// have no per-entry timeout, so d is capped to the visibility timeout.
+func (s *RedisStreamSource) Extend(ctx context.Context, msg *Message, d time.Duration) error {
+	return s.setIdle(ctx, msg, s.visibility-d)
+}
+
+func (s *RedisStreamSource) setIdle(ctx context.Context, msg *Message, idle time.Duration) error {
+	idle = max(idle, 0)
+	err := streamIdleScript.Run(ctx, s.client, []string{s.stream}, s.group, msg.MessageID, s.consumer, idle.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("xclaim: %w", err)
+	}
+	return nil
+}
+
+// LongPolling reports whether Receive blocks for new entries, i.e. the wait
+// time is positive.
+func (s *RedisStreamSource) LongPolling() bool {
+	return s.waitTime > 0
+}
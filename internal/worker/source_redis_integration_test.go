@@ -0,0 +1,238 @@
+//go:build integration
+
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"go-sqs-worker/internal/worker"
+)
+
+func newTestStreamSource(t *testing.T) (*worker.RedisStreamSource, *redis.Client, context.Context) {
+	t.Helper()
+	client, ctx := newTestRedis(t)
+	source := worker.NewRedisStreamSource(client, "stream:test", "workers", "c1").
+		WithWaitTime(20 * time.Millisecond).
+		WithVisibilityTimeout(100 * time.Millisecond)
+	if err := source.EnsureGroup(ctx); err != nil {
+		t.Fatalf("ensure group: %v", err)
+	}
+	// Creating it again is a no-op
+	if err := source.EnsureGroup(ctx); err != nil {
+		t.Fatalf("ensure group twice: %v", err)
+	}
+	return source, client, ctx
+}
+
+func TestRedisStreamSource_ReceiveAck(t *testing.T) {
+	source, _, ctx := newTestStreamSource(t)
+
+	id, err := source.Send(ctx, "hello", map[string]string{"tenant": "a"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := source.Send(ctx, "world", nil); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	msgs, err := source.Receive(ctx, 10)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	first := msgs[0]
+	if first.MessageID != id || first.Body != "hello" || first.Attributes["tenant"] != "a" || first.ReceiveCount != 1 {
+		t.Fatalf("unexpected message: %+v", first)
+	}
+
+	for _, msg := range msgs {
+		if err := source.Ack(ctx, msg); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	msgs, err = source.Receive(ctx, 10)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expected acked messages to stay gone, got %d", len(msgs))
+	}
+}
+
+func TestRedisStreamSource_ZeroWaitTimeDoesNotBlock(t *testing.T) {
+	source, _, ctx := newTestStreamSource(t)
+	source.WithWaitTime(0)
+	if source.LongPolling() {
+		t.Fatal("expected no long polling with a zero wait time")
+	}
+
+	received := make(chan error, 1)
+	go func() {
+		_, err := source.Receive(ctx, 10)
+		received <- err
+	}()
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Receive on an empty stream to return at once")
+	}
+}
+
+func TestRedisStreamSource_ReclaimsAfterVisibilityTimeout(t *testing.T) {
+	source, _, ctx := newTestStreamSource(t)
+	id, _ := source.Send(ctx, "retry me", nil)
+
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 1 {
+		t.Fatal("expected first delivery")
+	}
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected message to stay hidden within the visibility timeout")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	msgs, err := source.Receive(ctx, 1)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].MessageID != id {
+		t.Fatalf("expected %s to be reclaimed, got %v", id, msgs)
+	}
+	if msgs[0].ReceiveCount != 2 {
+		t.Fatalf("expected receive count 2, got %d", msgs[0].ReceiveCount)
+	}
+}
+
+func TestRedisStreamSource_Nack(t *testing.T) {
+	source, _, ctx := newTestStreamSource(t)
+	source.WithVisibilityTimeout(time.Minute)
+	_, _ = source.Send(ctx, "later", nil)
+
+	msgs, _ := source.Receive(ctx, 1)
+	if err := source.Nack(ctx, msgs[0], 0); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+	again, _ := source.Receive(ctx, 1)
+	if len(again) != 1 || again[0].ReceiveCount != 2 {
+		t.Fatalf("expected nacked message to be delivered again, got %+v", again[0])
+	}
+
+	if err := source.Extend(ctx, again[0], time.Minute); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatal("expected extended message to stay hidden")
+	}
+}
+
+func TestRedisStreamSource_IgnoresOtherConsumersHandles(t *testing.T) {
+	source, client, ctx := newTestStreamSource(t)
+	other := worker.NewRedisStreamSource(client, "stream:test", "workers", "c2").
+		WithWaitTime(20 * time.Millisecond)
+
+	_, _ = source.Send(ctx, "mine", nil)
+	msgs, _ := source.Receive(ctx, 1)
+
+	// c2 can't ack or release what c1 holds
+	_ = other.Ack(ctx, msgs[0])
+	_ = other.Nack(ctx, msgs[0], 0)
+	time.Sleep(10 * time.Millisecond)
+	if got, _ := other.Receive(ctx, 1); len(got) != 0 {
+		t.Fatal("expected message to stay with its consumer")
+	}
+
+	pending, err := client.XPending(ctx, "stream:test", "workers").Result()
+	if err != nil {
+		t.Fatalf("xpending: %v", err)
+	}
+	if pending.Count != 1 {
+		t.Fatalf("expected message still pending, got %d", pending.Count)
+	}
+}
+
+func TestRedisStreamSource_DeadLetter(t *testing.T) {
+	source, client, ctx := newTestStreamSource(t)
+	source.WithDeadLetter("stream:test:dlq", 2).WithVisibilityTimeout(20 * time.Millisecond)
+	id, _ := source.Send(ctx, "poison", map[string]string{"tenant": "a"})
+
+	for i := 1; i <= 2; i++ {
+		msgs, err := source.Receive(ctx, 1)
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].ReceiveCount != i {
+			t.Fatalf("delivery %d: unexpected messages %v", i, msgs)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+
+	if msgs, _ := source.Receive(ctx, 1); len(msgs) != 0 {
+		t.Fatalf("expected message to be dead-lettered, got %v", msgs)
+	}
+
+	dead, err := client.XRange(ctx, "stream:test:dlq", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("xrange: %v", err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("expected 1 dead-lettered entry, got %d", len(dead))
+	}
+	values := dead[0].Values
+	if values["source_id"] != id || values["body"] != "poison" || values["tenant"] != "a" {
+		t.Fatalf("unexpected dead-letter entry: %v", values)
+	}
+	if n, _ := client.XLen(ctx, "stream:test").Result(); n != 0 {
+		t.Fatalf("expected entry removed from the stream, got %d", n)
+	}
+}
+
+func TestRunner_RedisStreamSourceRetries(t *testing.T) {
+	source, _, ctx := newTestStreamSource(t)
+	source.WithVisibilityTimeout(50 * time.Millisecond)
+	_, _ = source.Send(ctx, "flaky", nil)
+
+	var mu sync.Mutex
+	var counts []int
+	done := make(chan struct{})
+	handler := func(ctx context.Context, msg *worker.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, msg.ReceiveCount)
+		if len(counts) == 1 {
+			return errors.New("transient")
+		}
+		close(done)
+		return nil
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- worker.NewRunner(source, handler, 1, 1).Run(runCtx)
+	}()
+
+	select {
+	case <-done:
+	case <-runCtx.Done():
+		t.Fatal("timeout waiting for retry")
+	}
+	cancel()
+	<-errCh
+
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 2 {
+		t.Fatalf("expected deliveries with receive counts [1 2], got %v", counts)
+	}
+}
@@ -0,0 +1,1131 @@
+// Package sqsfake is an in-memory SQS for tests. Its Client implements
+// worker.SQSClient and worker.SQSSender along with the batch, queue and
+// visibility APIs, and models visibility timeouts against an injectable
+// clock, receive counts, redrive to a dead-letter queue after
+// maxReceiveCount, FIFO message group locking and injected faults.
+package sqsfake
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+)
+
+const (
+	// Region and AccountID appear in queue URLs and ARNs.
+	Region    = "us-east-1"
+	AccountID = "000000000000"
+
+	maxBatch       = 10
+	maxBodyBytes   = 256 * 1024
+	maxVisibility  = 12 * time.Hour
+	dedupeInterval = 5 * time.Minute
+)
+
+// Client is an in-memory SQS service. The zero value is not usable; call
+// New.
+type Client struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	baseURL string
+	queues  map[string]*queue
+	faults  []*Fault
+	calls   map[string]int
+	seq     int64
+	// changed is closed and replaced whenever a message may have become
+	// receivable, to wake long polls
+	changed chan struct{}
+}
+
+type queue struct {
+	name      string
+	url       string
+	arn       string
+	createdAt time.Time
+	attrs     map[string]string
+
+	fifo            bool
+	contentDedupe   bool
+	visibility      time.Duration
+	delay           time.Duration
+	waitTime        time.Duration
+	deadLetterARN   string
+	maxReceiveCount int
+
+	messages []*message
+	dedupe   map[string]dedupeEntry
+}
+
+type dedupeEntry struct {
+	messageID string
+	seq       string
+	at        time.Time
+}
+
+type message struct {
+	id        string
+	body      string
+	attrs     map[string]types.MessageAttributeValue
+	md5Body   string
+	md5Attrs  string
+	sentAt    time.Time
+	visibleAt time.Time
+
+	receiveCount   int
+	firstReceiveAt time.Time
+	// receipt is the latest receipt handle, or empty if never received
+	receipt string
+
+	groupID   string
+	dedupeID  string
+	seq       string
+	sourceARN string
+}
+
+// New creates an empty service whose queue URLs start with
+// https://sqs.us-east-1.amazonaws.com.
+func New() *Client {
+	return &Client{
+		now:     time.Now,
+		baseURL: "https://sqs." + Region + ".amazonaws.com",
+		queues:  make(map[string]*queue),
+		calls:   make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// WithClock replaces time.Now, e.g. to drive visibility timeouts from a
+// fake clock. Long polls still wait in real time.
+func (c *Client) WithClock(now func() time.Time) *Client {
+	c.now = now
+	return c
+}
+
+// WithBaseURL sets the scheme and host of queue URLs handed out from now
+// on, e.g. the address of a server fronting the fake. Queues are looked up
+// by the last element of their URL, so URLs with any host work.
+func (c *Client) WithBaseURL(baseURL string) *Client {
+	c.baseURL = strings.TrimSuffix(baseURL, "/")
+	return c
+}
+
+// Fault makes matching calls fail before they take effect.
+type Fault struct {
+	// Op is the SQS action, e.g. "DeleteMessage"; empty matches every
+	// action.
+	Op string
+	// QueueURL limits the fault to one queue; empty matches every queue.
+	QueueURL string
+	Err      error
+	// Times is how many calls fail; 0 fails calls until ClearFaults.
+	Times int
+}
+
+// InjectFault adds f. Faults are matched in the order they were added.
+func (c *Client) InjectFault(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, &f)
+}
+
+// ClearFaults removes every injected fault.
+func (c *Client) ClearFaults() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = nil
+}
+
+// Calls reports how many times op has been called, including calls that
+// failed.
+func (c *Client) Calls(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[op]
+}
+
+// begin counts a call to op and returns the error of the first matching
+// fault, if any. c.mu must be held.
+func (c *Client) begin(op string, queueURL *string) error {
+	c.calls[op]++
+	for i, f := range c.faults {
+		if f.Op != "" && f.Op != op {
+			continue
+		}
+		if f.QueueURL != "" && (queueURL == nil || queueName(*queueURL) != queueName(f.QueueURL)) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				c.faults = append(c.faults[:i:i], c.faults[i+1:]...)
+			}
+		}
+		return f.Err
+	}
+	return nil
+}
+
+// notify wakes long polls. c.mu must be held.
+func (c *Client) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func queueName(queueURL string) string {
+	return path.Base(strings.TrimSuffix(queueURL, "/"))
+}
+
+func (c *Client) lookup(queueURL *string) (*queue, error) {
+	if queueURL != nil {
+		if q, ok := c.queues[queueName(*queueURL)]; ok {
+			return q, nil
+		}
+	}
+	return nil, &types.QueueDoesNotExist{Message: aws.String("The specified queue does not exist.")}
+}
+
+func (c *Client) lookupARN(arn string) *queue {
+	for _, q := range c.queues {
+		if q.arn == arn {
+			return q
+		}
+	}
+	return nil
+}
+
+func invalidParameter(format string, args ...any) error {
+	return &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: fmt.Sprintf(format, args...), Fault: smithy.FaultClient}
+}
+
+func missingParameter(name string) error {
+	return &smithy.GenericAPIError{Code: "MissingParameter", Message: "The request must contain the parameter " + name + ".", Fault: smithy.FaultClient}
+}
+
+var queueNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,80}$`)
+
+func (c *Client) CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("CreateQueue", nil); err != nil {
+		return nil, err
+	}
+	if params.QueueName == nil {
+		return nil, missingParameter("QueueName")
+	}
+	name := *params.QueueName
+	fifo := strings.HasSuffix(name, ".fifo")
+	if !queueNamePattern.MatchString(strings.TrimSuffix(name, ".fifo")) {
+		return nil, invalidParameter("Can only include alphanumeric characters, hyphens, or underscores. 1 to 80 in length")
+	}
+	if (params.Attributes["FifoQueue"] == "true") != fifo {
+		return nil, invalidParameter("The name of a FIFO queue can only include alphanumeric characters, hyphens, or underscores, must end with .fifo suffix")
+	}
+
+	if existing, ok := c.queues[name]; ok {
+		for k, v := range params.Attributes {
+			if existing.attrs[k] != v {
+				return nil, &types.QueueNameExists{Message: aws.String("A queue already exists with the same name and a different value for attribute " + k)}
+			}
+		}
+		return &sqs.CreateQueueOutput{QueueUrl: aws.String(existing.url)}, nil
+	}
+
+	q := &queue{
+		name:       name,
+		url:        c.baseURL + "/" + AccountID + "/" + name,
+		arn:        "arn:aws:sqs:" + Region + ":" + AccountID + ":" + name,
+		createdAt:  c.now(),
+		attrs:      make(map[string]string),
+		fifo:       fifo,
+		visibility: 30 * time.Second,
+		dedupe:     make(map[string]dedupeEntry),
+	}
+	if err := q.setAttributes(params.Attributes); err != nil {
+		return nil, err
+	}
+	c.queues[name] = q
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(q.url)}, nil
+}
+
+// MustCreateQueue creates a queue with the given attributes and returns its
+// URL, panicking on invalid input.
+func (c *Client) MustCreateQueue(name string, attrs map[string]string) string {
+	out, err := c.CreateQueue(context.Background(), &sqs.CreateQueueInput{QueueName: &name, Attributes: attrs})
+	if err != nil {
+		panic(err)
+	}
+	return *out.QueueUrl
+}
+
+func (q *queue) setAttributes(attrs map[string]string) error {
+	seconds := func(name string, value string, max time.Duration) (time.Duration, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > max {
+			return 0, &types.InvalidAttributeValue{Message: aws.String("Invalid value for the parameter " + name + ".")}
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+
+	var err error
+	for name, value := range attrs {
+		switch name {
+		case "VisibilityTimeout":
+			q.visibility, err = seconds(name, value, maxVisibility)
+		case "DelaySeconds":
+			q.delay, err = seconds(name, value, 15*time.Minute)
+		case "ReceiveMessageWaitTimeSeconds":
+			q.waitTime, err = seconds(name, value, 20*time.Second)
+		case "FifoQueue":
+			if (value == "true") != q.fifo {
+				err = invalidParameter("FifoQueue can't be changed")
+			}
+		case "ContentBasedDeduplication":
+			if !q.fifo {
+				err = invalidParameter("ContentBasedDeduplication is only valid for FIFO queues")
+			}
+			q.contentDedupe = value == "true"
+		case "RedrivePolicy":
+			q.deadLetterARN, q.maxReceiveCount = "", 0
+			if value == "" {
+				break
+			}
+			var policy struct {
+				DeadLetterTargetArn string
+				MaxReceiveCount     json.Number
+			}
+			err = json.Unmarshal([]byte(value), &policy)
+			if err == nil {
+				var n int64
+				n, err = policy.MaxReceiveCount.Int64()
+				q.deadLetterARN, q.maxReceiveCount = policy.DeadLetterTargetArn, int(n)
+			}
+			if err != nil || q.maxReceiveCount < 1 || q.deadLetterARN == "" {
+				err = &types.InvalidAttributeValue{Message: aws.String("Invalid value for the parameter RedrivePolicy.")}
+			}
+		case "MessageRetentionPeriod", "MaximumMessageSize", "Policy", "RedriveAllowPolicy",
+			"KmsMasterKeyId", "KmsDataKeyReusePeriodSeconds", "SqsManagedSseEnabled",
+			"DeduplicationScope", "FifoThroughputLimit":
+			// Accepted and reported, but not modelled
+		default:
+			err = &types.InvalidAttributeName{Message: aws.String("Unknown Attribute " + name + ".")}
+		}
+		if err != nil {
+			return err
+		}
+		q.attrs[name] = value
+	}
+	return nil
+}
+
+func (c *Client) GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetQueueUrl", params.QueueName); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(q.url)}, nil
+}
+
+func (c *Client) ListQueues(ctx context.Context, params *sqs.ListQueuesInput, optFns ...func(*sqs.Options)) (*sqs.ListQueuesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ListQueues", nil); err != nil {
+		return nil, err
+	}
+	out := &sqs.ListQueuesOutput{}
+	for name, q := range c.queues {
+		if strings.HasPrefix(name, aws.ToString(params.QueueNamePrefix)) {
+			out.QueueUrls = append(out.QueueUrls, q.url)
+		}
+	}
+	sort.Strings(out.QueueUrls)
+	return out, nil
+}
+
+func (c *Client) DeleteQueue(ctx context.Context, params *sqs.DeleteQueueInput, optFns ...func(*sqs.Options)) (*sqs.DeleteQueueOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteQueue", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	delete(c.queues, q.name)
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+func (c *Client) SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("SetQueueAttributes", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.setAttributes(params.Attributes); err != nil {
+		return nil, err
+	}
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func (c *Client) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("GetQueueAttributes", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := q.stats(c.now())
+	all := map[string]string{
+		"QueueArn":                              q.arn,
+		"CreatedTimestamp":                      strconv.FormatInt(q.createdAt.Unix(), 10),
+		"VisibilityTimeout":                     strconv.Itoa(int(q.visibility / time.Second)),
+		"DelaySeconds":                          strconv.Itoa(int(q.delay / time.Second)),
+		"ReceiveMessageWaitTimeSeconds":         strconv.Itoa(int(q.waitTime / time.Second)),
+		"ApproximateNumberOfMessages":           strconv.Itoa(stats.Visible),
+		"ApproximateNumberOfMessagesNotVisible": strconv.Itoa(stats.InFlight),
+		"ApproximateNumberOfMessagesDelayed":    strconv.Itoa(stats.Delayed),
+	}
+	for name, value := range q.attrs {
+		if _, ok := all[name]; !ok {
+			all[name] = value
+		}
+	}
+	if q.fifo {
+		all["FifoQueue"] = "true"
+		all["ContentBasedDeduplication"] = strconv.FormatBool(q.contentDedupe)
+	}
+
+	out := &sqs.GetQueueAttributesOutput{Attributes: make(map[string]string)}
+	for _, name := range params.AttributeNames {
+		if name == types.QueueAttributeNameAll {
+			out.Attributes = all
+			break
+		}
+		if value, ok := all[string(name)]; ok {
+			out.Attributes[string(name)] = value
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) PurgeQueue(ctx context.Context, params *sqs.PurgeQueueInput, optFns ...func(*sqs.Options)) (*sqs.PurgeQueueOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("PurgeQueue", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	q.messages = nil
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+// sendEntry is the common part of SendMessage and its batch entries.
+type sendEntry struct {
+	body     *string
+	delay    int32
+	attrs    map[string]types.MessageAttributeValue
+	groupID  *string
+	dedupeID *string
+}
+
+type sendResult struct {
+	id       string
+	md5Body  string
+	md5Attrs string
+	seq      string
+}
+
+func (c *Client) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("SendMessage", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.send(q, sendEntry{
+		body:     params.MessageBody,
+		delay:    params.DelaySeconds,
+		attrs:    params.MessageAttributes,
+		groupID:  params.MessageGroupId,
+		dedupeID: params.MessageDeduplicationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.SendMessageOutput{
+		MessageId:              aws.String(res.id),
+		MD5OfMessageBody:       aws.String(res.md5Body),
+		MD5OfMessageAttributes: optional(res.md5Attrs),
+		SequenceNumber:         optional(res.seq),
+	}, nil
+}
+
+func (c *Client) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("SendMessageBatch", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]*string, len(params.Entries))
+	for i, e := range params.Entries {
+		ids[i] = e.Id
+	}
+	if err := checkBatch(ids); err != nil {
+		return nil, err
+	}
+
+	out := &sqs.SendMessageBatchOutput{}
+	for _, e := range params.Entries {
+		res, err := c.send(q, sendEntry{
+			body:     e.MessageBody,
+			delay:    e.DelaySeconds,
+			attrs:    e.MessageAttributes,
+			groupID:  e.MessageGroupId,
+			dedupeID: e.MessageDeduplicationId,
+		})
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{
+			Id:                     e.Id,
+			MessageId:              aws.String(res.id),
+			MD5OfMessageBody:       aws.String(res.md5Body),
+			MD5OfMessageAttributes: optional(res.md5Attrs),
+			SequenceNumber:         optional(res.seq),
+		})
+	}
+	return out, nil
+}
+
+// send enqueues one message. c.mu must be held.
+func (c *Client) send(q *queue, e sendEntry) (sendResult, error) {
+	if e.body == nil || *e.body == "" {
+		return sendResult{}, missingParameter("MessageBody")
+	}
+	if len(*e.body) > maxBodyBytes {
+		return sendResult{}, invalidParameter("One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", maxBodyBytes)
+	}
+	for name, attr := range e.attrs {
+		if attr.DataType == nil || (attr.StringValue == nil && attr.BinaryValue == nil) {
+			return sendResult{}, invalidParameter("The message attribute '%s' must contain a non-empty value and data type.", name)
+		}
+	}
+	if e.delay < 0 || e.delay > 900 {
+		return sendResult{}, invalidParameter("Value %d for parameter DelaySeconds is invalid. Reason: must be between 0 and 900.", e.delay)
+	}
+
+	now := c.now()
+	delay := q.delay
+	if e.delay > 0 {
+		delay = time.Duration(e.delay) * time.Second
+	}
+	m := &message{
+		id:        uuid.New().String(),
+		body:      *e.body,
+		attrs:     e.attrs,
+		md5Body:   md5Hex(*e.body),
+		md5Attrs:  md5OfAttributes(e.attrs),
+		sentAt:    now,
+		visibleAt: now.Add(delay),
+		groupID:   aws.ToString(e.groupID),
+	}
+
+	if q.fifo {
+		if m.groupID == "" {
+			return sendResult{}, missingParameter("MessageGroupId")
+		}
+		if e.delay > 0 {
+			return sendResult{}, invalidParameter("Value %d for parameter DelaySeconds is invalid. Reason: The request include parameter that is not valid for this queue type.", e.delay)
+		}
+		m.dedupeID = aws.ToString(e.dedupeID)
+		if m.dedupeID == "" {
+			if !q.contentDedupe {
+				return sendResult{}, invalidParameter("The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+			}
+			sum := sha256.Sum256([]byte(m.body))
+			m.dedupeID = hex.EncodeToString(sum[:])
+		}
+		if prev, ok := q.dedupe[m.dedupeID]; ok && now.Sub(prev.at) < dedupeInterval {
+			return sendResult{id: prev.messageID, md5Body: m.md5Body, md5Attrs: m.md5Attrs, seq: prev.seq}, nil
+		}
+		c.seq++
+		m.seq = fmt.Sprintf("%020d", c.seq)
+		q.dedupe[m.dedupeID] = dedupeEntry{messageID: m.id, seq: m.seq, at: now}
+	}
+
+	q.messages = append(q.messages, m)
+	c.notify()
+	return sendResult{id: m.id, md5Body: m.md5Body, md5Attrs: m.md5Attrs, seq: m.seq}, nil
+}
+
+func (c *Client) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	c.mu.Lock()
+	if err := c.begin("ReceiveMessage", params.QueueUrl); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	max := int(params.MaxNumberOfMessages)
+	if max == 0 {
+		max = 1
+	}
+	if max < 1 || max > maxBatch {
+		c.mu.Unlock()
+		return nil, invalidParameter("Value %d for parameter MaxNumberOfMessages is invalid. Reason: Must be between 1 and 10, if provided.", max)
+	}
+	if params.VisibilityTimeout < 0 || time.Duration(params.VisibilityTimeout)*time.Second > maxVisibility {
+		c.mu.Unlock()
+		return nil, invalidParameter("Value %d for parameter VisibilityTimeout is invalid.", params.VisibilityTimeout)
+	}
+	wait := q.waitTime
+	if params.WaitTimeSeconds > 0 {
+		wait = time.Duration(params.WaitTimeSeconds) * time.Second
+	}
+	c.mu.Unlock()
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	// Recheck now and then, as a fake clock advancing doesn't notify
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		c.mu.Lock()
+		if c.queues[q.name] != q {
+			c.mu.Unlock()
+			return nil, &types.QueueDoesNotExist{Message: aws.String("The specified queue does not exist.")}
+		}
+		msgs := c.receive(q, max, params)
+		changed := c.changed
+		c.mu.Unlock()
+		if len(msgs) > 0 || wait == 0 {
+			return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+		}
+
+		select {
+		case <-changed:
+		case <-tick.C:
+		case <-deadline.C:
+			return &sqs.ReceiveMessageOutput{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// receive hands out up to max receivable messages, oldest first, moving
+// those past maxReceiveCount to the dead-letter queue. c.mu must be held.
+func (c *Client) receive(q *queue, max int, params *sqs.ReceiveMessageInput) []types.Message {
+	now := c.now()
+	visibility := q.visibility
+	if params.VisibilityTimeout > 0 {
+		visibility = time.Duration(params.VisibilityTimeout) * time.Second
+	}
+
+	// A FIFO group is locked while any of its messages is in flight
+	locked := make(map[string]bool)
+	if q.fifo {
+		for _, m := range q.messages {
+			if m.inFlight(now) {
+				locked[m.groupID] = true
+			}
+		}
+	}
+
+	var out []types.Message
+	var kept []*message
+	for _, m := range q.messages {
+		if len(out) == max || m.visibleAt.After(now) || (q.fifo && locked[m.groupID]) {
+			kept = append(kept, m)
+			continue
+		}
+		if q.maxReceiveCount > 0 && m.receiveCount >= q.maxReceiveCount {
+			if dlq := c.lookupARN(q.deadLetterARN); dlq != nil {
+				c.redrive(q, dlq, m, now)
+				continue
+			}
+		}
+		m.receiveCount++
+		if m.receiveCount == 1 {
+			m.firstReceiveAt = now
+		}
+		m.receipt = newReceipt(q.name, m.id)
+		m.visibleAt = now.Add(visibility)
+		out = append(out, m.toSDK(params))
+		kept = append(kept, m)
+	}
+	q.messages = kept
+	return out
+}
+
+// newReceipt encodes the queue and message a handle was issued for, so a
+// handle can be checked without keeping every one ever issued.
+func newReceipt(queueName, messageID string) string {
+	raw := queueName + "/" + messageID + "/" + uuid.New().String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseReceipt returns the message ID handle was issued for by q.
+func (q *queue) parseReceipt(handle string) (string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(handle)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(string(raw), "/")
+	if len(parts) != 3 || parts[0] != q.name || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// redrive moves m from q to dlq, as SQS does when a receive finds it past
+// maxReceiveCount. The message keeps its ID and send time.
+func (c *Client) redrive(q, dlq *queue, m *message, now time.Time) {
+	moved := *m
+	moved.receiveCount = 0
+	moved.firstReceiveAt = time.Time{}
+	moved.receipt = ""
+	moved.visibleAt = now
+	moved.sourceARN = q.arn
+	if dlq.fifo {
+		c.seq++
+		moved.seq = fmt.Sprintf("%020d", c.seq)
+	}
+	dlq.messages = append(dlq.messages, &moved)
+	c.notify()
+}
+
+func (m *message) inFlight(now time.Time) bool {
+	return m.receipt != "" && m.visibleAt.After(now)
+}
+
+// toSDK renders m as received, with the attributes params asked for.
+func (m *message) toSDK(params *sqs.ReceiveMessageInput) types.Message {
+	out := types.Message{
+		MessageId:     aws.String(m.id),
+		ReceiptHandle: aws.String(m.receipt),
+		Body:          aws.String(m.body),
+		MD5OfBody:     aws.String(m.md5Body),
+	}
+
+	system := map[string]string{
+		"SenderId":                         AccountID,
+		"SentTimestamp":                    strconv.FormatInt(m.sentAt.UnixMilli(), 10),
+		"ApproximateReceiveCount":          strconv.Itoa(m.receiveCount),
+		"ApproximateFirstReceiveTimestamp": strconv.FormatInt(m.firstReceiveAt.UnixMilli(), 10),
+	}
+	if m.groupID != "" {
+		system["MessageGroupId"] = m.groupID
+	}
+	if m.dedupeID != "" {
+		system["MessageDeduplicationId"] = m.dedupeID
+	}
+	if m.seq != "" {
+		system["SequenceNumber"] = m.seq
+	}
+	if m.sourceARN != "" {
+		system["DeadLetterQueueSourceArn"] = m.sourceARN
+	}
+	var wanted []string
+	for _, name := range params.AttributeNames {
+		wanted = append(wanted, string(name))
+	}
+	for _, name := range params.MessageSystemAttributeNames {
+		wanted = append(wanted, string(name))
+	}
+	for _, name := range wanted {
+		for key, value := range system {
+			if name == "All" || name == key {
+				if out.Attributes == nil {
+					out.Attributes = make(map[string]string)
+				}
+				out.Attributes[key] = value
+			}
+		}
+	}
+
+	for name, attr := range m.attrs {
+		if attributeWanted(name, params.MessageAttributeNames) {
+			if out.MessageAttributes == nil {
+				out.MessageAttributes = make(map[string]types.MessageAttributeValue)
+			}
+			out.MessageAttributes[name] = attr
+		}
+	}
+	if len(out.MessageAttributes) > 0 {
+		out.MD5OfMessageAttributes = aws.String(md5OfAttributes(out.MessageAttributes))
+	}
+	return out
+}
+
+// attributeWanted matches name against "All", ".*", "prefix.*" or exact
+// names.
+func attributeWanted(name string, wanted []string) bool {
+	for _, w := range wanted {
+		switch {
+		case w == "All" || w == ".*" || w == name:
+			return true
+		case strings.HasSuffix(w, ".*") && strings.HasPrefix(name, strings.TrimSuffix(w, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteMessage", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.delete(params.ReceiptHandle); err != nil {
+		return nil, err
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (c *Client) DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteMessageBatch", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]*string, len(params.Entries))
+	for i, e := range params.Entries {
+		ids[i] = e.Id
+	}
+	if err := checkBatch(ids); err != nil {
+		return nil, err
+	}
+
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, e := range params.Entries {
+		if err := q.delete(e.ReceiptHandle); err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, types.DeleteMessageBatchResultEntry{Id: e.Id})
+	}
+	return out, nil
+}
+
+// delete removes the message handle was issued for. Like SQS, a handle
+// from an earlier receive is accepted but deletes nothing.
+func (q *queue) delete(handle *string) error {
+	m, err := q.message(handle)
+	if err != nil {
+		return err
+	}
+	if m == nil || m.receipt != *handle {
+		return nil
+	}
+	for i, other := range q.messages {
+		if other == m {
+			q.messages = append(q.messages[:i:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	m.receipt = ""
+	return nil
+}
+
+// message returns the message handle was issued for, or nil if it has
+// been deleted, purged or redriven. Callers compare m.receipt with handle
+// to tell a stale handle from the current one.
+func (q *queue) message(handle *string) (*message, error) {
+	if handle == nil {
+		return nil, missingParameter("ReceiptHandle")
+	}
+	id, ok := q.parseReceipt(*handle)
+	if !ok {
+		return nil, &types.ReceiptHandleIsInvalid{Message: aws.String("The input receipt handle \"" + *handle + "\" is not a valid receipt handle.")}
+	}
+	for _, m := range q.messages {
+		if m.id == id {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ChangeMessageVisibility", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.changeVisibility(q, params.ReceiptHandle, params.VisibilityTimeout); err != nil {
+		return nil, err
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (c *Client) ChangeMessageVisibilityBatch(ctx context.Context, params *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin("ChangeMessageVisibilityBatch", params.QueueUrl); err != nil {
+		return nil, err
+	}
+	q, err := c.lookup(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]*string, len(params.Entries))
+	for i, e := range params.Entries {
+		ids[i] = e.Id
+	}
+	if err := checkBatch(ids); err != nil {
+		return nil, err
+	}
+
+	out := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, e := range params.Entries {
+		if err := c.changeVisibility(q, e.ReceiptHandle, e.VisibilityTimeout); err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, types.ChangeMessageVisibilityBatchResultEntry{Id: e.Id})
+	}
+	return out, nil
+}
+
+// changeVisibility makes an in-flight message receivable again timeout
+// seconds from now. c.mu must be held.
+func (c *Client) changeVisibility(q *queue, handle *string, timeout int32) error {
+	if timeout < 0 || time.Duration(timeout)*time.Second > maxVisibility {
+		return invalidParameter("Value %d for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and 43200.", timeout)
+	}
+	m, err := q.message(handle)
+	if err != nil {
+		return err
+	}
+	now := c.now()
+	if m == nil || m.receipt != *handle || !m.inFlight(now) {
+		return &types.MessageNotInflight{Message: aws.String("The message referred to isn't in flight.")}
+	}
+	m.visibleAt = now.Add(time.Duration(timeout) * time.Second)
+	if timeout == 0 {
+		c.notify()
+	}
+	return nil
+}
+
+// checkBatch validates the entry IDs of a batch request.
+func checkBatch(ids []*string) error {
+	if len(ids) == 0 {
+		return &types.EmptyBatchRequest{Message: aws.String("There should be at least one entry in the request.")}
+	}
+	if len(ids) > maxBatch {
+		return &types.TooManyEntriesInBatchRequest{Message: aws.String(fmt.Sprintf("Maximum number of entries per request are %d. You have sent %d.", maxBatch, len(ids)))}
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id == nil || !batchIDPattern.MatchString(*id) {
+			return &types.InvalidBatchEntryId{Message: aws.String("A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long.")}
+		}
+		if seen[*id] {
+			return &types.BatchEntryIdsNotDistinct{Message: aws.String("Id " + *id + " repeated.")}
+		}
+		seen[*id] = true
+	}
+	return nil
+}
+
+var batchIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,80}$`)
+
+func batchError(id *string, err error) types.BatchResultErrorEntry {
+	code := "InternalError"
+	senderFault := false
+	if apiErr, ok := err.(smithy.APIError); ok {
+		code = apiErr.ErrorCode()
+		senderFault = apiErr.ErrorFault() == smithy.FaultClient
+	}
+	return types.BatchResultErrorEntry{
+		Id:          id,
+		Code:        aws.String(code),
+		Message:     aws.String(err.Error()),
+		SenderFault: senderFault,
+	}
+}
+
+// Stats counts a queue's messages by state.
+type Stats struct {
+	Visible  int
+	InFlight int
+	Delayed  int
+}
+
+func (q *queue) stats(now time.Time) Stats {
+	var s Stats
+	for _, m := range q.messages {
+		switch {
+		case !m.visibleAt.After(now):
+			s.Visible++
+		case m.receipt != "":
+			s.InFlight++
+		default:
+			s.Delayed++
+		}
+	}
+	return s
+}
+
+// Stats reports the state of the queue at queueURL; an unknown queue has
+// no messages.
+func (c *Client) Stats(queueURL string) Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q, err := c.lookup(&queueURL)
+	if err != nil {
+		return Stats{}
+	}
+	return q.stats(c.now())
+}
+
+// MessageState is a snapshot of one message, for assertions.
+type MessageState struct {
+	ID           string
+	Body         string
+	Attributes   map[string]string
+	GroupID      string
+	ReceiveCount int
+	InFlight     bool
+	// SourceQueueARN is set on messages redriven from another queue.
+	SourceQueueARN string
+}
+
+// Messages lists the messages in the queue at queueURL, oldest first.
+func (c *Client) Messages(queueURL string) []MessageState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q, err := c.lookup(&queueURL)
+	if err != nil {
+		return nil
+	}
+	now := c.now()
+	out := make([]MessageState, 0, len(q.messages))
+	for _, m := range q.messages {
+		state := MessageState{
+			ID:             m.id,
+			Body:           m.body,
+			GroupID:        m.groupID,
+			ReceiveCount:   m.receiveCount,
+			InFlight:       m.inFlight(now),
+			SourceQueueARN: m.sourceARN,
+		}
+		for name, attr := range m.attrs {
+			if attr.StringValue != nil {
+				if state.Attributes == nil {
+					state.Attributes = make(map[string]string)
+				}
+				state.Attributes[name] = *attr.StringValue
+			}
+		}
+		out = append(out, state)
+	}
+	return out
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// md5OfAttributes computes MD5OfMessageAttributes the way SQS does: over
+// the attributes sorted by name, each encoded as length-prefixed name,
+// data type and value, with a transport byte before the value.
+func md5OfAttributes(attrs map[string]types.MessageAttributeValue) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := md5.New()
+	field := func(b []byte) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+		h.Write(n[:])
+		h.Write(b)
+	}
+	for _, name := range names {
+		attr := attrs[name]
+		field([]byte(name))
+		field([]byte(aws.ToString(attr.DataType)))
+		if attr.BinaryValue != nil {
+			h.Write([]byte{2})
+			field(attr.BinaryValue)
+		} else {
+			h.Write([]byte{1})
+			field([]byte(aws.ToString(attr.StringValue)))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
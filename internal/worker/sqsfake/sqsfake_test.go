@@ -0,0 +1,397 @@
+package sqsfake_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"go-sqs-worker/internal/worker"
+	"go-sqs-worker/internal/worker/sqsfake"
+)
+
+var (
+	_ worker.SQSClient = (*sqsfake.Client)(nil)
+	_ worker.SQSSender = (*sqsfake.Client)(nil)
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newFake() (*sqsfake.Client, *clock) {
+	clk := &clock{now: time.Unix(1700000000, 0)}
+	return sqsfake.New().WithClock(clk.Now), clk
+}
+
+func send(t *testing.T, c *sqsfake.Client, queueURL, body string) string {
+	t.Helper()
+	out, err := c.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: &queueURL, MessageBody: &body})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	return *out.MessageId
+}
+
+func receive(t *testing.T, c *sqsfake.Client, queueURL string, max int32) []types.Message {
+	t.Helper()
+	out, err := c.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:                    &queueURL,
+		MaxNumberOfMessages:         max,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll},
+	})
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return out.Messages
+}
+
+func receiveCount(m types.Message) int {
+	n, _ := strconv.Atoi(m.Attributes["ApproximateReceiveCount"])
+	return n
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	c, clk := newFake()
+	url := c.MustCreateQueue("jobs", map[string]string{"VisibilityTimeout": "30"})
+	id := send(t, c, url, "hello")
+
+	first := receive(t, c, url, 10)
+	if len(first) != 1 || *first[0].MessageId != id || receiveCount(first[0]) != 1 {
+		t.Fatalf("unexpected first receive: %+v", first)
+	}
+	if got := c.Stats(url); got.InFlight != 1 || got.Visible != 0 {
+		t.Fatalf("expected message in flight, got %+v", got)
+	}
+
+	clk.Advance(29 * time.Second)
+	if msgs := receive(t, c, url, 10); len(msgs) != 0 {
+		t.Fatal("expected message hidden within the visibility timeout")
+	}
+	clk.Advance(time.Second)
+	second := receive(t, c, url, 10)
+	if len(second) != 1 || receiveCount(second[0]) != 2 || *second[0].ReceiptHandle == *first[0].ReceiptHandle {
+		t.Fatalf("expected redelivery with a new handle and receive count 2, got %+v", second)
+	}
+
+	// The first receive's handle no longer deletes, as in SQS
+	ctx := context.Background()
+	if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: first[0].ReceiptHandle}); err != nil {
+		t.Fatalf("stale delete: %v", err)
+	}
+	if len(c.Messages(url)) != 1 {
+		t.Fatal("expected stale handle not to delete the message")
+	}
+	if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: second[0].ReceiptHandle}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(c.Messages(url)) != 0 {
+		t.Fatal("expected message deleted")
+	}
+	// Deleting again is accepted, though nothing keeps the handle around
+	if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: second[0].ReceiptHandle}); err != nil {
+		t.Fatalf("repeated delete: %v", err)
+	}
+
+	var invalid *types.ReceiptHandleIsInvalid
+	if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: aws.String("bogus")}); !errors.As(err, &invalid) {
+		t.Fatalf("expected ReceiptHandleIsInvalid, got %v", err)
+	}
+	// A handle is only valid on the queue that issued it
+	other := c.MustCreateQueue("other", nil)
+	if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &other, ReceiptHandle: second[0].ReceiptHandle}); !errors.As(err, &invalid) {
+		t.Fatalf("expected ReceiptHandleIsInvalid from another queue, got %v", err)
+	}
+}
+
+func TestChangeMessageVisibility(t *testing.T) {
+	c, clk := newFake()
+	url := c.MustCreateQueue("jobs", nil)
+	ctx := context.Background()
+	send(t, c, url, "hello")
+
+	msg := receive(t, c, url, 1)[0]
+	if _, err := c.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: &url, ReceiptHandle: msg.ReceiptHandle, VisibilityTimeout: 120}); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	clk.Advance(time.Minute)
+	if msgs := receive(t, c, url, 1); len(msgs) != 0 {
+		t.Fatal("expected extended message to stay hidden")
+	}
+
+	if _, err := c.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: &url, ReceiptHandle: msg.ReceiptHandle, VisibilityTimeout: 0}); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	again := receive(t, c, url, 1)
+	if len(again) != 1 {
+		t.Fatal("expected released message back immediately")
+	}
+
+	// Only the latest receipt of an in-flight message can change it
+	var notInflight *types.MessageNotInflight
+	_, err := c.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: &url, ReceiptHandle: msg.ReceiptHandle, VisibilityTimeout: 10})
+	if !errors.As(err, &notInflight) {
+		t.Fatalf("expected MessageNotInflight for stale handle, got %v", err)
+	}
+}
+
+func TestRedriveAfterMaxReceiveCount(t *testing.T) {
+	c, clk := newFake()
+	dlq := c.MustCreateQueue("jobs-dlq", nil)
+	url := c.MustCreateQueue("jobs", map[string]string{
+		"VisibilityTimeout": "10",
+		"RedrivePolicy":     `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:jobs-dlq","maxReceiveCount":"2"}`,
+	})
+	id := send(t, c, url, "poison")
+
+	for i := 1; i <= 2; i++ {
+		msgs := receive(t, c, url, 1)
+		if len(msgs) != 1 || receiveCount(msgs[0]) != i {
+			t.Fatalf("receive %d: unexpected %+v", i, msgs)
+		}
+		clk.Advance(10 * time.Second)
+	}
+	if msgs := receive(t, c, url, 1); len(msgs) != 0 {
+		t.Fatal("expected message redriven instead of a third delivery")
+	}
+
+	moved := c.Messages(dlq)
+	if len(moved) != 1 || moved[0].ID != id || moved[0].SourceQueueARN != "arn:aws:sqs:us-east-1:000000000000:jobs" {
+		t.Fatalf("expected message in the DLQ, got %+v", moved)
+	}
+}
+
+func TestFIFOGroupLocking(t *testing.T) {
+	c, clk := newFake()
+	url := c.MustCreateQueue("jobs.fifo", map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"})
+	ctx := context.Background()
+	for _, m := range []struct{ group, body string }{{"a", "a1"}, {"a", "a2"}, {"b", "b1"}} {
+		if _, err := c.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: &url, MessageBody: aws.String(m.body), MessageGroupId: aws.String(m.group)}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	first := receive(t, c, url, 1)
+	if len(first) != 1 || *first[0].Body != "a1" {
+		t.Fatalf("expected a1 first, got %+v", first)
+	}
+	// Group a is locked while a1 is in flight
+	next := receive(t, c, url, 10)
+	if len(next) != 1 || *next[0].Body != "b1" {
+		t.Fatalf("expected only b1 while group a is locked, got %+v", next)
+	}
+	_, _ = c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: next[0].ReceiptHandle})
+
+	clk.Advance(30 * time.Second)
+	// a1 is visible again and still comes before a2
+	again := receive(t, c, url, 1)
+	if len(again) != 1 || *again[0].Body != "a1" {
+		t.Fatalf("expected a1 redelivered first, got %+v", again)
+	}
+	_, _ = c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: again[0].ReceiptHandle})
+	last := receive(t, c, url, 10)
+	if len(last) != 1 || *last[0].Body != "a2" {
+		t.Fatalf("expected a2 once a1 is deleted, got %+v", last)
+	}
+}
+
+func TestFIFODeduplication(t *testing.T) {
+	c, clk := newFake()
+	url := c.MustCreateQueue("jobs.fifo", map[string]string{"FifoQueue": "true"})
+	ctx := context.Background()
+	in := &sqs.SendMessageInput{QueueUrl: &url, MessageBody: aws.String("x"), MessageGroupId: aws.String("g"), MessageDeduplicationId: aws.String("d")}
+
+	first, err := c.SendMessage(ctx, in)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	dup, err := c.SendMessage(ctx, in)
+	if err != nil || *dup.MessageId != *first.MessageId {
+		t.Fatalf("expected duplicate to return the original ID, got %v (err %v)", dup, err)
+	}
+	if n := len(c.Messages(url)); n != 1 {
+		t.Fatalf("expected 1 message, got %d", n)
+	}
+
+	clk.Advance(5 * time.Minute)
+	if _, err := c.SendMessage(ctx, in); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if n := len(c.Messages(url)); n != 2 {
+		t.Fatalf("expected a new message after the deduplication interval, got %d", n)
+	}
+
+	in.MessageDeduplicationId = nil
+	if _, err := c.SendMessage(ctx, in); err == nil {
+		t.Fatal("expected error without a deduplication ID or content-based deduplication")
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	c, _ := newFake()
+	url := c.MustCreateQueue("jobs", nil)
+	ctx := context.Background()
+
+	out, err := c.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: &url, Entries: []types.SendMessageBatchRequestEntry{
+		{Id: aws.String("0"), MessageBody: aws.String("one")},
+		{Id: aws.String("1"), MessageBody: aws.String("")},
+		{Id: aws.String("2"), MessageBody: aws.String("three")},
+	}})
+	if err != nil {
+		t.Fatalf("send batch: %v", err)
+	}
+	if len(out.Successful) != 2 || len(out.Failed) != 1 || *out.Failed[0].Id != "1" || !out.Failed[0].SenderFault {
+		t.Fatalf("expected the empty entry to fail alone, got %+v", out)
+	}
+
+	var dupIDs *types.BatchEntryIdsNotDistinct
+	_, err = c.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: &url, Entries: []types.SendMessageBatchRequestEntry{
+		{Id: aws.String("a"), MessageBody: aws.String("x")},
+		{Id: aws.String("a"), MessageBody: aws.String("y")},
+	}})
+	if !errors.As(err, &dupIDs) {
+		t.Fatalf("expected BatchEntryIdsNotDistinct, got %v", err)
+	}
+
+	msgs := receive(t, c, url, 10)
+	vis, err := c.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: &url, Entries: []types.ChangeMessageVisibilityBatchRequestEntry{
+		{Id: aws.String("0"), ReceiptHandle: msgs[0].ReceiptHandle, VisibilityTimeout: 0},
+		{Id: aws.String("1"), ReceiptHandle: aws.String("bogus")},
+	}})
+	if err != nil || len(vis.Successful) != 1 || len(vis.Failed) != 1 || *vis.Failed[0].Code != "ReceiptHandleIsInvalid" {
+		t.Fatalf("unexpected visibility batch result %+v (err %v)", vis, err)
+	}
+
+	del, err := c.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{QueueUrl: &url, Entries: []types.DeleteMessageBatchRequestEntry{
+		{Id: aws.String("0"), ReceiptHandle: msgs[1].ReceiptHandle},
+	}})
+	if err != nil || len(del.Successful) != 1 {
+		t.Fatalf("unexpected delete batch result %+v (err %v)", del, err)
+	}
+	if got := c.Stats(url); got.Visible != 1 || got.InFlight != 0 {
+		t.Fatalf("expected one released message left, got %+v", got)
+	}
+}
+
+func TestQueueAttributesAndPurge(t *testing.T) {
+	c, _ := newFake()
+	url := c.MustCreateQueue("jobs", map[string]string{"DelaySeconds": "5"})
+	ctx := context.Background()
+	send(t, c, url, "later")
+
+	out, err := c.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: &url, AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameAll}})
+	if err != nil {
+		t.Fatalf("attributes: %v", err)
+	}
+	if out.Attributes["ApproximateNumberOfMessagesDelayed"] != "1" || out.Attributes["DelaySeconds"] != "5" || out.Attributes["QueueArn"] == "" {
+		t.Fatalf("unexpected attributes %v", out.Attributes)
+	}
+
+	if _, err := c.PurgeQueue(ctx, &sqs.PurgeQueueInput{QueueUrl: &url}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if len(c.Messages(url)) != 0 {
+		t.Fatal("expected queue purged")
+	}
+
+	got, err := c.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String("jobs")})
+	if err != nil || *got.QueueUrl != url {
+		t.Fatalf("expected %s, got %v (err %v)", url, got, err)
+	}
+	var missing *types.QueueDoesNotExist
+	if _, err := c.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String("nope")}); !errors.As(err, &missing) {
+		t.Fatalf("expected QueueDoesNotExist, got %v", err)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	c, _ := newFake()
+	url := c.MustCreateQueue("jobs", nil)
+	ctx := context.Background()
+	send(t, c, url, "hello")
+
+	boom := errors.New("boom")
+	c.InjectFault(sqsfake.Fault{Op: "ReceiveMessage", Err: boom, Times: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := c.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &url}); !errors.Is(err, boom) {
+			t.Fatalf("call %d: expected injected error, got %v", i, err)
+		}
+	}
+	msgs := receive(t, c, url, 1)
+	if len(msgs) != 1 || receiveCount(msgs[0]) != 1 {
+		t.Fatal("expected the fault to run out without consuming a receive")
+	}
+
+	c.InjectFault(sqsfake.Fault{Op: "DeleteMessage", Err: boom})
+	for i := 0; i < 3; i++ {
+		if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: msgs[0].ReceiptHandle}); !errors.Is(err, boom) {
+			t.Fatalf("expected persistent fault, got %v", err)
+		}
+	}
+	c.ClearFaults()
+	if _, err := c.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &url, ReceiptHandle: msgs[0].ReceiptHandle}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if n := c.Calls("ReceiveMessage"); n != 3 {
+		t.Fatalf("expected 3 receive calls, got %d", n)
+	}
+}
+
+func TestLongPollWakesOnSend(t *testing.T) {
+	c, _ := newFake()
+	url := c.MustCreateQueue("jobs", nil)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = c.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: &url, MessageBody: aws.String("late")})
+	}()
+	start := time.Now()
+	out, err := c.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{QueueUrl: &url, WaitTimeSeconds: 5})
+	if err != nil || len(out.Messages) != 1 {
+		t.Fatalf("expected the late message, got %v (err %v)", out, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expected the long poll to return as soon as the message arrived")
+	}
+}
+
+func TestMessageAttributeMD5(t *testing.T) {
+	c, _ := newFake()
+	url := c.MustCreateQueue("jobs", nil)
+	out, err := c.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl:    &url,
+		MessageBody: aws.String("hello"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("a")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// Known values for body "hello" and attribute tenant=a
+	if *out.MD5OfMessageBody != "5d41402abc4b2a76b9719d911017c592" {
+		t.Fatalf("unexpected body MD5 %s", *out.MD5OfMessageBody)
+	}
+	if out.MD5OfMessageAttributes == nil || len(*out.MD5OfMessageAttributes) != 32 {
+		t.Fatalf("expected an attributes MD5, got %v", out.MD5OfMessageAttributes)
+	}
+}
@@ -0,0 +1,118 @@
+package workertest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual worker.Clock. Time only moves when Advance is called.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers []*timer
+}
+
+type timer struct {
+	at  time.Time
+	seq int
+	ch  chan time.Time
+	fn  func()
+}
+
+// NewClock returns a clock stopped at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements worker.Clock.
+func (c *Clock) After(d time.Duration) (<-chan time.Time, func() bool) {
+	t := &timer{ch: make(chan time.Time, 1)}
+	return t.ch, c.schedule(t, d)
+}
+
+// AfterFunc calls fn once d has passed, on the goroutine calling Advance.
+// The returned func cancels it and reports whether it was still pending.
+func (c *Clock) AfterFunc(d time.Duration, fn func()) func() bool {
+	return c.schedule(&timer{fn: fn}, d)
+}
+
+func (c *Clock) schedule(t *timer, d time.Duration) func() bool {
+	c.mu.Lock()
+	c.seq++
+	t.at, t.seq = c.now.Add(d), c.seq
+	if d <= 0 {
+		c.mu.Unlock()
+		c.fire(t)
+		return func() bool { return false }
+	}
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	return func() bool { return c.cancel(t) }
+}
+
+func (c *Clock) cancel(t *timer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Clock) fire(t *timer) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	t.ch <- t.at
+}
+
+// Advance moves the clock forward by d, firing the timers that come due in
+// order of their deadlines. Goroutines woken by a timer run concurrently
+// with the rest of Advance, so a timer they set afterwards starts from
+// wherever the clock has got to; advance in steps no longer than the
+// intervals under test.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		sort.Slice(c.timers, func(i, j int) bool {
+			if !c.timers[i].at.Equal(c.timers[j].at) {
+				return c.timers[i].at.Before(c.timers[j].at)
+			}
+			return c.timers[i].seq < c.timers[j].seq
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(target) {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+		c.mu.Unlock()
+		c.fire(t)
+	}
+}
+
+// Timers reports how many timers are pending, so a test can wait for the
+// Runner to start waiting before advancing.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
@@ -0,0 +1,48 @@
+package workertest
+
+import (
+	"context"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+)
+
+// LeaseStore is a worker.MemoryLeaseStore on a Clock that records every
+// call in the Log. It implements worker.CompletionStore, so it also works
+// with Runner.WithIdempotency.
+type LeaseStore struct {
+	store *worker.MemoryLeaseStore
+	log   *Log
+}
+
+func NewLeaseStore(clock *Clock, log *Log) *LeaseStore {
+	return &LeaseStore{store: worker.NewMemoryLeaseStore().WithClock(clock.Now), log: log}
+}
+
+func (s *LeaseStore) Acquire(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token, ok, err := s.store.Acquire(ctx, key, ttl)
+	s.log.add(Event{Kind: EventLeaseAcquired, Key: key, Delay: ttl, Stale: !ok, Err: err})
+	return token, ok, err
+}
+
+func (s *LeaseStore) Release(ctx context.Context, key string, token string) error {
+	err := s.store.Release(ctx, key, token)
+	s.log.add(Event{Kind: EventLeaseReleased, Key: key, Err: err})
+	return err
+}
+
+func (s *LeaseStore) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	ok, err := s.store.Extend(ctx, key, token, ttl)
+	s.log.add(Event{Kind: EventLeaseExtended, Key: key, Delay: ttl, Stale: !ok, Err: err})
+	return ok, err
+}
+
+func (s *LeaseStore) Completed(ctx context.Context, key string) (bool, error) {
+	return s.store.Completed(ctx, key)
+}
+
+func (s *LeaseStore) Complete(ctx context.Context, key string, token string, retention time.Duration) error {
+	err := s.store.Complete(ctx, key, token, retention)
+	s.log.add(Event{Kind: EventLeaseCompleted, Key: key, Delay: retention, Err: err})
+	return err
+}
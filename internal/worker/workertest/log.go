@@ -0,0 +1,119 @@
+package workertest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventSent     EventKind = "sent"
+	EventReceived EventKind = "received"
+	EventAcked    EventKind = "acked"
+	EventNacked   EventKind = "nacked"
+	// EventExtended is a visibility change requested through Source.Extend.
+	EventExtended EventKind = "extended"
+	// EventExpired is a message becoming visible again after its visibility
+	// timeout or nack delay ran out.
+	EventExpired EventKind = "expired"
+	// EventFailed is a handler error reported to the source.
+	EventFailed EventKind = "failed"
+
+	EventLeaseAcquired  EventKind = "lease_acquired"
+	EventLeaseExtended  EventKind = "lease_extended"
+	EventLeaseReleased  EventKind = "lease_released"
+	EventLeaseCompleted EventKind = "lease_completed"
+)
+
+// Event is one recorded source or lease store call. Only the fields
+// relevant to Kind are set.
+type Event struct {
+	Kind      EventKind
+	MessageID string
+	// Key is the lease key, for lease events.
+	Key string
+	// ReceiveCount is the delivery number, for EventReceived.
+	ReceiveCount int
+	// Delay is the nack delay or new visibility timeout.
+	Delay time.Duration
+	// Stale is set when the receipt handle no longer held the message, or
+	// when a lease call found the lease gone or held by someone else.
+	Stale bool
+	Err   error
+	// At is the Clock's time when the event was recorded.
+	At time.Time
+}
+
+func (e Event) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.At.Format("15:04:05.000"), e.Kind)
+	if e.MessageID != "" {
+		fmt.Fprintf(&b, " %s", e.MessageID)
+	}
+	if e.Key != "" {
+		fmt.Fprintf(&b, " key=%s", e.Key)
+	}
+	if e.ReceiveCount > 0 {
+		fmt.Fprintf(&b, " count=%d", e.ReceiveCount)
+	}
+	if e.Delay > 0 {
+		fmt.Fprintf(&b, " delay=%v", e.Delay)
+	}
+	if e.Stale {
+		b.WriteString(" stale")
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, " err=%v", e.Err)
+	}
+	return b.String()
+}
+
+// Log is the shared, ordered record of what a Source and LeaseStore saw.
+type Log struct {
+	clock *Clock
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewLog(clock *Clock) *Log {
+	return &Log{clock: clock}
+}
+
+func (l *Log) add(e Event) {
+	e.At = l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+// Events returns a copy of everything recorded so far.
+func (l *Log) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
+
+// Filter returns the events of kind for messageID, or for every message if
+// messageID is empty. Lease events match on Key.
+func (l *Log) Filter(kind EventKind, messageID string) []Event {
+	var out []Event
+	for _, e := range l.Events() {
+		if e.Kind == kind && (messageID == "" || e.MessageID == messageID || e.Key == messageID) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (l *Log) String() string {
+	var b strings.Builder
+	for _, e := range l.Events() {
+		b.WriteString(e.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
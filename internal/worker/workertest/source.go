@@ -0,0 +1,207 @@
+package workertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+)
+
+// Source is an in-memory worker.Source whose visibility timeouts run on a
+// Clock. Like SQS, a received message that isn't acked before its
+// visibility runs out is delivered again under a new receipt handle. Every
+// call is recorded in the Log.
+type Source struct {
+	clock      *Clock
+	log        *Log
+	visibility time.Duration
+
+	mu      sync.Mutex
+	seq     int
+	visible []*worker.Message
+	// inFlight maps receipt handles to the message and its redelivery timer
+	inFlight map[string]*inFlight
+	// wake is closed when a message becomes visible
+	wake chan struct{}
+}
+
+type inFlight struct {
+	msg  *worker.Message
+	stop func() bool
+}
+
+// NewSource creates an empty source with a 30s visibility timeout.
+func NewSource(clock *Clock, log *Log) *Source {
+	return &Source{
+		clock:      clock,
+		log:        log,
+		visibility: 30 * time.Second,
+		inFlight:   make(map[string]*inFlight),
+		wake:       make(chan struct{}),
+	}
+}
+
+// WithVisibilityTimeout sets how long a received message stays hidden.
+func (s *Source) WithVisibilityTimeout(d time.Duration) *Source {
+	s.visibility = d
+	return s
+}
+
+// Send enqueues a message and returns its ID, "msg-1", "msg-2" and so on.
+func (s *Source) Send(body string, attrs map[string]string) string {
+	s.mu.Lock()
+	s.seq++
+	msg := &worker.Message{MessageID: fmt.Sprintf("msg-%d", s.seq), Body: body, Attributes: attrs}
+	s.mu.Unlock()
+
+	s.log.add(Event{Kind: EventSent, MessageID: msg.MessageID})
+	s.makeVisible(msg)
+	return msg.MessageID
+}
+
+// Visible reports how many messages are waiting to be received.
+func (s *Source) Visible() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.visible)
+}
+
+// InFlight reports how many messages are received and not yet acked or
+// visible again.
+func (s *Source) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inFlight)
+}
+
+func (s *Source) makeVisible(msg *worker.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.visible = append(s.visible, msg)
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// Receive waits until a message is visible; it never returns empty.
+func (s *Source) Receive(ctx context.Context, max int) ([]*worker.Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.visible) > 0 {
+			n := min(max, len(s.visible))
+			msgs := make([]*worker.Message, n)
+			for i, msg := range s.visible[:n] {
+				msgs[i] = s.deliver(msg)
+			}
+			s.visible = s.visible[n:]
+			s.mu.Unlock()
+			for _, msg := range msgs {
+				s.log.add(Event{Kind: EventReceived, MessageID: msg.MessageID, ReceiveCount: msg.ReceiveCount})
+				s.schedule(handle(msg), s.visibility)
+			}
+			return msgs, nil
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// deliver hands out msg under a new receipt handle. Callers hold s.mu.
+func (s *Source) deliver(msg *worker.Message) *worker.Message {
+	msg.ReceiveCount++
+	handle := fmt.Sprintf("%s/%d", msg.MessageID, msg.ReceiveCount)
+	delivered := *msg
+	delivered.ReceiptHandle = &handle
+	s.inFlight[handle] = &inFlight{msg: msg, stop: func() bool { return false }}
+	return &delivered
+}
+
+// schedule makes the message under h visible again after d. It is called
+// without s.mu held, since a timer that is already due fires at once.
+func (s *Source) schedule(h string, d time.Duration) {
+	stop := s.clock.AfterFunc(d, func() { s.expire(h) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.inFlight[h]; ok {
+		f.stop = stop
+	}
+}
+
+// expire makes the message under handle visible again.
+func (s *Source) expire(handle string) {
+	s.mu.Lock()
+	f, ok := s.inFlight[handle]
+	delete(s.inFlight, handle)
+	s.mu.Unlock()
+
+	if ok {
+		s.log.add(Event{Kind: EventExpired, MessageID: f.msg.MessageID})
+		s.makeVisible(f.msg)
+	}
+}
+
+// reset moves the redelivery of msg to d from now. It reports false if
+// msg's handle is stale.
+func (s *Source) reset(msg *worker.Message, d time.Duration) bool {
+	s.mu.Lock()
+	f, ok := s.inFlight[handle(msg)]
+	if ok {
+		f.stop()
+	}
+	s.mu.Unlock()
+	if ok {
+		s.schedule(handle(msg), d)
+	}
+	return ok
+}
+
+func handle(msg *worker.Message) string {
+	if msg.ReceiptHandle == nil {
+		return ""
+	}
+	return *msg.ReceiptHandle
+}
+
+func (s *Source) Ack(ctx context.Context, msg *worker.Message) error {
+	s.mu.Lock()
+	f, ok := s.inFlight[handle(msg)]
+	if ok {
+		f.stop()
+		delete(s.inFlight, handle(msg))
+	}
+	s.mu.Unlock()
+
+	s.log.add(Event{Kind: EventAcked, MessageID: msg.MessageID, Stale: !ok})
+	return nil
+}
+
+func (s *Source) Nack(ctx context.Context, msg *worker.Message, delay time.Duration) error {
+	ok := s.reset(msg, delay)
+	s.log.add(Event{Kind: EventNacked, MessageID: msg.MessageID, Delay: delay, Stale: !ok})
+	return nil
+}
+
+func (s *Source) Extend(ctx context.Context, msg *worker.Message, d time.Duration) error {
+	ok := s.reset(msg, d)
+	s.log.add(Event{Kind: EventExtended, MessageID: msg.MessageID, Delay: d, Stale: !ok})
+	return nil
+}
+
+// Failed records a handler error; the message stays in flight until its
+// visibility runs out.
+func (s *Source) Failed(ctx context.Context, msg *worker.Message, err error) error {
+	s.log.add(Event{Kind: EventFailed, MessageID: msg.MessageID, Err: err})
+	return nil
+}
+
+// LongPolling reports true: Receive waits for messages.
+func (s *Source) LongPolling() bool {
+	return true
+}
@@ -0,0 +1,198 @@
+// Package workertest runs a worker.Runner against an in-memory source and
+// lease store on a virtual clock, so timeouts, redelivery, lease renewal and
+// shutdown drain can be tested without waiting on real time. Every source
+// and lease store call is recorded in a Log to assert against.
+//
+// Handlers and the Runner still run on their own goroutines; tests wait for
+// them with WaitFor and WaitForTimers before advancing the clock.
+package workertest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker"
+)
+
+// Wait bounds, in real time, how long WaitFor, WaitForTimers, Stop and the
+// Expect helpers wait for the Runner's goroutines to catch up.
+var Wait = 5 * time.Second
+
+// Harness wires a Source, LeaseStore and Log to one Clock.
+type Harness struct {
+	t testing.TB
+
+	Clock  *Clock
+	Log    *Log
+	Source *Source
+	Leases *LeaseStore
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// New returns a harness whose clock starts at a fixed time. A Runner
+// started with Start is stopped when the test ends.
+func New(t testing.TB) *Harness {
+	clock := NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	log := NewLog(clock)
+	h := &Harness{
+		t:      t,
+		Clock:  clock,
+		Log:    log,
+		Source: NewSource(clock, log),
+		Leases: NewLeaseStore(clock, log),
+	}
+	t.Cleanup(func() {
+		if h.cancel != nil {
+			h.Stop()
+		}
+		if t.Failed() {
+			t.Logf("event log:\n%s", log)
+		}
+	})
+	return h
+}
+
+// Runner returns a Runner reading from h.Source on h.Clock. Chain further
+// options, such as WithLeaseStore(h.Leases, ttl), before calling Start.
+func (h *Harness) Runner(handler worker.Handler, maxInFlight, concurrency int) *worker.Runner {
+	return worker.NewRunner(h.Source, handler, maxInFlight, concurrency).WithClock(h.Clock)
+}
+
+// Start runs r in the background until Stop.
+func (h *Harness) Start(r *worker.Runner) {
+	h.t.Helper()
+	if h.cancel != nil {
+		h.t.Fatal("workertest: runner already started")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	h.done = make(chan struct{})
+	go func() {
+		defer close(h.done)
+		h.err = r.Run(ctx)
+	}()
+}
+
+// Shutdown cancels the Runner's context without waiting for it to drain.
+func (h *Harness) Shutdown() {
+	h.cancel()
+}
+
+// Stopped reports whether Run has returned.
+func (h *Harness) Stopped() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop cancels the Runner and waits for Run to return, failing the test if
+// it doesn't within Wait. It returns Run's error.
+func (h *Harness) Stop() error {
+	h.t.Helper()
+	h.cancel()
+	select {
+	case <-h.done:
+		return h.err
+	case <-time.After(Wait):
+		h.t.Fatalf("workertest: runner did not stop within %v", Wait)
+		return nil
+	}
+}
+
+// Send enqueues a message on h.Source and returns its ID.
+func (h *Harness) Send(body string) string {
+	return h.Source.Send(body, nil)
+}
+
+// Advance moves h.Clock forward by d.
+func (h *Harness) Advance(d time.Duration) {
+	h.Clock.Advance(d)
+}
+
+// WaitFor waits until the log has n events of kind for messageID and
+// returns the last of them.
+func (h *Harness) WaitFor(kind EventKind, messageID string, n int) Event {
+	h.t.Helper()
+	var events []Event
+	h.eventually(func() bool {
+		events = h.Log.Filter(kind, messageID)
+		return len(events) >= n
+	}, func() string {
+		return fmt.Sprintf("%d %s events for %s, got %d", n, kind, messageID, len(events))
+	})
+	return events[n-1]
+}
+
+// WaitForTimers waits until at least n timers are pending on h.Clock, i.e.
+// the Runner and source are blocked on the clock.
+func (h *Harness) WaitForTimers(n int) {
+	h.t.Helper()
+	h.eventually(func() bool { return h.Clock.Timers() >= n }, func() string {
+		return fmt.Sprintf("%d pending timers, got %d", n, h.Clock.Timers())
+	})
+}
+
+// ExpectAcked waits until messageID is acked under a current receipt handle.
+func (h *Harness) ExpectAcked(messageID string) {
+	h.t.Helper()
+	h.eventually(func() bool {
+		for _, e := range h.Log.Filter(EventAcked, messageID) {
+			if !e.Stale {
+				return true
+			}
+		}
+		return false
+	}, func() string { return messageID + " to be acked" })
+}
+
+// ExpectNotAcked fails if messageID has been acked.
+func (h *Harness) ExpectNotAcked(messageID string) {
+	h.t.Helper()
+	if events := h.Log.Filter(EventAcked, messageID); len(events) > 0 {
+		h.t.Fatalf("expected %s not to be acked, acked at %v", messageID, events[0].At)
+	}
+}
+
+// ExpectRedelivered waits for messageID's delivery after n redeliveries
+// and fails if it was delivered more often than that.
+func (h *Harness) ExpectRedelivered(messageID string, n int) {
+	h.t.Helper()
+	h.WaitFor(EventReceived, messageID, n+1)
+	if got := len(h.Log.Filter(EventReceived, messageID)); got != n+1 {
+		h.t.Fatalf("expected %s redelivered %d times, got %d", messageID, n, got-1)
+	}
+}
+
+// ExpectNacked waits until messageID is released with delay.
+func (h *Harness) ExpectNacked(messageID string, delay time.Duration) {
+	h.t.Helper()
+	h.eventually(func() bool {
+		for _, e := range h.Log.Filter(EventNacked, messageID) {
+			if e.Delay == delay {
+				return true
+			}
+		}
+		return false
+	}, func() string { return fmt.Sprintf("%s to be nacked with delay %v", messageID, delay) })
+}
+
+// eventually polls cond until it holds or Wait has passed, then fails
+// with what describes.
+func (h *Harness) eventually(cond func() bool, what func() string) {
+	h.t.Helper()
+	deadline := time.Now().Add(Wait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("workertest: timed out waiting for %s", what())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
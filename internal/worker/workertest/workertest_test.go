@@ -0,0 +1,74 @@
+package workertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-sqs-worker/internal/worker/workertest"
+)
+
+func TestClockFiresInOrder(t *testing.T) {
+	clock := workertest.NewClock(time.Unix(0, 0))
+	var fired []int
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	stop := clock.AfterFunc(time.Second, func() { fired = append(fired, 3) })
+	ch, _ := clock.After(3 * time.Second)
+
+	if !stop() {
+		t.Fatal("expected a pending timer to stop")
+	}
+	clock.Advance(2 * time.Second)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 2 {
+		t.Fatalf("expected timers 1 and 2 in order, got %v", fired)
+	}
+	select {
+	case <-ch:
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if at := <-ch; !at.Equal(time.Unix(3, 0)) {
+		t.Fatalf("expected the timer at 3s, got %v", at)
+	}
+	if clock.Timers() != 0 {
+		t.Fatalf("expected no pending timers, got %d", clock.Timers())
+	}
+}
+
+func TestSourceVisibility(t *testing.T) {
+	clock := workertest.NewClock(time.Unix(0, 0))
+	log := workertest.NewLog(clock)
+	src := workertest.NewSource(clock, log).WithVisibilityTimeout(30 * time.Second)
+	ctx := context.Background()
+
+	id := src.Send("a", nil)
+	msgs, _ := src.Receive(ctx, 10)
+	if len(msgs) != 1 || msgs[0].MessageID != id || msgs[0].ReceiveCount != 1 {
+		t.Fatalf("unexpected receive %+v", msgs)
+	}
+	stale := msgs[0]
+
+	clock.Advance(30 * time.Second)
+	msgs, _ = src.Receive(ctx, 10)
+	if len(msgs) != 1 || msgs[0].ReceiveCount != 2 {
+		t.Fatalf("expected redelivery after the visibility timeout, got %+v", msgs)
+	}
+
+	_ = src.Ack(ctx, stale)
+	_ = src.Nack(ctx, msgs[0], 5*time.Second)
+	clock.Advance(5 * time.Second)
+	if src.Visible() != 1 {
+		t.Fatal("expected the nacked message visible after its delay")
+	}
+
+	acks := log.Filter(workertest.EventAcked, id)
+	if len(acks) != 1 || !acks[0].Stale {
+		t.Fatalf("expected one stale ack, got %v", acks)
+	}
+	if n := len(log.Filter(workertest.EventExpired, id)); n != 2 {
+		t.Fatalf("expected 2 expiries, got %d", n)
+	}
+}